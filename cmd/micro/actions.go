@@ -520,7 +520,7 @@ func (v *View) ParagraphPrevious(usePlugin bool) bool {
 	}
 	var line int
 	for line = v.Cursor.Y; line > 0; line-- {
-		if len(v.Buf.lines[line].data) == 0 && line != v.Cursor.Y {
+		if len(v.Buf.LineBytes(line)) == 0 && line != v.Cursor.Y {
 			v.Cursor.X = 0
 			v.Cursor.Y = line
 			break
@@ -544,15 +544,15 @@ func (v *View) ParagraphNext(usePlugin bool) bool {
 	}
 
 	var line int
-	for line = v.Cursor.Y; line < len(v.Buf.lines); line++ {
-		if len(v.Buf.lines[line].data) == 0 && line != v.Cursor.Y {
+	for line = v.Cursor.Y; line < v.Buf.LinesNum(); line++ {
+		if len(v.Buf.LineBytes(line)) == 0 && line != v.Cursor.Y {
 			v.Cursor.X = 0
 			v.Cursor.Y = line
 			break
 		}
 	}
 	// If no empty line found. move cursor to end of buffer
-	if line == len(v.Buf.lines) {
+	if line == v.Buf.LinesNum() {
 		v.Cursor.Loc = v.Buf.End()
 	}
 
@@ -586,7 +586,7 @@ func (v *View) Retab(usePlugin bool) bool {
 		}
 
 		l = strings.TrimLeft(l, " \t")
-		v.Buf.lines[i].data = []byte(ws + l)
+		v.Buf.setData(i, []byte(ws+l))
 		dirty = true
 	}
 
@@ -949,7 +949,168 @@ func (v *View) InsertTab(usePlugin bool) bool {
 	return true
 }
 
-// SaveAll saves all open buffers
+// ExpandSnippet replaces the word before the cursor with the snippet which it
+// triggers, if there is one for the buffer's filetype
+func (v *View) ExpandSnippet(usePlugin bool) bool {
+	if !v.mainCursor() || len(v.Buf.cursors) > 1 || v.Cursor.HasSelection() {
+		return false
+	}
+
+	line := v.Buf.LineRunes(v.Cursor.Y)
+	start := v.Cursor.X
+	for start > 0 && start <= len(line) && IsWordChar(string(line[start-1])) {
+		start--
+	}
+	if start == v.Cursor.X {
+		return false
+	}
+	def, ok := snippetsForFiletype(v.Buf.FileType())[string(line[start:v.Cursor.X])]
+	if !ok {
+		return false
+	}
+
+	if usePlugin && !PreActionCall("ExpandSnippet", v) {
+		return false
+	}
+
+	v.InsertSnippet(Loc{start, v.Cursor.Y}, v.Cursor.Loc, def.Body)
+
+	if usePlugin {
+		return PostActionCall("ExpandSnippet", v)
+	}
+	return true
+}
+
+// NextSnippetField moves to the next field of the snippet being edited
+func (v *View) NextSnippetField(usePlugin bool) bool {
+	if v.snippet == nil {
+		return false
+	}
+	if !v.mainCursor() {
+		return v.snippetKeyForOtherCursor()
+	}
+	if !v.snippet.contains(v.Cursor.Loc) {
+		// The cursor has left the snippet, so it's finished with.
+		v.endSnippet()
+		return false
+	}
+
+	if usePlugin && !PreActionCall("NextSnippetField", v) {
+		return false
+	}
+
+	v.snippet.gotoField(v, v.snippet.current+1)
+
+	if usePlugin {
+		return PostActionCall("NextSnippetField", v)
+	}
+	return true
+}
+
+// PreviousSnippetField moves to the previous field of the snippet being edited
+func (v *View) PreviousSnippetField(usePlugin bool) bool {
+	if v.snippet == nil {
+		return false
+	}
+	if !v.mainCursor() {
+		return v.snippetKeyForOtherCursor()
+	}
+	if !v.snippet.contains(v.Cursor.Loc) {
+		return false
+	}
+
+	if usePlugin && !PreActionCall("PreviousSnippetField", v) {
+		return false
+	}
+
+	if v.snippet.current > 0 {
+		v.snippet.gotoField(v, v.snippet.current-1)
+	}
+
+	if usePlugin {
+		return PostActionCall("PreviousSnippetField", v)
+	}
+	return true
+}
+
+// AutocompleteTrigger shows the autocomplete options for the word before the
+// cursor, even if it wouldn't have started by itself
+func (v *View) AutocompleteTrigger(usePlugin bool) bool {
+	if !v.mainCursor() {
+		return false
+	}
+
+	if usePlugin && !PreActionCall("AutocompleteTrigger", v) {
+		return false
+	}
+
+	if err := v.Completer.Trigger(); err != nil {
+		messenger.Error(err)
+	}
+
+	if usePlugin {
+		return PostActionCall("AutocompleteTrigger", v)
+	}
+	return false
+}
+
+// AutocompleteNext highlights the next autocomplete option
+func (v *View) AutocompleteNext(usePlugin bool) bool {
+	if !v.mainCursor() || !v.Completer.Active {
+		return false
+	}
+
+	if usePlugin && !PreActionCall("AutocompleteNext", v) {
+		return false
+	}
+
+	v.Completer.Move(1)
+
+	if usePlugin {
+		return PostActionCall("AutocompleteNext", v)
+	}
+	return false
+}
+
+// AutocompletePrev highlights the previous autocomplete option
+func (v *View) AutocompletePrev(usePlugin bool) bool {
+	if !v.mainCursor() || !v.Completer.Active {
+		return false
+	}
+
+	if usePlugin && !PreActionCall("AutocompletePrev", v) {
+		return false
+	}
+
+	v.Completer.Move(-1)
+
+	if usePlugin {
+		return PostActionCall("AutocompletePrev", v)
+	}
+	return false
+}
+
+// AutocompleteAccept replaces the text being completed with the highlighted
+// autocomplete option
+func (v *View) AutocompleteAccept(usePlugin bool) bool {
+	if !v.mainCursor() || !v.Completer.Active {
+		return false
+	}
+
+	if usePlugin && !PreActionCall("AutocompleteAccept", v) {
+		return false
+	}
+
+	v.Completer.Accept()
+
+	if usePlugin {
+		return PostActionCall("AutocompleteAccept", v)
+	}
+	return true
+}
+
+// SaveAll saves all open buffers, along with the files grepreplace changed
+// which aren't open
 func (v *View) SaveAll(usePlugin bool) bool {
 	if v.mainCursor() {
 		if usePlugin && !PreActionCall("SaveAll", v) {
@@ -961,6 +1122,9 @@ func (v *View) SaveAll(usePlugin bool) bool {
 				v.Save(false)
 			}
 		}
+		if err := saveHiddenBuffers(); err != nil {
+			messenger.Error(err)
+		}
 
 		if usePlugin {
 			return PostActionCall("SaveAll", v)
@@ -1097,6 +1261,21 @@ func (v *View) FindNext(usePlugin bool) bool {
 	return true
 }
 
+// UnhighlightSearch stops highlighting the matches of the last search until
+// the next search
+func (v *View) UnhighlightSearch(usePlugin bool) bool {
+	if usePlugin && !PreActionCall("UnhighlightSearch", v) {
+		return false
+	}
+
+	highlightedSearch = ""
+
+	if usePlugin {
+		return PostActionCall("UnhighlightSearch", v)
+	}
+	return true
+}
+
 // FindPrevious searches backwards for the last used search term
 func (v *View) FindPrevious(usePlugin bool) bool {
 	if usePlugin && !PreActionCall("FindPrevious", v) {
@@ -1321,7 +1500,7 @@ func (v *View) MoveLinesDown(usePlugin bool) bool {
 	}
 
 	if v.Cursor.HasSelection() {
-		if v.Cursor.CurSelection[1].Y >= len(v.Buf.lines) {
+		if v.Cursor.CurSelection[1].Y >= v.Buf.LinesNum() {
 			messenger.Message("Can not move further down")
 			return true
 		}
@@ -1337,7 +1516,7 @@ func (v *View) MoveLinesDown(usePlugin bool) bool {
 		)
 		messenger.Message("Moved down selected line(s)")
 	} else {
-		if v.Cursor.Loc.Y >= len(v.Buf.lines)-1 {
+		if v.Cursor.Loc.Y >= v.Buf.LinesNum()-1 {
 			messenger.Message("Can not move further down")
 			return true
 		}
@@ -1835,6 +2014,11 @@ func (v *View) Escape(usePlugin bool) bool {
 			messenger.Reset() // FIXME
 			return true
 		}
+		// stop moving between the fields of a snippet
+		if v.snippet != nil {
+			v.endSnippet()
+			return true
+		}
 	}
 
 	return false
@@ -1848,7 +2032,7 @@ func (v *View) Quit(usePlugin bool) bool {
 		}
 
 		// Make sure not to quit if there are unsaved changes
-		if v.CanClose() {
+		if v.CanClose() && (len(tabs) > 1 || len(tabs[curTab].Views) > 1 || closeHiddenBuffers()) {
 			v.CloseBuffer()
 			if len(tabs[curTab].Views) > 1 {
 				v.splitNode.Delete()
@@ -1872,6 +2056,7 @@ func (v *View) Quit(usePlugin bool) bool {
 					PostActionCall("Quit", v)
 				}
 
+				lspShutdown()
 				screen.Fini()
 				messenger.SaveHistory()
 				os.Exit(0)
@@ -1905,7 +2090,7 @@ func (v *View) QuitAll(usePlugin bool) bool {
 			// only quit if all of the buffers can be closed and the user confirms that they actually want to quit everything
 			shouldQuit, _ := messenger.YesNoPrompt("Do you want to quit micro (all open files will be closed)?")
 
-			if shouldQuit {
+			if shouldQuit && closeHiddenBuffers() {
 				for _, tab := range tabs {
 					for _, v := range tab.Views {
 						v.CloseBuffer()
@@ -1916,6 +2101,7 @@ func (v *View) QuitAll(usePlugin bool) bool {
 					PostActionCall("QuitAll", v)
 				}
 
+				lspShutdown()
 				screen.Fini()
 				messenger.SaveHistory()
 				os.Exit(0)
@@ -182,6 +182,15 @@ func OptionValueComplete(inputOpt, input string) (string, []string) {
 			if strings.HasPrefix("dos", input) {
 				suggestions = append(suggestions, "dos")
 			}
+			if strings.HasPrefix("mac", input) {
+				suggestions = append(suggestions, "mac")
+			}
+		case "multiopen":
+			for _, layout := range []string{"tab", "vsplit", "hsplit"} {
+				if strings.HasPrefix(layout, input) {
+					suggestions = append(suggestions, layout)
+				}
+			}
 		case "sucmd":
 			if strings.HasPrefix("sudo", input) {
 				suggestions = append(suggestions, "sudo")
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	dmp "github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Unsaved changes are backed up to configDir/backups every few seconds for
+// the swapfile option, so that they can be recovered if micro is killed or the
+// terminal goes away. A backup is removed once its buffer is saved or closed,
+// so one which is still there when a file is opened was left by a micro which
+// didn't exit cleanly.
+
+const backupTime = 8 // Number of seconds to wait between backups
+
+// backupPath returns the file which the buffer is backed up to
+func (b *Buffer) backupPath() string {
+	return configDir + "/backups/" + EscapePath(b.AbsPath)
+}
+
+// canBackup returns whether the buffer is backed up
+func (b *Buffer) canBackup() bool {
+	// Large files would take too long to copy
+	return b.Settings["swapfile"].(bool) && b.Path != "" && !b.LargeFile
+}
+
+// Backup writes the text of the buffer to its backup if it has changed since
+// the last backup, or removes the backup once there are no unsaved changes
+func (b *Buffer) Backup() {
+	if !b.canBackup() {
+		return
+	}
+	// Each state in the undo tree has different text
+	state := b.Tree.Nodes[b.Tree.Current]
+	if state == b.backupState {
+		return
+	}
+	b.backupState = state
+
+	if !b.Modified() {
+		b.RemoveBackup()
+		return
+	}
+
+	os.MkdirAll(configDir+"/backups", os.ModePerm)
+	// The old backup is kept until the new one is complete
+	err := replaceFile(b.backupPath(), 0600, func(file io.Writer) error {
+		// The backup is compared with the text of the buffer when it's
+		// recovered, which has only newlines
+		_, err := file.Write(b.Bytes(false))
+		return err
+	})
+	if err != nil {
+		b.backupState = nil
+		messenger.AddLog("Error backing up ", b.Path, ": ", err.Error())
+	}
+}
+
+// RemoveBackup removes the backup of the buffer
+func (b *Buffer) RemoveBackup() {
+	if b.Path == "" {
+		return
+	}
+	os.Remove(b.backupPath())
+}
+
+// BackupAll backs up every open buffer
+func BackupAll() {
+	for _, t := range tabs {
+		for _, v := range t.Views {
+			if v.Type == vtDefault {
+				v.Buf.Backup()
+			}
+		}
+	}
+}
+
+// recoverBackup looks for a backup left from before the buffer was opened, and
+// asks the user whether to recover it
+func (b *Buffer) recoverBackup() {
+	if !b.canBackup() {
+		return
+	}
+	name := b.backupPath()
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return
+	}
+	backup, text := string(data), b.String()
+	if backup == text {
+		os.Remove(name)
+		return
+	}
+
+	// A backup which is kept is moved aside, so that the changes made from
+	// now on are still backed up
+	kept := name + "." + time.Now().Format("20060102-150405")
+	prompt := "Found a backup of unsaved changes to " + b.Path + ". Micro may have crashed, or it may\n" +
+		"still be editing the file somewhere else.\n\n" +
+		"Recover the changes (r), show them (d), discard them (x) or keep the backup as\n" +
+		kept + " (k)?"
+	for {
+		switch TermPrompt(prompt, 'r', 'd', 'x', 'k') {
+		case 'r':
+			// Recovering is a change which can be undone
+			b.ApplyDiff(backup)
+			b.Cursor.Relocate()
+			return
+		case 'd':
+			TermMessage("Changes in the backup of " + b.Path + ":\n\n" + diffLines(text, backup, 2))
+		case 'x':
+			os.Remove(name)
+			return
+		default:
+			if err := os.Rename(name, kept); err != nil {
+				TermMessage("Error keeping the backup of " + b.Path + ": " + err.Error())
+			}
+			return
+		}
+	}
+}
+
+// diffLines returns the lines which differ between a and b, marked with - and
+// + as in a unified diff, and the lines within context lines of them. Lines
+// which aren't shown are replaced with "...".
+func diffLines(a, b string, context int) string {
+	type diffLine struct {
+		op   byte
+		text string
+	}
+
+	differ := dmp.New()
+	ca, cb, lines := differ.DiffLinesToChars(a, b)
+	diffs := differ.DiffCharsToLines(differ.DiffMain(ca, cb, false), lines)
+
+	var all []diffLine
+	for _, d := range diffs {
+		op := byte(' ')
+		switch d.Type {
+		case dmp.DiffDelete:
+			op = '-'
+		case dmp.DiffInsert:
+			op = '+'
+		}
+		for _, l := range strings.SplitAfter(d.Text, "\n") {
+			if l != "" {
+				all = append(all, diffLine{op, strings.TrimSuffix(l, "\n")})
+			}
+		}
+	}
+
+	changed := false
+	show := make([]bool, len(all))
+	for i, l := range all {
+		if l.op != ' ' {
+			changed = true
+			for j := Max(i-context, 0); j <= Min(i+context, len(all)-1); j++ {
+				show[j] = true
+			}
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out []string
+	for i, l := range all {
+		if !show[i] {
+			if i == 0 || show[i-1] {
+				out = append(out, "...")
+			}
+			continue
+		}
+		out = append(out, string(l.op)+" "+l.text)
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\nb\nc\n", "a\nB\nc\n", "  a\n- b\n+ B\n  c"},
+		{"a\nb\nc\nd\ne\nf\ng\n", "a\nb\nc\nd\ne\nf\nG\n", "...\n  e\n  f\n- g\n+ G"},
+		{"a\nb\nc\nd\ne\nf\ng\n", "A\nb\nc\nd\ne\nf\ng\nh\n", "- a\n+ A\n  b\n  c\n...\n  f\n  g\n+ h"},
+		{"", "new\n", "+ new"},
+	}
+
+	for _, test := range tests {
+		if actual := diffLines(test.a, test.b, 2); actual != test.expected {
+			t.Errorf("diffLines(%q, %q): expected %q, got %q", test.a, test.b, test.expected, actual)
+		}
+	}
+}
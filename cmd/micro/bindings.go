@@ -59,6 +59,7 @@ var bindingActions = map[string]func(*View, bool) bool{
 	"Find":                   (*View).Find,
 	"FindNext":               (*View).FindNext,
 	"FindPrevious":           (*View).FindPrevious,
+	"UnhighlightSearch":      (*View).UnhighlightSearch,
 	"Center":                 (*View).Center,
 	"Undo":                   (*View).Undo,
 	"Redo":                   (*View).Redo,
@@ -116,6 +117,13 @@ var bindingActions = map[string]func(*View, bool) bool{
 	"RemoveAllMultiCursors":  (*View).RemoveAllMultiCursors,
 	"SkipMultiCursor":        (*View).SkipMultiCursor,
 	"JumpToMatchingBrace":    (*View).JumpToMatchingBrace,
+	"ExpandSnippet":          (*View).ExpandSnippet,
+	"NextSnippetField":       (*View).NextSnippetField,
+	"PreviousSnippetField":   (*View).PreviousSnippetField,
+	"AutocompleteTrigger":    (*View).AutocompleteTrigger,
+	"AutocompleteNext":       (*View).AutocompleteNext,
+	"AutocompletePrev":       (*View).AutocompletePrev,
+	"AutocompleteAccept":     (*View).AutocompleteAccept,
 
 	// This was changed to InsertNewline but I don't want to break backwards compatibility
 	"InsertEnter": (*View).InsertNewline,
@@ -406,6 +414,42 @@ func findAction(v string) (action func(*View, bool) bool) {
 	return action
 }
 
+// chainActions returns an action which runs each of the named actions in turn
+// until one of them succeeds, skipping the ones which would change a readonly
+// view. An action's plugin callbacks are run here, around the action, since
+// what the on callbacks return only says whether to relocate the view, and
+// not whether the action did anything.
+func chainActions(names []string) func(*View, bool) bool {
+	actions := make([]func(*View, bool) bool, len(names))
+	for i, name := range names {
+		actions[i] = findAction(name)
+	}
+	return func(v *View, usePlugin bool) bool {
+		for i, name := range names {
+			if v.Type.Readonly && changesContents(name) {
+				continue
+			}
+			if _, ok := bindingActions[name]; !ok {
+				// Lua functions have no callbacks
+				if actions[i](v, usePlugin) {
+					return true
+				}
+				continue
+			}
+			if usePlugin && !PreActionCall(name, v) {
+				continue
+			}
+			if actions[i](v, false) {
+				if usePlugin {
+					return PostActionCall(name, v)
+				}
+				return true
+			}
+		}
+		return false
+	}
+}
+
 func findMouseAction(v string) func(*View, bool, *tcell.EventMouse) bool {
 	action, ok := mouseBindingActions[v]
 	if !ok {
@@ -494,6 +538,8 @@ func BindKey(k, v string) {
 		} else if strings.HasPrefix(actionName, "command-edit:") {
 			cmd := strings.SplitN(actionName, ":", 2)[1]
 			actions = append(actions, CommandEditAction(cmd))
+		} else if strings.Contains(actionName, "|") {
+			actions = append(actions, chainActions(strings.Split(actionName, "|")))
 		} else {
 			actions = append(actions, findAction(actionName))
 		}
@@ -545,8 +591,8 @@ func DefaultBindings() map[string]string {
 		"Backspace":      "Backspace",
 		"Alt-CtrlH":      "DeleteWordLeft",
 		"Alt-Backspace":  "DeleteWordLeft",
-		"Tab":            "IndentSelection,InsertTab",
-		"Backtab":        "OutdentSelection,OutdentLine",
+		"Tab":            "NextSnippetField|ExpandSnippet|IndentSelection|InsertTab",
+		"Backtab":        "PreviousSnippetField|OutdentSelection|OutdentLine",
 		"CtrlO":          "OpenFile",
 		"CtrlS":          "Save",
 		"CtrlF":          "Find",
@@ -583,6 +629,7 @@ func DefaultBindings() map[string]string {
 		"CtrlU":          "ToggleMacro",
 		"CtrlJ":          "PlayMacro",
 		"Insert":         "ToggleOverwriteMode",
+		"CtrlSpace":      "AutocompleteTrigger",
 
 		// Emacs-style keybindings
 		"Alt-f": "WordRight",
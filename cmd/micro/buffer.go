@@ -4,10 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/md5"
-	"encoding/gob"
 	"errors"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -23,20 +21,13 @@ import (
 
 const LargeFileThreshold = 50000
 
-var (
-	// 0 - no line type detected
-	// 1 - lf detected
-	// 2 - crlf detected
-	fileformat = 0
-)
-
 // Buffer stores the text for files that are loaded into the text editor
 // It uses a rope to efficiently store the string and contains some
 // simple functions for saving and wrapper functions for modifying the rope
 type Buffer struct {
 	// The eventhandler for undo/redo
 	*EventHandler
-	// This stores all the text in the buffer as an array of lines
+	// This stores all the text in the buffer as a tree of lines
 	*LineArray
 
 	Cursor    Cursor
@@ -53,8 +44,33 @@ type Buffer struct {
 	// Whether or not the buffer has been modified since it was opened
 	IsModified bool
 
+	// Whether the file started with a byte order mark, which is written
+	// again when it's saved
+	bom bool
+
+	// Whether the file is larger than the "largefilesize" option, so that its
+	// lines are read from it as they're needed and the features which need all
+	// of its text are off
+	LargeFile bool
+
 	// Stores the last modification time of the file the buffer is pointing to
 	ModTime time.Time
+	// The text of the file when it was last read or saved, which changes made
+	// to it by other programs are merged with
+	saved *LineArray
+	// The file which is being watched for changes, if any
+	watched string
+
+	// The directory listing, if the buffer browses a directory rather than
+	// holding a file
+	browser *FileBrowser
+
+	// The matches of a search of the files in the working directory, if the
+	// buffer lists them
+	results *GrepResults
+
+	// The matches of the highlighted search, if they've been looked for
+	matches *searchMatches
 
 	// NumLines is the number of lines in the buffer
 	NumLines int
@@ -67,40 +83,36 @@ type Buffer struct {
 
 	// Buffer local settings
 	Settings map[string]interface{}
-}
 
-// The SerializedBuffer holds the types that get serialized when a buffer is saved
-// These are used for the savecursor and saveundo options
-type SerializedBuffer struct {
-	EventHandler *EventHandler
-	Cursor       Cursor
-	ModTime      time.Time
+	// Functions which are told about every change to the text
+	changeListeners []ChangeListener
+
+	// The state of the undo tree which was last backed up
+	backupState *UndoNode
 }
 
 // NewBufferFromFile opens a new buffer using the given path
 // It will also automatically handle `~`, and line/column with filename:l:c
 // It will return an empty buffer if the path does not exist
-// and an error if the file is a directory
+// and a file browser if the file is a directory
 func NewBufferFromFile(path string) (*Buffer, error) {
 	filename, cursorPosition := GetPathAndCursorPosition(path)
 	filename = ReplaceHome(filename)
-	file, err := os.Open(filename)
-	fileInfo, _ := os.Stat(filename)
-
-	if err == nil && fileInfo.IsDir() {
-		return nil, errors.New(filename + " is a directory")
+	if fileInfo, err := os.Stat(filename); err == nil && fileInfo.IsDir() {
+		return NewBufferFromDir(filename)
 	}
 
-	defer file.Close()
-
-	var buf *Buffer
+	file, err := os.Open(filename)
 	if err != nil {
 		// File does not exist -- create an empty buffer with that name
-		buf = NewBufferFromString("", filename)
-	} else {
-		buf = NewBuffer(file, FSize(file), filename, cursorPosition)
+		return NewBufferFromString("", filename), nil
 	}
+	defer file.Close()
 
+	buf, err := newBuffer(file, FSize(file), filename, cursorPosition)
+	if err != nil {
+		return nil, errors.New("Error reading " + filename + ": " + err.Error())
+	}
 	return buf, nil
 }
 
@@ -111,19 +123,57 @@ func NewBufferFromString(text, path string) *Buffer {
 
 // NewBuffer creates a new buffer from a given reader with a given path
 func NewBuffer(reader io.Reader, size int64, path string, cursorPosition []string) *Buffer {
+	b, _ := newBuffer(reader, size, path, cursorPosition)
+	return b
+}
+
+// newBuffer creates a new buffer like NewBuffer, and returns the error if the
+// reader fails, along with a buffer holding the text which was read before it
+func newBuffer(reader io.Reader, size int64, path string, cursorPosition []string) (*Buffer, error) {
 	// check if the file is already open in a tab. If it's open return the buffer to that tab
 	if path != "" {
 		for _, tab := range tabs {
 			for _, view := range tab.Views {
 				if view.Buf.Path == path {
-					return view.Buf
+					return view.Buf, nil
 				}
 			}
 		}
+		if b := takeHiddenBuffer(path); b != nil {
+			return b, nil
+		}
+	}
+
+	b, err := loadBuffer(reader, size, path, cursorPosition)
+	if err != nil {
+		return b, err
+	}
+	b.watch()
+
+	b.recoverBackup()
+
+	if b.Path != "" && b.MixedLineEndings() {
+		messenger.Message(b.GetName() + " has mixed line endings, which are kept when it's saved. Run normalize-eol to make them all " + b.Settings["fileformat"].(string) + ".")
 	}
 
+	return b, nil
+}
+
+// loadBuffer reads a buffer like newBuffer, without watching its file or
+// offering to recover its backup, for a buffer which isn't shown
+func loadBuffer(reader io.Reader, size int64, path string, cursorPosition []string) (*Buffer, error) {
 	b := new(Buffer)
-	b.LineArray = NewLineArray(size, reader)
+	if file, ok := reader.(*os.File); ok && isLargeFile(size) {
+		if la, err := openLargeFile(file.Name()); err == nil {
+			b.LineArray = la
+			b.LargeFile = true
+		}
+	}
+	var encoding string
+	var readErr error
+	if b.LineArray == nil {
+		b.LineArray, encoding, b.bom, readErr = readText(reader)
+	}
 
 	b.Settings = DefaultLocalSettings()
 	for k, v := range globalSettings {
@@ -131,11 +181,14 @@ func NewBuffer(reader io.Reader, size int64, path string, cursorPosition []strin
 			b.Settings[k] = v
 		}
 	}
+	b.largeFileSettings()
 
-	if fileformat == 1 {
-		b.Settings["fileformat"] = "unix"
-	} else if fileformat == 2 {
-		b.Settings["fileformat"] = "dos"
+	detected := b.commonLineEnding()
+	if detected != eolDefault {
+		b.Settings["fileformat"] = detected.String()
+	}
+	if encoding != "" {
+		b.Settings["encoding"] = encoding
 	}
 
 	absPath, _ := filepath.Abs(path)
@@ -162,34 +215,21 @@ func NewBuffer(reader io.Reader, size int64, path string, cursorPosition []strin
 	}
 
 	InitLocalSettings(b)
+	b.largeFileSettings()
+	if detected != eolDefault && b.lineEnding() != detected {
+		// A fileformat given in the settings converts the file, unless it's
+		// large, since that would read all of it
+		if b.LargeFile {
+			b.Settings["fileformat"] = detected.String()
+		} else {
+			b.swapLineEndings(make([]lineEnding, b.lineCount()))
+		}
+	}
 
 	if cursorLocationError != nil && len(*flagStartPos) == 0 && (b.Settings["savecursor"].(bool) || b.Settings["saveundo"].(bool)) {
 		// If either savecursor or saveundo is turned on, we need to load the serialized information
 		// from ~/.config/micro/buffers
-		file, err := os.Open(configDir + "/buffers/" + EscapePath(b.AbsPath))
-		defer file.Close()
-		if err == nil {
-			var buffer SerializedBuffer
-			decoder := gob.NewDecoder(file)
-			gob.Register(TextEvent{})
-			err = decoder.Decode(&buffer)
-			if err != nil {
-				TermMessage(err.Error(), "\n", "You may want to remove the files in ~/.config/micro/buffers (these files store the information for the 'saveundo' and 'savecursor' options) if this problem persists.")
-			}
-			if b.Settings["savecursor"].(bool) {
-				b.Cursor = buffer.Cursor
-				b.Cursor.buf = b
-				b.Cursor.Relocate()
-			}
-
-			if b.Settings["saveundo"].(bool) {
-				// We should only use last time's eventhandler if the file wasn't modified by someone else in the meantime
-				if b.ModTime == buffer.ModTime {
-					b.EventHandler = buffer.EventHandler
-					b.EventHandler.buf = b
-				}
-			}
-		}
+		b.loadUndoFile()
 	}
 
 	if !b.Settings["fastdirty"].(bool) {
@@ -202,8 +242,37 @@ func NewBuffer(reader io.Reader, size int64, path string, cursorPosition []strin
 	}
 
 	b.cursors = []*Cursor{&b.Cursor}
+	b.saved = b.Snapshot()
+	return b, readErr
+}
 
-	return b
+// isLargeFile returns whether a file of the given size should be opened in
+// large file mode.
+func isLargeFile(size int64) bool {
+	limit := largeFileSize()
+	return limit > 0 && size > limit
+}
+
+// largeFileSize returns the largefilesize option in bytes, or 0 if there's no
+// limit.
+func largeFileSize() int64 {
+	limit, ok := globalSettings["largefilesize"].(float64)
+	if !ok || limit <= 0 {
+		return 0
+	}
+	return int64(limit * 1024 * 1024)
+}
+
+// largeFileSettings turns off highlighting, autocomplete and hashing for a
+// large file, since they would read all of it.
+func (b *Buffer) largeFileSettings() {
+	if b.LargeFile {
+		b.Settings["syntax"] = false
+		b.Settings["autocomplete"] = false
+		b.Settings["fastdirty"] = true
+		// The file is read as it is, without decoding it
+		b.Settings["encoding"] = "utf-8"
+	}
 }
 
 func GetBufferCursorLocation(cursorPosition []string, b *Buffer) (Loc, error) {
@@ -287,7 +356,7 @@ func (b *Buffer) UpdateRules() {
 
 			ft := b.Settings["filetype"].(string)
 			if (ft == "Unknown" || ft == "") && !rehighlight {
-				if highlight.MatchFiletype(ftdetect, b.Path, b.lines[0].data) {
+				if highlight.MatchFiletype(ftdetect, b.Path, b.LineBytes(0)) {
 					header := new(highlight.Header)
 					header.FileType = file.FileType
 					header.FtDetect = ftdetect
@@ -330,6 +399,18 @@ func (b *Buffer) UpdateRules() {
 	}
 }
 
+// AddChangeListener registers a function which is called after every change
+// made to the text of the buffer, including undo and redo
+func (b *Buffer) AddChangeListener(l ChangeListener) {
+	b.changeListeners = append(b.changeListeners, l)
+}
+
+func (b *Buffer) notifyChange(start, end Loc, removed, inserted string) {
+	for _, l := range b.changeListeners {
+		l(start, end, removed, inserted)
+	}
+}
+
 // FileType returns the buffer's filetype
 func (b *Buffer) FileType() string {
 	return b.Settings["filetype"].(string)
@@ -344,46 +425,86 @@ func (b *Buffer) IndentString() string {
 }
 
 // CheckModTime makes sure that the file this buffer points to hasn't been updated
-// by an external program since it was last read
-// If it has, we ask the user if they would like to reload the file
+// by an external program since it was last read, unless the file is watched
+// for changes
 func (b *Buffer) CheckModTime() {
-	modTime, ok := GetModTime(b.Path)
-	if ok {
-		if modTime != b.ModTime {
-			choice, canceled := messenger.YesNoPrompt("The file has changed since it was last read. Reload file? (y,n)")
-			messenger.Reset()
-			messenger.Clear()
-			if !choice || canceled {
-				// Don't load new changes -- do nothing
-				b.ModTime, _ = GetModTime(b.Path)
-			} else {
-				// Load new changes
-				b.ReOpen()
-			}
-		}
+	if b.watched == "" {
+		b.fileChanged()
 	}
 }
 
 // ReOpen reloads the current buffer from disk
 func (b *Buffer) ReOpen() {
-	data, err := ioutil.ReadFile(b.Path)
-	txt := string(data)
+	if b.LargeFile {
+		b.reOpenLargeFile()
+		return
+	}
+	la, err := b.readFile()
+	if err != nil {
+		messenger.Error(err.Error())
+		return
+	}
+	// The line endings of the file are restored after its text
+	b.EventHandler.ApplyDiff(la.String())
+	b.setLineEndings(la.swapLineEndings(make([]lineEnding, la.lineCount())))
+
+	b.ModTime, _ = GetModTime(b.Path)
+	b.IsModified = false
+	b.saved = b.Snapshot()
+	if !b.Settings["fastdirty"].(bool) {
+		calcHash(b, &b.origHash)
+	}
+	b.Update()
+	b.Cursor.Relocate()
+}
+
+// openLargeFile opens a large file, whose lines are read from it as they're
+// needed, so it stays open until the line array's closeFile is called.
+func openLargeFile(path string) (*LineArray, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	la, err := NewFileLineArray(file, FSize(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return la, nil
+}
 
+// checkFile returns an error if the lines of a large file which haven't been
+// edited can't be read from it any more, so that it can't be saved without
+// losing them.
+func (b *Buffer) checkFile() error {
+	if err := b.fileError(); err != nil {
+		return errors.New("Can't save " + b.GetName() + ", since " + err.Error() + " and its lines which haven't been edited can't be read. Reopen it to save it")
+	}
+	return nil
+}
+
+// reOpenLargeFile reads the lines of the file again instead of comparing it
+// with the buffer, which would read all of both. The undo history is lost.
+func (b *Buffer) reOpenLargeFile() {
+	la, err := openLargeFile(b.Path)
 	if err != nil {
 		messenger.Error(err.Error())
 		return
 	}
-	b.EventHandler.ApplyDiff(txt)
+	b.LineArray.closeFile()
+	b.LineArray = la
+	b.EventHandler = NewEventHandler(b)
 
 	b.ModTime, _ = GetModTime(b.Path)
 	b.IsModified = false
+	b.saved = b.Snapshot()
 	b.Update()
 	b.Cursor.Relocate()
 }
 
 // Update fetches the string from the rope and updates the `text` and `lines` in the buffer
 func (b *Buffer) Update() {
-	b.NumLines = len(b.lines)
+	b.NumLines = b.lineCount()
 }
 
 // MergeCursors merges any cursors that are at the same position
@@ -431,36 +552,18 @@ func (b *Buffer) SaveWithSudo() error {
 	return b.SaveAsWithSudo(b.Path)
 }
 
-// Serialize serializes the buffer to configDir/buffers
-func (b *Buffer) Serialize() error {
-	if !b.Settings["savecursor"].(bool) && !b.Settings["saveundo"].(bool) {
-		return nil
-	}
-
-	name := configDir + "/buffers/" + EscapePath(b.AbsPath)
-
-	return overwriteFile(name, func(file io.Writer) error {
-		return gob.NewEncoder(file).Encode(SerializedBuffer{
-			b.EventHandler,
-			b.Cursor,
-			b.ModTime,
-		})
-	})
-}
-
-func init() {
-	gob.Register(TextEvent{})
-	gob.Register(SerializedBuffer{})
-}
-
 // SaveAs saves the buffer to a specified path (filename), creating the file if it does not exist
 func (b *Buffer) SaveAs(filename string) error {
+	if err := b.checkFile(); err != nil {
+		return err
+	}
 	b.UpdateRules()
 	if b.Settings["rmtrailingws"].(bool) {
-		for i, l := range b.lines {
-			pos := len(bytes.TrimRightFunc(l.data, unicode.IsSpace))
+		for i := 0; i < b.lineCount(); i++ {
+			l := b.lineData(i)
+			pos := len(bytes.TrimRightFunc(l, unicode.IsSpace))
 
-			if pos < len(l.data) {
+			if pos < len(l) {
 				b.deleteToEnd(Loc{pos, i})
 			}
 		}
@@ -502,41 +605,37 @@ func (b *Buffer) SaveAs(filename string) error {
 
 	var fileSize int
 
-	err := overwriteFile(absFilename, func(file io.Writer) (e error) {
-		if len(b.lines) == 0 {
-			return
-		}
-
-		// end of line
-		var eol []byte
-
-		if b.Settings["fileformat"] == "dos" {
-			eol = []byte{'\r', '\n'}
-		} else {
-			eol = []byte{'\n'}
+	// Text in another encoding is converted before the file is opened, so
+	// that the file is left alone if it can't be
+	var encoded []byte
+	if b.isEncoded() {
+		var err error
+		if encoded, err = b.encode(b.lineEnding()); err != nil {
+			return err
 		}
+	}
 
-		// write lines
-		if fileSize, e = file.Write(b.lines[0].data); e != nil {
+	// A large file is still being read from while it's saved, so it can't be
+	// overwritten.
+	err := saveFile(absFilename, b.Settings["backup"].(bool), !b.LargeFile, func(file io.Writer) (e error) {
+		if b.lineCount() == 0 {
 			return
 		}
 
-		for _, l := range b.lines[1:] {
-			if _, e = file.Write(eol); e != nil {
-				return
-			}
-
-			if _, e = file.Write(l.data); e != nil {
-				return
-			}
-
-			fileSize += len(eol) + len(l.data)
+		if encoded != nil {
+			fileSize, e = file.Write(encoded)
+			return
 		}
 
+		// write lines
+		fileSize, e = b.write(file, b.lineEnding())
 		return
 	})
 
 	if err != nil {
+		if changed := b.checkFile(); changed != nil {
+			return changed
+		}
 		return err
 	}
 
@@ -549,8 +648,12 @@ func (b *Buffer) SaveAs(filename string) error {
 		}
 	}
 
+	b.RemoveBackup()
 	b.Path = filename
+	b.AbsPath, _ = filepath.Abs(filename)
 	b.IsModified = false
+	b.saved = b.Snapshot()
+	b.watch()
 	return b.Serialize()
 }
 
@@ -576,7 +679,11 @@ func overwriteFile(name string, fn func(io.Writer) error) (err error) {
 		return
 	}
 
-	err = w.Flush()
+	if err = w.Flush(); err != nil {
+		return
+	}
+
+	err = file.Sync()
 	return
 }
 
@@ -584,21 +691,26 @@ func overwriteFile(name string, fn func(io.Writer) error) (err error) {
 func calcHash(b *Buffer, out *[md5.Size]byte) {
 	h := md5.New()
 
-	if len(b.lines) > 0 {
-		h.Write(b.lines[0].data)
-
-		for _, l := range b.lines[1:] {
-			h.Write([]byte{'\n'})
-			h.Write(l.data)
-		}
-	}
+	b.write(h, b.lineEnding())
 
 	h.Sum((*out)[:0])
 }
 
-// SaveAsWithSudo is the same as SaveAs except it uses a neat trick
-// with tee to use sudo so the user doesn't have to reopen micro with sudo
+// SaveAsWithSudo is the same as SaveAs except it runs micro again with sudo
+// to save the file, so the user doesn't have to reopen micro with sudo
 func (b *Buffer) SaveAsWithSudo(filename string) error {
+	text, err := b.encode(b.lineEnding())
+	if err != nil {
+		if changed := b.checkFile(); changed != nil {
+			return changed
+		}
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
 	b.UpdateRules()
 	b.Path = filename
 
@@ -607,8 +719,11 @@ func (b *Buffer) SaveAsWithSudo(filename string) error {
 	screen = nil
 
 	// Set up everything for the command
-	cmd := exec.Command(globalSettings["sucmd"].(string), "tee", filename)
-	cmd.Stdin = bytes.NewBufferString(b.SaveString(b.Settings["fileformat"] == "dos"))
+	backup := strconv.FormatBool(b.Settings["backup"].(bool))
+	cmd := exec.Command(globalSettings["sucmd"].(string), exe, "-save-file", filename, "-save-backup="+backup)
+	cmd.Stdin = bytes.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 
 	// This is a trap for Ctrl-C so that it doesn't kill micro
 	// Instead we trap Ctrl-C to kill the program we're running
@@ -622,13 +737,19 @@ func (b *Buffer) SaveAsWithSudo(filename string) error {
 
 	// Start the command
 	cmd.Start()
-	err := cmd.Wait()
+	err = cmd.Wait()
+	if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+		err = errors.New(msg)
+	}
 
 	// Start the screen back up
 	InitScreen()
 	if err == nil {
+		b.AbsPath, _ = filepath.Abs(filename)
 		b.IsModified = false
 		b.ModTime, _ = GetModTime(filename)
+		b.saved = b.Snapshot()
+		b.watch()
 		b.Serialize()
 	}
 	return err
@@ -671,7 +792,7 @@ func (b *Buffer) Start() Loc {
 
 // End returns the location of the last character in the buffer
 func (b *Buffer) End() Loc {
-	return Loc{utf8.RuneCount(b.lines[b.NumLines-1].data), b.NumLines - 1}
+	return Loc{utf8.RuneCount(b.lineData(b.NumLines - 1)), b.NumLines - 1}
 }
 
 // RuneAt returns the rune at a given location in the buffer
@@ -685,51 +806,52 @@ func (b *Buffer) RuneAt(loc Loc) rune {
 
 // LineBytes returns a single line as an array of runes
 func (b *Buffer) LineBytes(n int) []byte {
-	if n >= len(b.lines) {
+	if n >= b.lineCount() {
 		return []byte{}
 	}
-	return b.lines[n].data
+	return b.lineData(n)
 }
 
 // LineRunes returns a single line as an array of runes
 func (b *Buffer) LineRunes(n int) []rune {
-	if n >= len(b.lines) {
+	if n >= b.lineCount() {
 		return []rune{}
 	}
-	return toRunes(b.lines[n].data)
+	return toRunes(b.lineData(n))
 }
 
 // Line returns a single line
 func (b *Buffer) Line(n int) string {
-	if n >= len(b.lines) {
+	if n >= b.lineCount() {
 		return ""
 	}
-	return string(b.lines[n].data)
+	return string(b.lineData(n))
 }
 
 // LinesNum returns the number of lines in the buffer
 func (b *Buffer) LinesNum() int {
-	return len(b.lines)
+	return b.lineCount()
 }
 
 // Lines returns an array of strings containing the lines from start to end
 func (b *Buffer) Lines(start, end int) []string {
-	lines := b.lines[start:end]
 	var slice []string
-	for _, line := range lines {
-		slice = append(slice, string(line.data))
-	}
+	b.eachLine(start, end, func(_ int, l []byte) bool {
+		slice = append(slice, string(l))
+		return true
+	})
 	return slice
 }
 
 // Len gives the length of the buffer
 func (b *Buffer) Len() (n int) {
-	for _, l := range b.lines {
-		n += utf8.RuneCount(l.data)
-	}
+	b.eachLine(0, b.lineCount(), func(_ int, l []byte) bool {
+		n += utf8.RuneCount(l)
+		return true
+	})
 
-	if len(b.lines) > 1 {
-		n += len(b.lines) - 1 // account for newlines
+	if b.lineCount() > 1 {
+		n += b.lineCount() - 1 // account for newlines
 	}
 
 	return
@@ -737,14 +859,14 @@ func (b *Buffer) Len() (n int) {
 
 // MoveLinesUp moves the range of lines up one row
 func (b *Buffer) MoveLinesUp(start int, end int) {
-	// 0 < start < end <= len(b.lines)
-	if start < 1 || start >= end || end > len(b.lines) {
+	// 0 < start < end <= b.LinesNum()
+	if start < 1 || start >= end || end > b.LinesNum() {
 		return // what to do? FIXME
 	}
-	if end == len(b.lines) {
+	if end == b.LinesNum() {
 		b.Insert(
 			Loc{
-				utf8.RuneCount(b.lines[end-1].data),
+				utf8.RuneCount(b.LineBytes(end - 1)),
 				end - 1,
 			},
 			"\n"+b.Line(start-1),
@@ -763,10 +885,10 @@ func (b *Buffer) MoveLinesUp(start int, end int) {
 
 // MoveLinesDown moves the range of lines down one row
 func (b *Buffer) MoveLinesDown(start int, end int) {
-	// 0 <= start < end < len(b.lines)
-	// if end == len(b.lines), we can't do anything here because the
+	// 0 <= start < end < b.LinesNum()
+	// if end == b.LinesNum(), we can't do anything here because the
 	// last line is unaccessible, FIXME
-	if start < 0 || start >= end || end >= len(b.lines)-1 {
+	if start < 0 || start >= end || end >= b.LinesNum()-1 {
 		return // what to do? FIXME
 	}
 	b.Insert(
@@ -782,7 +904,7 @@ func (b *Buffer) MoveLinesDown(start int, end int) {
 
 // ClearMatches clears all of the syntax highlighting for this buffer
 func (b *Buffer) ClearMatches() {
-	for i := range b.lines {
+	for i := 0; i < b.lineCount(); i++ {
 		b.SetMatch(i, nil)
 		b.SetState(i, nil)
 	}
@@ -831,7 +953,7 @@ func (b *Buffer) FindMatchingBrace(braceType [2]rune, start Loc) Loc {
 		}
 	} else if startChar == braceType[1] {
 		for y := start.Y; y >= 0; y-- {
-			l := []rune(string(b.lines[y].data))
+			l := b.LineRunes(y)
 			xInit := len(l) - 1
 			if y == start.Y {
 				xInit = start.X
@@ -100,9 +100,9 @@ func (c *CellView) Draw(buf *Buffer, top, height, left, width int) {
 
 	start := buf.Cursor.Y
 	if buf.Settings["syntax"].(bool) && buf.syntaxDef != nil {
-		if start > 0 && buf.lines[start-1].rehighlight {
+		if start > 0 && buf.rehighlight(start-1) {
 			buf.highlighter.ReHighlightLine(buf, start-1)
-			buf.lines[start-1].rehighlight = false
+			buf.setRehighlight(start-1, false)
 		}
 
 		buf.highlighter.ReHighlightStates(buf, start)
@@ -117,7 +117,7 @@ func (c *CellView) Draw(buf *Buffer, top, height, left, width int) {
 
 	curStyle := defStyle
 	for viewLine < height {
-		if lineN >= len(buf.lines) {
+		if lineN >= buf.LinesNum() {
 			break
 		}
 
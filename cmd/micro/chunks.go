@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxLoadedChunkBytes is the most bytes of the chunks of a file which are
+// kept in memory. Older chunks are read from the file again when they're
+// needed.
+var maxLoadedChunkBytes = 16 << 20
+
+var (
+	errFileChanged = errors.New("the file was changed by another program")
+	errFileClosed  = errors.New("the file was closed")
+)
+
+// A chunkSource is a large file which the chunks of a line array are read
+// from as they're needed, rather than reading all of it into memory.
+type chunkSource struct {
+	r io.ReaderAt
+
+	mu sync.Mutex
+	// err is set once a chunk can't be read as it was when its lines were
+	// counted, or once the file is closed, after which it isn't read from
+	// again
+	err error
+	// loaded holds the chunks whose data is in memory, in the order they were
+	// read
+	loaded      []*fileChunk
+	loadedBytes int
+}
+
+// A fileChunk is a part of a chunkSource holding the lines of a leaf.
+type fileChunk struct {
+	src      *chunkSource
+	off      int64
+	size     int
+	newlines int
+	// data is the text of the chunk, or nil if it isn't in memory
+	data []byte
+}
+
+// read returns the text of the chunk, reading it from the file if it isn't in
+// memory. Lines which were taken from it stay valid when it's dropped from
+// memory. A file which another program only appended to can still be read,
+// but if the chunk isn't in the file as it was when its lines were counted,
+// an error is returned and the file isn't read from again.
+func (c *fileChunk) read() ([]byte, error) {
+	s := c.src
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.data != nil {
+		return c.data, nil
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	data := make([]byte, c.size)
+	n, _ := s.r.ReadAt(data, c.off)
+	if n < c.size || bytes.Count(data, []byte{'\n'}) != c.newlines {
+		s.err = errFileChanged
+		return nil, s.err
+	}
+
+	c.data = data
+	s.loaded = append(s.loaded, c)
+	s.loadedBytes += len(data)
+	for s.loadedBytes > maxLoadedChunkBytes && len(s.loaded) > 1 {
+		old := s.loaded[0]
+		s.loadedBytes -= len(old.data)
+		old.data = nil
+		s.loaded = s.loaded[1:]
+	}
+	return data, nil
+}
+
+// bytes returns the text of the chunk like read. If it can't be read, its
+// lines are shown as empty so that the line array stays consistent, but
+// they're never written, since write fails instead.
+func (c *fileChunk) bytes() []byte {
+	data, err := c.read()
+	if err != nil {
+		return bytes.Repeat([]byte{'\n'}, c.newlines)
+	}
+	return data
+}
+
+// slice returns the part of the chunk from start to end, whose text is data.
+func (c *fileChunk) slice(start, end int, data []byte) *fileChunk {
+	return &fileChunk{
+		src:      c.src,
+		off:      c.off + int64(start),
+		size:     end - start,
+		newlines: bytes.Count(data, []byte{'\n'}),
+	}
+}
+
+// failed returns the error which stopped the file from being read, or nil if
+// all of its chunks can still be read.
+func (s *chunkSource) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// close closes the file, if it is one, and drops the chunks from memory.
+func (s *chunkSource) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = errFileClosed
+	for _, c := range s.loaded {
+		c.data = nil
+	}
+	s.loaded, s.loadedBytes = nil, 0
+	if c, ok := s.r.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// buildFileChunks builds a tree holding chunks of the lines of a file, which
+// is read once to find where they start.
+func buildFileChunks(owner *lineOwner, src *chunkSource, size int64) (*lineNode, error) {
+	br := bufio.NewReaderSize(io.NewSectionReader(src.r, 0, size), 64*1024)
+	var leaves []*lineNode
+	var start, pos int64
+	var last byte
+	newlines, crlf := 0, 0
+	for {
+		data, err := br.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return nil, err
+		}
+		if n := len(data); n > 0 && data[n-1] == '\n' {
+			cr := n > 1 && data[n-2] == '\r' || n == 1 && last == '\r'
+			if newlines == maxLeafLines-1 {
+				end, eol := pos+int64(n)-1, eolLF
+				if cr {
+					end, eol = end-1, eolCRLF
+				}
+				c := &fileChunk{src: src, off: start, size: int(end - start), newlines: newlines}
+				leaves = append(leaves, newChunk(owner, c, newlines, crlf, eol))
+				start, newlines, crlf = pos+int64(n), 0, 0
+			} else {
+				newlines++
+				if cr {
+					crlf++
+				}
+			}
+		}
+		if n := len(data); n > 0 {
+			last = data[n-1]
+			pos += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	c := &fileChunk{src: src, off: start, size: int(size - start), newlines: newlines}
+	leaves = append(leaves, newChunk(owner, c, newlines, crlf, eolDefault))
+	return buildTree(owner, leaves), nil
+}
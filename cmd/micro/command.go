@@ -8,7 +8,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"unicode/utf8"
+	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/zyedidia/micro/cmd/micro/shellwords"
@@ -18,6 +18,8 @@ import (
 type Command struct {
 	action      func([]string)
 	completions []Completion
+	// ranged is whether the command can be given a range of lines
+	ranged bool
 }
 
 // A StrCommand is similar to a command but keeps the name of the action
@@ -30,34 +32,61 @@ var commands map[string]Command
 
 var commandActions map[string]func([]string)
 
+// rangeActions are the actions which can be given a range of lines, which is
+// put before the command's name, as in 10,40 replace a b
+var rangeActions = map[string]bool{
+	"Replace":    true,
+	"ReplaceAll": true,
+}
+
+// A lineRange is a range of lines, counted from 1
+type lineRange struct {
+	start, end int
+}
+
+// commandRange is the range of lines given to the command which is running, if
+// it was given one
+var commandRange *lineRange
+
+var commandRangeRegex = regexp.MustCompile(`^\s*(\d+)(?:\s*,\s*(\d+))?\s*`)
+
 func init() {
 	commandActions = map[string]func([]string){
-		"Set":        Set,
-		"SetLocal":   SetLocal,
-		"Show":       Show,
-		"ShowKey":    ShowKey,
-		"Run":        Run,
-		"Bind":       Bind,
-		"Quit":       Quit,
-		"Save":       Save,
-		"Replace":    Replace,
-		"ReplaceAll": ReplaceAll,
-		"VSplit":     VSplit,
-		"HSplit":     HSplit,
-		"Tab":        NewTab,
-		"Help":       Help,
-		"Eval":       Eval,
-		"ToggleLog":  ToggleLog,
-		"Plugin":     PluginCmd,
-		"Reload":     Reload,
-		"Cd":         Cd,
-		"Pwd":        Pwd,
-		"Open":       Open,
-		"TabSwitch":  TabSwitch,
-		"Term":       Term,
-		"MemUsage":   MemUsage,
-		"Retab":      Retab,
-		"Raw":        Raw,
+		"Set":                Set,
+		"SetLocal":           SetLocal,
+		"Show":               Show,
+		"ShowKey":            ShowKey,
+		"Run":                Run,
+		"Bind":               Bind,
+		"Quit":               Quit,
+		"Save":               Save,
+		"SaveAll":            SaveAll,
+		"Replace":            Replace,
+		"ReplaceAll":         ReplaceAll,
+		"VSplit":             VSplit,
+		"HSplit":             HSplit,
+		"Tab":                NewTab,
+		"Help":               Help,
+		"Eval":               Eval,
+		"ToggleLog":          ToggleLog,
+		"Plugin":             PluginCmd,
+		"Reload":             Reload,
+		"Cd":                 Cd,
+		"Pwd":                Pwd,
+		"Open":               Open,
+		"TabSwitch":          TabSwitch,
+		"Term":               Term,
+		"MemUsage":           MemUsage,
+		"Retab":              Retab,
+		"Raw":                Raw,
+		"Earlier":            Earlier,
+		"Later":              Later,
+		"UndoTree":           ToggleUndoTree,
+		"ReopenWithEncoding": ReopenWithEncoding,
+		"NormalizeEOL":       NormalizeEOL,
+		"Grep":               Grep,
+		"GrepReplace":        GrepReplace,
+		"NoHlSearch":         NoHlSearch,
 	}
 }
 
@@ -85,38 +114,47 @@ func MakeCommand(name, function string, completions ...Completion) {
 		action = LuaFunctionCommand(function)
 	}
 
-	commands[name] = Command{action, completions}
+	commands[name] = Command{action, completions, rangeActions[function]}
 }
 
 // DefaultCommands returns a map containing micro's default commands
 func DefaultCommands() map[string]StrCommand {
 	return map[string]StrCommand{
-		"set":        {"Set", []Completion{OptionCompletion, OptionValueCompletion}},
-		"setlocal":   {"SetLocal", []Completion{OptionCompletion, OptionValueCompletion}},
-		"show":       {"Show", []Completion{OptionCompletion, NoCompletion}},
-		"showkey":    {"ShowKey", []Completion{NoCompletion}},
-		"bind":       {"Bind", []Completion{NoCompletion}},
-		"run":        {"Run", []Completion{NoCompletion}},
-		"quit":       {"Quit", []Completion{NoCompletion}},
-		"save":       {"Save", []Completion{NoCompletion}},
-		"replace":    {"Replace", []Completion{NoCompletion}},
-		"replaceall": {"ReplaceAll", []Completion{NoCompletion}},
-		"vsplit":     {"VSplit", []Completion{FileCompletion, NoCompletion}},
-		"hsplit":     {"HSplit", []Completion{FileCompletion, NoCompletion}},
-		"tab":        {"Tab", []Completion{FileCompletion, NoCompletion}},
-		"help":       {"Help", []Completion{HelpCompletion, NoCompletion}},
-		"eval":       {"Eval", []Completion{NoCompletion}},
-		"log":        {"ToggleLog", []Completion{NoCompletion}},
-		"plugin":     {"Plugin", []Completion{PluginCmdCompletion, PluginNameCompletion}},
-		"reload":     {"Reload", []Completion{NoCompletion}},
-		"cd":         {"Cd", []Completion{FileCompletion}},
-		"pwd":        {"Pwd", []Completion{NoCompletion}},
-		"open":       {"Open", []Completion{FileCompletion}},
-		"tabswitch":  {"TabSwitch", []Completion{NoCompletion}},
-		"term":       {"Term", []Completion{NoCompletion}},
-		"memusage":   {"MemUsage", []Completion{NoCompletion}},
-		"retab":      {"Retab", []Completion{NoCompletion}},
-		"raw":        {"Raw", []Completion{NoCompletion}},
+		"set":                  {"Set", []Completion{OptionCompletion, OptionValueCompletion}},
+		"setlocal":             {"SetLocal", []Completion{OptionCompletion, OptionValueCompletion}},
+		"show":                 {"Show", []Completion{OptionCompletion, NoCompletion}},
+		"showkey":              {"ShowKey", []Completion{NoCompletion}},
+		"bind":                 {"Bind", []Completion{NoCompletion}},
+		"run":                  {"Run", []Completion{NoCompletion}},
+		"quit":                 {"Quit", []Completion{NoCompletion}},
+		"save":                 {"Save", []Completion{NoCompletion}},
+		"saveall":              {"SaveAll", []Completion{NoCompletion}},
+		"replace":              {"Replace", []Completion{NoCompletion}},
+		"replaceall":           {"ReplaceAll", []Completion{NoCompletion}},
+		"vsplit":               {"VSplit", []Completion{FileCompletion, NoCompletion}},
+		"hsplit":               {"HSplit", []Completion{FileCompletion, NoCompletion}},
+		"tab":                  {"Tab", []Completion{FileCompletion, NoCompletion}},
+		"help":                 {"Help", []Completion{HelpCompletion, NoCompletion}},
+		"eval":                 {"Eval", []Completion{NoCompletion}},
+		"log":                  {"ToggleLog", []Completion{NoCompletion}},
+		"plugin":               {"Plugin", []Completion{PluginCmdCompletion, PluginNameCompletion}},
+		"reload":               {"Reload", []Completion{NoCompletion}},
+		"cd":                   {"Cd", []Completion{FileCompletion}},
+		"pwd":                  {"Pwd", []Completion{NoCompletion}},
+		"open":                 {"Open", []Completion{FileCompletion}},
+		"tabswitch":            {"TabSwitch", []Completion{NoCompletion}},
+		"term":                 {"Term", []Completion{NoCompletion}},
+		"memusage":             {"MemUsage", []Completion{NoCompletion}},
+		"retab":                {"Retab", []Completion{NoCompletion}},
+		"raw":                  {"Raw", []Completion{NoCompletion}},
+		"earlier":              {"Earlier", []Completion{NoCompletion}},
+		"later":                {"Later", []Completion{NoCompletion}},
+		"undotree":             {"UndoTree", []Completion{NoCompletion}},
+		"reopen-with-encoding": {"ReopenWithEncoding", []Completion{NoCompletion}},
+		"normalize-eol":        {"NormalizeEOL", []Completion{NoCompletion}},
+		"grep":                 {"Grep", []Completion{NoCompletion}},
+		"grepreplace":          {"GrepReplace", []Completion{NoCompletion}},
+		"nohlsearch":           {"NoHlSearch", []Completion{NoCompletion}},
 	}
 }
 
@@ -253,6 +291,125 @@ func Raw(args []string) {
 	}
 }
 
+// parseUndoAmount parses the argument of the earlier and later commands,
+// which is either a number of steps or a time such as 30s, 5m, 2h or 1d
+func parseUndoAmount(args []string) (steps int, d time.Duration, err error) {
+	if len(args) == 0 {
+		return 1, 0, nil
+	}
+	if steps, err = strconv.Atoi(args[0]); err == nil {
+		return steps, 0, nil
+	}
+	if days := strings.TrimSuffix(args[0], "d"); days != args[0] {
+		n, err := strconv.Atoi(days)
+		return 0, time.Duration(n) * 24 * time.Hour, err
+	}
+	d, err = time.ParseDuration(args[0])
+	return 0, d, err
+}
+
+// Earlier goes back to an older state of the buffer, which can be on another
+// branch of the undo tree
+func Earlier(args []string) {
+	steps, d, err := parseUndoAmount(args)
+	if err != nil {
+		messenger.Error("Invalid amount: ", args[0])
+		return
+	}
+
+	v := CurView()
+	if v.Buf.curCursor == 0 {
+		v.Buf.clearCursors()
+	}
+	if steps > 0 {
+		v.Buf.EarlierSteps(steps)
+	} else {
+		v.Buf.Earlier(d)
+	}
+	v.Relocate()
+}
+
+// Later goes forward to a newer state of the buffer, which can be on another
+// branch of the undo tree
+func Later(args []string) {
+	steps, d, err := parseUndoAmount(args)
+	if err != nil {
+		messenger.Error("Invalid amount: ", args[0])
+		return
+	}
+
+	v := CurView()
+	if v.Buf.curCursor == 0 {
+		v.Buf.clearCursors()
+	}
+	if steps > 0 {
+		v.Buf.LaterSteps(steps)
+	} else {
+		v.Buf.Later(d)
+	}
+	v.Relocate()
+}
+
+// ToggleUndoTree toggles a split which shows the undo tree of the current buffer
+func ToggleUndoTree(args []string) {
+	if CurView().Type == vtUndo {
+		CurView().Quit(true)
+	} else {
+		CurView().OpenUndoBrowser()
+	}
+}
+
+// ReopenWithEncoding reads the file of the current buffer again from the given
+// encoding, which it's then saved in
+func ReopenWithEncoding(args []string) {
+	if len(args) < 1 {
+		messenger.Error("No encoding")
+		return
+	}
+	name := strings.ToLower(args[0])
+	if _, err := findEncoding(name); err != nil {
+		messenger.Error(err)
+		return
+	}
+
+	v := CurView()
+	if v.Buf.Path == "" {
+		messenger.Error("The buffer has no file to reopen")
+		return
+	}
+	if v.Buf.LargeFile {
+		messenger.Error("Large files can only be read as UTF-8")
+		return
+	}
+	if v.CanClose() {
+		v.Buf.Settings["encoding"] = name
+		v.Buf.ReOpen()
+		v.Relocate()
+		messenger.Message("Reopened ", v.Buf.GetName(), " as ", name)
+	}
+}
+
+// NormalizeEOL gives every line of the current buffer the same line ending,
+// the one of the given file format or else of the fileformat option
+func NormalizeEOL(args []string) {
+	v := CurView()
+	if len(args) > 0 {
+		// Setting fileformat normalizes the line endings
+		if err := SetLocalOption("fileformat", args[0], v); err != nil {
+			messenger.Error(err)
+			return
+		}
+	} else {
+		v.Buf.NormalizeLineEndings()
+	}
+	messenger.Message("Line endings are now ", v.Buf.Settings["fileformat"])
+}
+
+// NoHlSearch stops highlighting the matches of the last search
+func NoHlSearch(args []string) {
+	CurView().UnhighlightSearch(true)
+}
+
 // TabSwitch switches to a given tab either by name or by number
 func TabSwitch(args []string) {
 	if len(args) > 0 {
@@ -331,21 +488,27 @@ func Pwd(args []string) {
 	}
 }
 
-// Open opens a new buffer with a given filename
+// Open opens the given files, or the files which match the given glob
+// patterns
 func Open(args []string) {
-	if len(args) > 0 {
-		filename := args[0]
-		// the filename might or might not be quoted, so unquote first then join the strings.
-		args, err := shellwords.Split(filename)
-		if err != nil {
-			messenger.Error("Error parsing args ", err)
-			return
-		}
-		filename = strings.Join(args, " ")
-
-		CurView().Open(filename)
-	} else {
+	if len(args) == 0 {
 		messenger.Error("No filename")
+		return
+	}
+	paths := expandPaths(args)
+
+	// The first file replaces the current one, and the others are opened in
+	// tabs or splits depending on the multiopen option
+	CurView().Open(paths[0])
+	open := NewTab
+	switch globalSettings["multiopen"] {
+	case "vsplit":
+		open = VSplit
+	case "hsplit":
+		open = HSplit
+	}
+	for _, path := range paths[1:] {
+		open([]string{path})
 	}
 }
 
@@ -543,9 +706,15 @@ func Save(args []string) {
 	}
 }
 
+// SaveAll saves all of the buffers, including the ones grepreplace changed
+// in files which aren't open
+func SaveAll(args []string) {
+	CurView().SaveAll(true)
+}
+
 // Replace runs search and replace
 func Replace(args []string) {
-	if len(args) < 2 || len(args) > 4 {
+	if len(args) < 2 || len(args) > 5 {
 		// We need to find both a search and replace expression
 		messenger.Error("Invalid replace statement: " + strings.Join(args, " "))
 		return
@@ -553,6 +722,7 @@ func Replace(args []string) {
 
 	all := false
 	noRegex := false
+	preserveCase := false
 
 	if len(args) > 2 {
 		for _, arg := range args[2:] {
@@ -561,6 +731,8 @@ func Replace(args []string) {
 				all = true
 			case "-l":
 				noRegex = true
+			case "-c":
+				preserveCase = true
 			default:
 				messenger.Error("Invalid flag: " + arg)
 				return
@@ -574,84 +746,127 @@ func Replace(args []string) {
 		search = regexp.QuoteMeta(search)
 	}
 
-	replace := string(args[1])
-	replaceBytes := []byte(replace)
-
 	regex, err := regexp.Compile("(?m)" + search)
 	if err != nil {
 		// There was an error with the user's regex
 		messenger.Error(err.Error())
 		return
 	}
+	replacer := newReplacer(regex, args[1], noRegex, preserveCase)
 
 	view := CurView()
+	regions, err := replaceRegions(view, commandRange)
+	if err != nil {
+		messenger.Error(err)
+		return
+	}
+	if regions != nil {
+		view.Buf.clearCursors()
+	}
 
 	found := 0
-	replaceAll := func() {
-		var deltas []Delta
-		for i := 0; i < view.Buf.LinesNum(); i++ {
-			newText := regex.ReplaceAllFunc(view.Buf.lines[i].data, func(in []byte) []byte {
-				found++
-				return replaceBytes
-			})
-
-			from := Loc{0, i}
-			to := Loc{utf8.RuneCount(view.Buf.lines[i].data), i}
-
-			deltas = append(deltas, Delta{string(newText), from, to})
+	if all {
+		if regions == nil {
+			regions = []region{{view.Buf.Start(), view.Buf.End()}}
+		}
+		deltas := replacer.deltasIn(view.Buf, regions)
+		if len(deltas) > 0 {
+			view.Buf.MultipleReplace(deltas)
 		}
-		view.Buf.MultipleReplace(deltas)
+		found = len(deltas)
+	} else {
+		if regions == nil {
+			// Go from the cursor to the end, and then from the start back to
+			// the cursor
+			regions = []region{{view.Cursor.Loc, view.Buf.End()}, {view.Buf.Start(), view.Cursor.Loc}}
+		}
+		found = replaceInteractively(view, replacer, regions)
 	}
+	view.Cursor.ResetSelection()
+	view.Cursor.Relocate()
 
-	if all {
-		replaceAll()
+	if found > 1 {
+		messenger.Message("Replaced ", found, " occurrences of ", search)
+	} else if found == 1 {
+		messenger.Message("Replaced ", found, " occurrence of ", search)
 	} else {
-		for {
-			// The 'check' flag was used
-			Search(search, view, true)
-			if !view.Cursor.HasSelection() {
+		messenger.Message("Nothing matched ", search)
+	}
+}
+
+// replaceInteractively asks whether to replace each match in the regions, and
+// returns the number of matches which were replaced
+func replaceInteractively(view *View, replacer *replacer, regions []region) int {
+	found := 0
+	for i := 0; i < len(regions); i++ {
+		from := regions[i].start
+		for from.LessEqual(regions[i].end) {
+			match, src, indexes := replacer.find(view.Buf, from, regions[i].end)
+			if indexes == nil {
 				break
 			}
+			replacement := replacer.expand(src, indexes)
+
+			end, restore := previewReplacement(view.Buf, match, replacement)
+			view.Cursor.SetSelectionStart(match.start)
+			view.Cursor.SetSelectionEnd(end)
+			view.Cursor.Loc = end
 			view.Relocate()
 			RedrawAll()
 			choice, canceled := messenger.LetterPrompt("Perform replacement? (y,n,a)", 'y', 'n', 'a')
+			restore()
+			view.Cursor.Loc = match.end
+			messenger.Reset()
 			if canceled {
-				if view.Cursor.HasSelection() {
-					view.Cursor.Loc = view.Cursor.CurSelection[0]
-					view.Cursor.ResetSelection()
-				}
-				messenger.Reset()
-				break
-			} else if choice == 'a' {
-				if view.Cursor.HasSelection() {
-					view.Cursor.Loc = view.Cursor.CurSelection[0]
-					view.Cursor.ResetSelection()
-				}
-				messenger.Reset()
-				replaceAll()
-				break
-			} else if choice == 'y' {
-				view.Cursor.DeleteSelection()
-				view.Buf.Insert(view.Cursor.Loc, replace)
+				view.Cursor.Loc = match.start
+				return found
+			}
+			if choice == 'a' {
+				rest := append([]region{{match.start, regions[i].end}}, regions[i+1:]...)
+				deltas := replacer.deltasIn(view.Buf, rest)
+				view.Buf.MultipleReplace(deltas)
+				view.Cursor.Loc = match.start
+				return found + len(deltas)
+			}
+
+			from = match.end
+			if choice == 'y' {
 				view.Cursor.ResetSelection()
-				messenger.Reset()
+				view.Buf.Replace(match.start, match.end, replacement)
+				from = textEnd(match.start, replacement)
+				for j := i; j < len(regions); j++ {
+					regions[j].start = shiftLoc(regions[j].start, match.end, from)
+					regions[j].end = shiftLoc(regions[j].end, match.end, from)
+				}
 				found++
 			}
-			if view.Cursor.HasSelection() {
-				searchStart = view.Cursor.CurSelection[1]
-			} else {
-				searchStart = view.Cursor.Loc
+			if match.start == match.end {
+				// An empty match would be found again at the same place
+				from = from.Move(1, view.Buf)
 			}
 		}
 	}
-	view.Cursor.Relocate()
+	return found
+}
 
-	if found > 1 {
-		messenger.Message("Replaced ", found, " occurrences of ", search)
-	} else if found == 1 {
-		messenger.Message("Replaced ", found, " occurrence of ", search)
-	} else {
-		messenger.Message("Nothing matched ", search)
+// previewReplacement puts the replacement in place of the match, so that it
+// can be seen while the user is asked whether to make it, and returns where it
+// ends along with a function which puts the match back. The text is changed
+// without going through the event handler, so the preview isn't undone, but
+// change listeners are told about both changes so that what they keep of the
+// text, such as the matches of the highlighted search, stays up to date.
+func previewReplacement(buf *Buffer, match region, replacement string) (Loc, func()) {
+	modified := buf.IsModified
+	original := buf.Snapshot()
+	removed := buf.remove(match.start, match.end)
+	buf.insert(match.start, []byte(replacement))
+	end := textEnd(match.start, replacement)
+	buf.notifyChange(match.start, match.end, removed, replacement)
+	return end, func() {
+		buf.restore(original)
+		buf.Update()
+		buf.notifyChange(match.start, end, replacement, removed)
+		buf.IsModified = modified
 	}
 }
 
@@ -676,17 +891,36 @@ func Term(args []string) {
 
 // HandleCommand handles input from the user
 func HandleCommand(input string) {
+	var lines *lineRange
+	if m := commandRangeRegex.FindStringSubmatch(input); m != nil {
+		start, _ := strconv.Atoi(m[1])
+		end := start
+		if m[2] != "" {
+			end, _ = strconv.Atoi(m[2])
+		}
+		lines = &lineRange{start, end}
+		input = input[len(m[0]):]
+	}
+
 	args, err := shellwords.Split(input)
 	if err != nil {
 		messenger.Error("Error parsing args ", err)
 		return
 	}
+	if len(args) == 0 || args[0] == "" {
+		messenger.Error("No command given")
+		return
+	}
 
 	inputCmd := args[0]
 
-	if _, ok := commands[inputCmd]; !ok {
+	if cmd, ok := commands[inputCmd]; !ok {
 		messenger.Error("Unknown command ", inputCmd)
+	} else if lines != nil && !cmd.ranged {
+		messenger.Error(inputCmd, " doesn't take a range of lines")
 	} else {
-		commands[inputCmd].action(args[1:])
+		commandRange = lines
+		cmd.action(args[1:])
+		commandRange = nil
 	}
 }
@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/zyedidia/micro/cmd/micro/optionprovider"
+	"github.com/zyedidia/micro/cmd/micro/snippet"
 	"github.com/zyedidia/tcell"
 )
 
@@ -12,7 +16,8 @@ import (
 // data. For example, given input "abc\nab", start offset 4 and end offset 5, then the prefix is "ab", and the result
 // should be the option "abc".
 // Logger provides logging. Can be satisfied with t.Logf for tests, or LogToMessenger.
-type OptionProvider func(logger func(s string, values ...interface{}), buffer []byte, startOffset, endOffset int) (options []optionprovider.Option, startOffsetDelta int, err error)
+// Cancel is closed when the options are no longer wanted, so that slow providers can stop early. It may be nil.
+type OptionProvider func(logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, endOffset int) (options []optionprovider.Option, startOffsetDelta int, err error)
 
 // ContentSetter is the signature of a function which allows the content of a cell to be set.
 type ContentSetter func(x int, y int, mainc rune, combc []rune, style tcell.Style)
@@ -35,12 +40,29 @@ func CompleterEnabledFlagFromView(v *View) func() bool {
 // CurrentBytesAndOffsetFromView gets bytes from a view.
 func CurrentBytesAndOffsetFromView(v *View) func() (bytes []byte, offset int) {
 	return func() (bytes []byte, offset int) {
-		bytes = v.Buf.Buffer(false).Bytes()
+		bytes = v.Buf.Bytes(false)
 		offset = ByteOffset(v.Cursor.Loc, v.Buf)
 		return
 	}
 }
 
+// SnapshotFromView takes a snapshot of the text of a view, which is only copied into bytes when they're
+// needed.
+func SnapshotFromView(v *View) func() (bytes func() []byte, offset int) {
+	return func() (bytes func() []byte, offset int) {
+		s := v.Buf.Snapshot()
+		return func() []byte { return s.Bytes(false) }, ByteOffset(v.Cursor.Loc, v.Buf)
+	}
+}
+
+// LineBeforeCursorFromView gets the text of the current line before the cursor in a view.
+func LineBeforeCursorFromView(v *View) func() []byte {
+	return func() []byte {
+		line := v.Buf.LineBytes(v.Cursor.Y)
+		return line[:runeToByteIndex(v.Cursor.X, line)]
+	}
+}
+
 // LocationOffsetFromView provides the offset of a given location.
 func LocationOffsetFromView(v *View) func(Loc) (offset int) {
 	return func(l Loc) (offset int) {
@@ -55,24 +77,100 @@ func CurrentLocationFromView(v *View) func() Loc {
 	}
 }
 
-// ReplaceFromBuffer replaces text in a buffer.
+// ReplaceFromBuffer replaces text in a buffer. When there are several cursors, the text is also
+// completed at the others which have the same text before them, as one change which is undone at once.
 func ReplaceFromBuffer(buf *Buffer) func(from, to Loc, with string) {
 	return func(from, to Loc, with string) {
 		LogToMessenger()("replacing from %v to %v with %s", from, to, with)
-		buf.Replace(from, to, with)
-		buf.Cursor.GotoLoc(Loc{X: from.X + len(with), Y: from.Y})
+		if len(buf.cursors) == 1 || from.Y != to.Y || strings.Contains(with, "\n") {
+			buf.Replace(from, to, with)
+			buf.Cursor.GotoLoc(Loc{X: from.X + Count(with), Y: from.Y})
+			return
+		}
+
+		locs := make([]Loc, len(buf.cursors))
+		for i, c := range buf.cursors {
+			locs[i] = c.Loc
+		}
+		deltas := completionDeltas(buf.LineRunes, locs, from, to, with)
+		buf.MultipleReplace(deltas)
+		for _, c := range buf.cursors {
+			c.GotoLoc(locAfterDeltas(c.Loc, deltas))
+			c.ResetSelection()
+		}
+	}
+}
+
+// completionDeltas returns the changes which complete the text between from and to, which are on
+// the same line, at each of the cursor locations with the same text before them. The deltas are
+// ordered from the end of the buffer, so that each one can be made without moving the others.
+func completionDeltas(line func(y int) []rune, locs []Loc, from, to Loc, with string) (deltas []Delta) {
+	prefix := string(line(from.Y)[from.X:to.X])
+	n := to.X - from.X
+
+	sorted := append([]Loc(nil), locs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[j].LessThan(sorted[i]) })
+	for _, l := range sorted {
+		runes := line(l.Y)
+		if l.X < n || l.X > len(runes) || string(runes[l.X-n:l.X]) != prefix {
+			continue
+		}
+		start := Loc{X: l.X - n, Y: l.Y}
+		// Skip cursors whose text overlaps the change after them, or which are at the same place,
+		// unless it's the text being completed.
+		if len(deltas) > 0 && !deltas[len(deltas)-1].Start.GreaterEqual(l) {
+			if l != to {
+				continue
+			}
+			deltas = deltas[:len(deltas)-1]
+		}
+		deltas = append(deltas, Delta{Text: with, Start: start, End: l})
 	}
+	return deltas
 }
 
-// ContentSetterForView sets the content of a cell for the x, y coordinate of a document.
+// locAfterDeltas returns where a location ends up once the deltas from completionDeltas have been
+// made. Locations at the end of a change are moved to the end of its new text.
+func locAfterDeltas(l Loc, deltas []Delta) Loc {
+	x := l.X
+	for _, d := range deltas {
+		if d.Start.Y == l.Y && d.End.X <= l.X {
+			x += Count(d.Text) - (d.End.X - d.Start.X)
+		}
+	}
+	return Loc{X: x, Y: l.Y}
+}
+
+// ContentSetterForView sets the content of a cell for the x, y coordinate of a document, where x is
+// the visual column of the line.
 func ContentSetterForView(v *View) ContentSetter {
 	return func(x int, y int, mainc rune, combc []rune, style tcell.Style) {
-		targetY := y - v.Topline
-		targetX := x + v.leftCol + v.lineNumOffset
+		targetY := v.y + y - v.Topline
+		targetX := v.x + v.lineNumOffset + x - v.leftCol
 		screen.SetContent(targetX, targetY, mainc, combc, style)
 	}
 }
 
+// PositionFromView converts a location to the line and visual column where it's drawn, which
+// differ when the line contains tabs or wide characters.
+func PositionFromView(v *View) func(Loc) Loc {
+	return func(l Loc) Loc {
+		runes := v.Buf.LineRunes(l.Y)
+		if l.X > len(runes) {
+			l.X = len(runes)
+		}
+		tabSize := int(v.Buf.Settings["tabsize"].(float64))
+		return Loc{X: StringWidth(string(runes[:l.X]), tabSize), Y: l.Y}
+	}
+}
+
+// BoundsFromView returns the part of the document which is visible in the view.
+func BoundsFromView(v *View) func() (left, top, right, bottom int) {
+	return func() (left, top, right, bottom int) {
+		return v.leftCol, v.Topline, v.leftCol + v.Width - v.lineNumOffset, v.Topline + v.Height
+	}
+}
+
 // LogToMessenger logs to the global messenger.
 func LogToMessenger() func(s string, values ...interface{}) {
 	return func(s string, values ...interface{}) {
@@ -96,20 +194,49 @@ type Completer struct {
 	Activators map[rune]int
 	// Deactivators are insertions that stop autocomplete, e.g. a closing bracket, or a semicolon.
 	Deactivators []rune
+	// Triggers are sequences which start autocomplete just after them once they've been typed, such as
+	// "->" or "::". Unlike Activators, they restart autocomplete if it's already active.
+	Triggers []string
+	// WordRune returns whether a character is part of a word. If it's set, typing a word character starts
+	// autocomplete at the start of the word, once the word is at least MinPrefix characters long.
+	WordRune func(r rune) bool
+	// MinPrefix is the number of characters of a word which must be typed before autocomplete starts.
+	MinPrefix int
+	// Delay is how long to wait for more typing before asking the provider for options in the background.
+	Delay time.Duration
 	// Provider is the provider of completion options, e.g. gocode, or another provider such as a language server.
 	Provider OptionProvider
 	// Logger is where log messages are written via fmt.Sprintf.
 	Logger func(s string, values ...interface{})
 	// CurrentBytesAndOffset is a function which returns the bytes and the current offset position from the current view.
 	CurrentBytesAndOffset func() (bytes []byte, offset int)
+	// Snapshot is like CurrentBytesAndOffset, but the bytes are only made when the options are fetched, so
+	// that copying a large buffer doesn't hold up typing. If it's nil, CurrentBytesAndOffset is used.
+	Snapshot func() (bytes func() []byte, offset int)
+	// LineBeforeCursor is a function which returns the text before the cursor on its line. If it's nil, it's
+	// taken from CurrentBytesAndOffset.
+	LineBeforeCursor func() []byte
 	// CurrentLocation is a function which returns the current location of the cursor.
 	CurrentLocation func() Loc
 	// LocationOffset is a function which returns the offset of a given location.
 	LocationOffset func(Loc) int
 	// Replacer is a function which replaces text.
 	Replacer func(from, to Loc, with string)
+	// SnippetReplacer is a function which replaces text with a snippet. If it's nil, the text of options
+	// is used even if they have a snippet.
+	SnippetReplacer func(from, to Loc, body string)
 	// Setter is a function which draws to the console at a given location.
 	Setter ContentSetter
+	// Position is a function which converts a location to the cell where it's drawn, in the coordinates
+	// used by Setter. If it's nil, locations are drawn as they are.
+	Position func(Loc) Loc
+	// Bounds is a function which returns the area that the options can be drawn in, in the coordinates
+	// used by Setter. The right and bottom edges are not included. If it's nil, the area is unlimited.
+	Bounds func() (left, top, right, bottom int)
+	// MaxHeight is the number of options that can be shown at once. The list scrolls to show the rest.
+	MaxHeight int
+	// ShowDetail determines whether the documentation of the active option is shown next to the list.
+	ShowDetail bool
 	// OptionStyleInactive is the style for completer options which are not currently highlighted.
 	OptionStyleInactive tcell.Style
 	// OptionStyleActive is the style for completer options which are currently highlighted.
@@ -118,38 +245,26 @@ type Completer struct {
 	Enabled func() bool
 	// PreviousLocation stores the last known location of the cursor.
 	PreviousLocation Loc
+	// Dispatch runs a function on the main thread. When it's set, Process asks the provider for options
+	// in the background and uses Dispatch to apply them, otherwise Process waits for the provider.
+	Dispatch func(f func())
+
+	// request is the number of the latest request for options, used to discard out of date results.
+	request int
+	// cancel is closed to abandon the request which is running in the background.
+	cancel chan struct{}
+	// top is the index of the first option shown in the list.
+	top int
+	// shown is where the list was last drawn, so that mouse events can be matched to options.
+	shown popupLayout
 }
 
-// defaultActivators sets whether the character should start autocompletion. The value of zero means that
-// the character itself is not included in the replacement, -1 means that it is.
-var defaultActivators = map[rune]int{
-	'.': 0,
-	'(': 0,
-	'a': -1, 'b': -1, 'c': -1, 'd': -1, 'e': -1, 'f': -1, 'g': -1, 'h': -1, 'i': -1, 'j': -1, 'k': -1, 'l': -1, 'm': -1, 'n': -1, 'o': -1, 'p': -1, 'q': -1, 'r': -1, 's': -1, 't': -1, 'u': -1, 'v': -1, 'w': -1, 'x': -1, 'y': -1, 'z': -1,
-	'A': -1, 'B': -1, 'C': -1, 'D': -1, 'E': -1, 'F': -1, 'G': -1, 'H': -1, 'I': -1, 'J': -1, 'K': -1, 'L': -1, 'M': -1, 'N': -1, 'O': -1, 'P': -1, 'Q': -1, 'R': -1, 'S': -1, 'T': -1, 'U': -1, 'V': -1, 'W': -1, 'X': -1, 'Y': -1, 'Z': -1,
-}
-
-const defaultDeactivators = "), \n."
+const defaultMaxHeight = 10
 
 // NewCompleterForView creates a new autocompleter with defaults for writing to the console.
 func NewCompleterForView(v *View) *Completer {
-	var provider OptionProvider
-
-	// Load the provider based on filename.
-	fileName := v.Buf.GetName()
-	if strings.HasSuffix(fileName, ".go") {
-		provider = optionprovider.GoCode
-	} else {
-		provider = optionprovider.Generic
-	}
-
-	// If no matching provider was found, we can't autocomplete.
-	if provider == nil {
-		provider = optionprovider.Noop
-	}
-
-	return NewCompleter(defaultActivators, []rune(defaultDeactivators),
-		provider,
+	c := NewCompleter(nil, CompleterDeactivators(v.Buf),
+		CompleterProviderForView(v),
 		LogToMessenger(),
 		CurrentBytesAndOffsetFromView(v),
 		CurrentLocationFromView(v),
@@ -160,6 +275,27 @@ func NewCompleterForView(v *View) *Completer {
 		colorscheme["default"],
 		CompleterEnabledFlagFromView(v),
 	)
+	c.Dispatch = RunInMainLoop
+	c.Snapshot = SnapshotFromView(v)
+	c.LineBeforeCursor = LineBeforeCursorFromView(v)
+	c.Position = PositionFromView(v)
+	c.Bounds = BoundsFromView(v)
+	c.ShowDetail = globalSettings["autocompletedetail"].(bool)
+	c.SnippetReplacer = func(from, to Loc, body string) {
+		// Snippets are only edited with one cursor, so the other cursors get the plain text.
+		if len(v.Buf.cursors) > 1 {
+			if s, err := snippet.Parse(body); err == nil {
+				c.Replacer(from, to, s.Text)
+				return
+			}
+		}
+		v.InsertSnippet(from, to, body)
+	}
+	c.Triggers = CompleterTriggers(v.Buf)
+	c.WordRune = CompleterWordRune(v.Buf.FileType())
+	c.MinPrefix = int(v.Buf.Settings["autocompleteminlength"].(float64))
+	c.Delay = time.Duration(v.Buf.Settings["autocompletedelay"].(float64)) * time.Millisecond
+	return c
 }
 
 // NewCompleter creates a new completer with all options exposed. See NewCompleterForView for more common usage.
@@ -188,6 +324,7 @@ func NewCompleter(activators map[rune]int,
 		OptionStyleInactive:   optionStyleInactive,
 		OptionStyleActive:     optionStyleActive,
 		Enabled:               enabled,
+		MaxHeight:             defaultMaxHeight,
 	}
 }
 
@@ -207,15 +344,21 @@ func (c *Completer) Process(r rune) error {
 		c.Active = false
 	}
 
+	if trigger, ok := c.triggered(); ok {
+		c.Logger("completer.Process: activating, because received %v", trigger)
+		c.activate(0)
+	} else if !c.Active && c.WordRune != nil && c.WordRune(r) {
+		if n, ok := c.wordBeforeCursor(); ok && n >= c.MinPrefix {
+			c.Logger("completer.Process: activating, because a word of %d characters was typed", n)
+			c.activate(-n)
+		}
+	}
+
 	if !c.Active {
 		// Check to work out whether we should activate the autocomplete.
 		if indexAdjustment, ok := c.Activators[r]; ok {
 			c.Logger("completer.Process: activating, because received %v", string(r))
-			c.Active = true
-			currentLocation := c.CurrentLocation()
-			c.PreviousLocation = currentLocation
-			c.X, c.Y = currentLocation.X+indexAdjustment, currentLocation.Y
-			c.Logger("completer.Process: SetStartPosition to %d, %d", c.X, c.Y)
+			c.activate(indexAdjustment)
 		}
 	}
 
@@ -224,24 +367,146 @@ func (c *Completer) Process(r rune) error {
 		return nil
 	}
 
-	// Get options.
-	//TODO: We only need the answer by the time Display is called, so we could let the rest of the
-	// program continue until we're ready to receive the value by using a go routine or channel.
-	bytes, currentOffset := c.CurrentBytesAndOffset()
+	return c.update()
+}
+
+// update asks the provider for the options for the text being completed.
+func (c *Completer) update() error {
 	startOffset := c.LocationOffset(Loc{X: c.X, Y: c.Y})
-	options, delta, err := c.Provider(c.Logger, bytes, startOffset, currentOffset)
+	if c.Dispatch != nil && c.Snapshot != nil {
+		bytes, currentOffset := c.Snapshot()
+		c.fetch(bytes, startOffset, currentOffset)
+		return nil
+	}
+	bytes, currentOffset := c.CurrentBytesAndOffset()
+	if c.Dispatch != nil {
+		c.fetch(func() []byte { return bytes }, startOffset, currentOffset)
+		return nil
+	}
+	options, delta, err := c.Provider(c.Logger, nil, bytes, startOffset, currentOffset)
 	if err != nil {
 		return err
 	}
+	c.setOptions(options, delta)
+	return nil
+}
+
+// activate starts autocomplete, with the text to replace starting indexAdjustment characters from
+// the cursor.
+func (c *Completer) activate(indexAdjustment int) {
+	c.Active = true
+	currentLocation := c.CurrentLocation()
+	c.PreviousLocation = currentLocation
+	c.X, c.Y = currentLocation.X+indexAdjustment, currentLocation.Y
+	c.Logger("completer.Process: SetStartPosition to %d, %d", c.X, c.Y)
+}
+
+// triggered returns the trigger which the text before the cursor ends with, if there is one.
+func (c *Completer) triggered() (trigger string, ok bool) {
+	if len(c.Triggers) == 0 {
+		return "", false
+	}
+	before := c.lineBeforeCursor()
+	for _, t := range c.Triggers {
+		if t != "" && strings.HasSuffix(string(before[Max(0, len(before)-len(t)):]), t) {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// wordBeforeCursor returns the number of characters in the word that ends at the cursor. Numbers
+// aren't words, so ok is false if it starts with a digit.
+func (c *Completer) wordBeforeCursor() (n int, ok bool) {
+	before := c.lineBeforeCursor()
+	var first rune
+	for len(before) > 0 {
+		r, size := utf8.DecodeLastRune(before)
+		if !c.WordRune(r) {
+			break
+		}
+		first = r
+		before = before[:len(before)-size]
+		n++
+	}
+	return n, n > 0 && (first < '0' || first > '9')
+}
+
+// lineBeforeCursor returns the text before the cursor, which only needs to go back to the start of the
+// line.
+func (c *Completer) lineBeforeCursor() []byte {
+	if c.LineBeforeCursor != nil {
+		return c.LineBeforeCursor()
+	}
+	bytes, offset := c.CurrentBytesAndOffset()
+	return bytes[:offset]
+}
+
+// fetch asks the provider for options in a goroutine, so that typing isn't held up by slow providers.
+// The options are applied on the main thread, unless the cursor has moved or another request has been
+// made in the meantime.
+func (c *Completer) fetch(text func() []byte, startOffset, currentOffset int) {
+	c.Cancel()
+	c.request++
+	request, location, cancel := c.request, c.CurrentLocation(), make(chan struct{})
+	c.cancel = cancel
+
+	provider, dispatch, delay := c.Provider, c.Dispatch, c.Delay
+	// The logger isn't safe to use from another goroutine.
+	logger := func(s string, values ...interface{}) {
+		msg := fmt.Sprintf(s, values...)
+		dispatch(func() { c.Logger("%s", msg) })
+	}
+
+	go func() {
+		if delay > 0 {
+			// Wait in case another key is pressed, which cancels this request.
+			select {
+			case <-cancel:
+				return
+			case <-time.After(delay):
+			}
+		}
+		options, delta, err := provider(logger, cancel, text(), startOffset, currentOffset)
+		select {
+		case <-cancel:
+			return
+		default:
+		}
+		dispatch(func() {
+			if request != c.request || !c.Active || c.CurrentLocation() != location {
+				c.Logger("completer.fetch: discarding out of date options for request %d", request)
+				return
+			}
+			c.cancel = nil
+			if err != nil {
+				c.Logger("completer.fetch: failed to get options: %v", err)
+				c.Active = false
+				return
+			}
+			c.setOptions(options, delta)
+		})
+	}()
+}
+
+// Cancel abandons the request for options which is running in the background, if there is one.
+func (c *Completer) Cancel() {
+	if c.cancel != nil {
+		close(c.cancel)
+		c.cancel = nil
+	}
+}
+
+func (c *Completer) setOptions(options []optionprovider.Option, delta int) {
 	c.X += delta
 	c.Options = options
 	c.ActiveIndex = 0
+	c.top = 0
 	// If there are no options, just deactivate.
 	if len(options) == 0 {
 		c.Logger("completer.Process: Deactivating because there are no options")
 		c.Active = false
 	}
-	return err
 }
 
 // HandleEvent handles incoming key presses if the completer is active.
@@ -259,25 +524,22 @@ func (c *Completer) HandleEvent(key tcell.Key) bool {
 	// Handle selecting various options in the list.
 	switch key {
 	case tcell.KeyUp:
-		if c.ActiveIndex > 0 {
-			c.ActiveIndex--
-		}
-		break
+		c.Move(-1)
 	case tcell.KeyDown:
-		if c.ActiveIndex < len(c.Options)-1 {
-			c.ActiveIndex++
-		}
-		break
+		c.Move(1)
+	case tcell.KeyPgUp:
+		c.Move(-c.pageSize())
+	case tcell.KeyPgDn:
+		c.Move(c.pageSize())
+	case tcell.KeyHome:
+		c.Move(-len(c.Options))
+	case tcell.KeyEnd:
+		c.Move(len(c.Options))
 	case tcell.KeyEsc:
+		c.Cancel()
 		c.Active = false
-		break
 	case tcell.KeyTab, tcell.KeyEnter:
-		// Complete the text.
-		if toUse, ok := getOption(c.ActiveIndex, c.Options); ok {
-			c.Replacer(Loc{X: c.X, Y: c.Y}, c.CurrentLocation(), toUse)
-		}
-		c.Active = false
-		break
+		c.Accept()
 	default:
 		// Not part of the keys that the autocomplete menu handles.
 		return false
@@ -287,17 +549,103 @@ func (c *Completer) HandleEvent(key tcell.Key) bool {
 	return true
 }
 
+// HandleMouse handles a mouse event at x, y, in the coordinates used by Setter. Clicking an option
+// accepts it, and the wheel scrolls the list. Clicking anywhere else closes the list, but the event
+// is left for the view, as are events which the list doesn't use. It returns true if it used the event.
+func (c *Completer) HandleMouse(x, y int, buttons tcell.ButtonMask) bool {
+	if !c.Enabled() || !c.Active || c.shown.rows == 0 {
+		return false
+	}
+	p := c.shown
+	inside := x >= p.x && x < p.x+p.width() && y >= p.y && y < p.y+p.rows
+
+	switch {
+	case buttons == tcell.Button1 && inside:
+		c.ActiveIndex = c.top + y - p.y
+		c.Accept()
+	case buttons == tcell.Button1:
+		c.Cancel()
+		c.Active = false
+		return false
+	case buttons == tcell.WheelUp && inside:
+		c.scroll(-1)
+	case buttons == tcell.WheelDown && inside:
+		c.scroll(1)
+	default:
+		return false
+	}
+	return true
+}
+
+// Move moves the highlight n options down the list, or up if n is negative, stopping at the ends.
+func (c *Completer) Move(n int) {
+	i := c.ActiveIndex + n
+	if n > 0 {
+		i = Min(i, len(c.Options)-1)
+	}
+	c.ActiveIndex = Max(i, 0)
+}
+
+// Accept replaces the text being completed with the highlighted option, and closes the list.
+func (c *Completer) Accept() {
+	c.Cancel()
+	if o, ok := optionAt(c.ActiveIndex, c.Options); ok {
+		if o.Snippet() != "" && c.SnippetReplacer != nil {
+			c.SnippetReplacer(Loc{X: c.X, Y: c.Y}, c.CurrentLocation(), o.Snippet())
+		} else {
+			c.Replacer(Loc{X: c.X, Y: c.Y}, c.CurrentLocation(), o.Text())
+		}
+	}
+	c.Active = false
+}
+
+// Trigger starts autocomplete for the word before the cursor, or at the cursor if there isn't one,
+// whatever was typed last.
+func (c *Completer) Trigger() error {
+	if !c.Enabled() || c.Provider == nil {
+		return nil
+	}
+	n := 0
+	if c.WordRune != nil {
+		n, _ = c.wordBeforeCursor()
+	}
+	c.Logger("completer.Trigger: activating for a word of %d characters", n)
+	c.activate(-n)
+	return c.update()
+}
+
+// scroll moves the list n options down, or up if n is negative, keeping the highlight on a visible
+// option.
+func (c *Completer) scroll(n int) {
+	rows := c.shown.rows
+	c.top = Max(0, Min(c.top+n, len(c.Options)-rows))
+	c.ActiveIndex = Max(c.top, Min(c.ActiveIndex, c.top+rows-1))
+}
+
+// pageSize returns the number of options that PgUp and PgDn move by.
+func (c *Completer) pageSize() int {
+	if c.shown.rows > 0 {
+		return c.shown.rows
+	}
+	return Max(1, c.MaxHeight)
+}
+
 func getOption(i int, options []optionprovider.Option) (toUse string, ok bool) {
+	o, ok := optionAt(i, options)
+	return o.Text(), ok
+}
+
+func optionAt(i int, options []optionprovider.Option) (o optionprovider.Option, ok bool) {
 	if len(options) == 0 {
-		return "", false
+		return o, false
 	}
 	if i > len(options)-1 {
-		return "", false
+		return o, false
 	}
 	if i < 0 {
 		i = 0
 	}
-	return options[i].Text(), true
+	return options[i], true
 }
 
 // DeactivateIfOutOfBounds for example, if duplicating lines or backspacing past the start of the completion.
@@ -314,6 +662,7 @@ func (c *Completer) DeactivateIfOutOfBounds() {
 	movedLine := cur.Y != c.Y
 	if beforeStart || movedMoreThanOneXSinceLastCheck || movedLine {
 		c.Logger("completer.DeactivateIfOutOfBounds: deactivating")
+		c.Cancel()
 		c.Active = false
 	}
 	c.PreviousLocation = cur
@@ -340,41 +689,20 @@ func (c *Completer) Display() {
 	}
 
 	c.Logger("completer.Display: showing %d options", len(c.Options))
-	width := getWidth(c.Options)
-	start := c.CurrentLocation()
-	for iy, o := range c.Options {
-		y := start.Y + iy + 1 // +1 to draw a line below the cursor.
-
-		// If it's active, show it differently.
-		style := c.OptionStyleInactive
-		if c.ActiveIndex == iy {
-			style = c.OptionStyleActive
-		}
-
-		// Draw the runes.
-		for ix, r := range padRight(o.Text(), width+1) {
-			x := start.X + ix
-			c.Setter(x, y, r, nil, style)
-		}
-	}
-}
-
-func getWidth(options []optionprovider.Option) (max int) {
-	for _, o := range options {
-		if l := len(o.Text()); l > max {
-			max = l
-		}
+	// Line the options up with the start of the text being completed.
+	start := Loc{X: c.X, Y: c.CurrentLocation().Y}
+	if c.Position != nil {
+		start = c.Position(start)
+	}
+	p := c.layout(start)
+	c.shown = p
+	if p.rows == 0 {
+		return
 	}
-	return
-}
-
-func padRight(s string, minSize int) string {
-	extra := minSize - len(s)
-	if extra > 0 {
-		padding := make([]byte, extra)
-		return s + string(padding)
+	c.drawOptions(p)
+	if c.ShowDetail {
+		c.drawDetail(p)
 	}
-	return s
 }
 
 func containsRune(array []rune, r rune) bool {
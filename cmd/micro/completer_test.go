@@ -2,8 +2,12 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/zyedidia/micro/cmd/micro/optionprovider"
 	"github.com/zyedidia/tcell"
@@ -33,7 +37,7 @@ func TestCompleterDoesNothingWhenNotEnabledOrProviderNotSet(t *testing.T) {
 		locationOffsetCalled = true
 		return 0
 	}
-	provider := func(l func(s string, values ...interface{}), buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
 		providerCalled = true
 		return
 	}
@@ -92,7 +96,7 @@ func TestCompleterIsDeactivatedByDeactivatorRunes(t *testing.T) {
 	locationOffset := func(Loc) int {
 		return 3
 	}
-	provider := func(l func(s string, values ...interface{}), buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
 		options = []optionprovider.Option{
 			optionprovider.New("text", "hint"),
 		}
@@ -145,7 +149,7 @@ func TestCompleterIsActivatedByActivatorRunes(t *testing.T) {
 	locationOffset := func(Loc) int {
 		return 3
 	}
-	provider := func(l func(s string, values ...interface{}), buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
 		providerReceivedBytes = buffer
 		providerReceivedOffset = currentOffset
 		options = expectedOptions
@@ -202,7 +206,7 @@ func TestCompleterIsDeactivatedByNotReceivingAnyOptions(t *testing.T) {
 	locationOffset := func(Loc) int {
 		return 0
 	}
-	provider := func(l func(s string, values ...interface{}), buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
 		options = []optionprovider.Option{} // No options.
 		return
 	}
@@ -245,7 +249,7 @@ func TestCompleterIsRestartedIfARuneIsAnActivatorAndDeactivator(t *testing.T) {
 	locationOffset := func(Loc) int {
 		return 9
 	}
-	provider := func(l func(s string, values ...interface{}), buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
 		options = []optionprovider.Option{
 			optionprovider.New("test", "test"),
 		}
@@ -293,7 +297,7 @@ func TestCompleterIsNotTriggeredByOtherRunesWhenInactive(t *testing.T) {
 	locationOffset := func(Loc) int {
 		return 0
 	}
-	provider := func(l func(s string, values ...interface{}), buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
 		options = []optionprovider.Option{
 			optionprovider.New("text", "hint"),
 		}
@@ -335,7 +339,7 @@ func TestCompleterAdjustsStartPositionIfOptionProviderMovesIt(t *testing.T) {
 	locationOffset := func(Loc) int {
 		return 9
 	}
-	provider := func(l func(s string, values ...interface{}), buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, startPositionDelta int, err error) {
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, startPositionDelta int, err error) {
 		options = []optionprovider.Option{
 			optionprovider.New("test", "test"),
 		}
@@ -367,6 +371,284 @@ func TestCompleterAdjustsStartPositionIfOptionProviderMovesIt(t *testing.T) {
 	}
 }
 
+func TestCompleterIsActivatedByTriggers(t *testing.T) {
+	text := "p->"
+	currentBytesAndOffset := func() (bytes []byte, offset int) {
+		return []byte(text), len(text)
+	}
+	currentLocation := func() Loc {
+		return Loc{X: len(text), Y: 0}
+	}
+	locationOffset := func(l Loc) int {
+		return l.X
+	}
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
+		options = []optionprovider.Option{
+			optionprovider.New("next", "*node"),
+		}
+		return
+	}
+	c := NewCompleter(nil, nil, provider, t.Logf, currentBytesAndOffset, currentLocation, locationOffset, noopReplacer, noopContentSetter, optionStyleInactive, optionStyleActive, enabledFlagSetToTrue)
+	c.Triggers = []string{".", "->", "::"}
+
+	text = "p-"
+	if err := c.Process('-'); err != nil {
+		t.Fatalf("failed to process with error: %v", err)
+	}
+	if c.Active {
+		t.Errorf("expected '-' not to activate the completer")
+	}
+
+	text = "p->"
+	if err := c.Process('>'); err != nil {
+		t.Fatalf("failed to process with error: %v", err)
+	}
+	if !c.Active {
+		t.Fatalf("expected '->' to activate the completer")
+	}
+	if c.X != 3 {
+		t.Errorf("expected the start position to be after the trigger at x:3, but was %v", c.X)
+	}
+
+	// Triggers restart the completer when it's already active.
+	text = "p->next."
+	if err := c.Process('.'); err != nil {
+		t.Fatalf("failed to process with error: %v", err)
+	}
+	if !c.Active || c.X != 8 {
+		t.Errorf("expected '.' to restart the completer at x:8, but active was %v and x was %v", c.Active, c.X)
+	}
+}
+
+func TestCompleterIsActivatedByWords(t *testing.T) {
+	text := ""
+	currentBytesAndOffset := func() (bytes []byte, offset int) {
+		return []byte(text), len(text)
+	}
+	currentLocation := func() Loc {
+		return Loc{X: utf8.RuneCountInString(text), Y: 0}
+	}
+	locationOffset := func(l Loc) int {
+		return l.X
+	}
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
+		options = []optionprovider.Option{
+			optionprovider.New("$größe", ""),
+		}
+		return
+	}
+
+	tests := []struct {
+		name      string
+		text      string
+		minPrefix int
+		active    bool
+		x         int
+	}{
+		{
+			name:   "a letter starts the completer",
+			text:   "a = g",
+			active: true,
+			x:      4,
+		},
+		{
+			name:   "letters from other scripts are part of words",
+			text:   "a = grö",
+			active: true,
+			x:      4,
+		},
+		{
+			name:   "extra word characters are part of words",
+			text:   "a = $g",
+			active: true,
+			x:      4,
+		},
+		{
+			name:      "short words don't start the completer",
+			text:      "a = gr",
+			minPrefix: 3,
+		},
+		{
+			name:      "words which are long enough start the completer",
+			text:      "a = grö",
+			minPrefix: 3,
+			active:    true,
+			x:         4,
+		},
+		{
+			name: "numbers don't start the completer",
+			text: "a = 12",
+		},
+	}
+
+	for _, test := range tests {
+		c := NewCompleter(nil, nil, provider, t.Logf, currentBytesAndOffset, currentLocation, locationOffset, noopReplacer, noopContentSetter, optionStyleInactive, optionStyleActive, enabledFlagSetToTrue)
+		c.WordRune = func(r rune) bool {
+			return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '$'
+		}
+		c.MinPrefix = test.minPrefix
+		text = test.text
+		r, _ := utf8.DecodeLastRuneInString(text)
+		if err := c.Process(r); err != nil {
+			t.Fatalf("%s: failed to process with error: %v", test.name, err)
+		}
+		if c.Active != test.active {
+			t.Errorf("%s: expected active to be %v, but was %v", test.name, test.active, c.Active)
+		}
+		if c.Active && c.X != test.x {
+			t.Errorf("%s: expected the start position to be x:%v, but was %v", test.name, test.x, c.X)
+		}
+	}
+}
+
+// newBackgroundCompleter creates a completer whose provider waits for release to be closed, and which
+// sends functions which need to run on the main thread to the returned channel.
+func newBackgroundCompleter(location *Loc, release chan struct{}, t *testing.T) (*Completer, chan func()) {
+	activators := map[rune]int{
+		'.': 0,
+	}
+	currentBytesAndOffset := func() (bytes []byte, offset int) {
+		return []byte("fmt."), 4
+	}
+	currentLocation := func() Loc {
+		return *location
+	}
+	locationOffset := func(Loc) int {
+		return 4
+	}
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, startPositionDelta int, err error) {
+		<-release
+		options = []optionprovider.Option{
+			optionprovider.New("Println", "func(a ...interface{})"),
+		}
+		return
+	}
+
+	c := NewCompleter(activators, nil, provider, t.Logf, currentBytesAndOffset, currentLocation, locationOffset, noopReplacer, noopContentSetter, optionStyleInactive, optionStyleActive, enabledFlagSetToTrue)
+	dispatched := make(chan func(), 10)
+	c.Dispatch = func(f func()) {
+		dispatched <- f
+	}
+	return c, dispatched
+}
+
+func runDispatched(dispatched chan func(), t *testing.T) {
+	select {
+	case f := <-dispatched:
+		f()
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the provider's options")
+	}
+}
+
+func TestCompleterProcessDoesNotWaitForProviderWhenDispatchIsSet(t *testing.T) {
+	location := Loc{X: 4, Y: 0}
+	release := make(chan struct{})
+	c, dispatched := newBackgroundCompleter(&location, release, t)
+
+	// The provider is blocked, so this would never return if Process waited for it.
+	err := c.Process('.')
+	if err != nil {
+		t.Fatalf("failed to process with error: %v", err)
+	}
+	if !c.Active {
+		t.Error("expected the completer to be active while the options are being fetched")
+	}
+	if len(c.Options) != 0 {
+		t.Errorf("expected no options until the provider returns, but got %v", c.Options)
+	}
+
+	close(release)
+	runDispatched(dispatched, t)
+	if len(c.Options) != 1 || c.Options[0].Text() != "Println" {
+		t.Errorf("expected the provider's options to be applied, but got %v", c.Options)
+	}
+}
+
+func TestCompleterDiscardsOptionsIfTheCursorMoved(t *testing.T) {
+	location := Loc{X: 4, Y: 0}
+	release := make(chan struct{})
+	c, dispatched := newBackgroundCompleter(&location, release, t)
+
+	err := c.Process('.')
+	if err != nil {
+		t.Fatalf("failed to process with error: %v", err)
+	}
+	location = Loc{X: 5, Y: 0}
+
+	close(release)
+	runDispatched(dispatched, t)
+	if len(c.Options) != 0 {
+		t.Errorf("expected out of date options to be discarded, but got %v", c.Options)
+	}
+}
+
+func TestCompleterDelayWaitsForTyping(t *testing.T) {
+	location := Loc{X: 4, Y: 0}
+	release := make(chan struct{})
+	close(release)
+	c, dispatched := newBackgroundCompleter(&location, release, t)
+	c.Delay = 50 * time.Millisecond
+
+	if err := c.Process('.'); err != nil {
+		t.Fatalf("failed to process with error: %v", err)
+	}
+	// Another key pressed during the delay replaces the first request before the provider is asked.
+	if err := c.Process('.'); err != nil {
+		t.Fatalf("failed to process with error: %v", err)
+	}
+	runDispatched(dispatched, t)
+	if len(c.Options) != 1 {
+		t.Errorf("expected the options of the second request to be applied, but got %v", c.Options)
+	}
+	select {
+	case <-dispatched:
+		t.Error("expected the first request to be dropped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCompleterCancelDropsResults(t *testing.T) {
+	location := Loc{X: 4, Y: 0}
+	release := make(chan struct{})
+	c, dispatched := newBackgroundCompleter(&location, release, t)
+
+	err := c.Process('.')
+	if err != nil {
+		t.Fatalf("failed to process with error: %v", err)
+	}
+	c.Cancel()
+
+	close(release)
+	select {
+	case <-dispatched:
+		t.Error("expected the results of a cancelled request to be dropped")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCompleterCancelStopsProvider(t *testing.T) {
+	location := Loc{X: 4, Y: 0}
+	c, _ := newBackgroundCompleter(&location, nil, t)
+	stopped := make(chan struct{})
+	c.Provider = func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
+		<-cancel
+		close(stopped)
+		return
+	}
+
+	err := c.Process('.')
+	if err != nil {
+		t.Fatalf("failed to process with error: %v", err)
+	}
+	c.Cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("expected the provider to be told that the request was cancelled")
+	}
+}
+
 func TestCompleterHandleEventNotEnabled(t *testing.T) {
 	c := NewCompleter(nil, nil, nil, t.Logf, nil, nil, nil, nil, nil, optionStyleInactive, optionStyleActive, enabledFlagSetToFalse)
 
@@ -432,6 +714,130 @@ func TestCompleterHandleEventKeyDown(t *testing.T) {
 	}
 }
 
+func TestCompleterHandleEventPageKeys(t *testing.T) {
+	c := NewCompleter(nil, nil, nil, t.Logf, nil, nil, nil, nil, nil, optionStyleInactive, optionStyleActive, enabledFlagSetToTrue)
+	c.Active = true
+	for i := 0; i < 25; i++ {
+		c.Options = append(c.Options, optionprovider.New(fmt.Sprintf("text%d", i), ""))
+	}
+	c.MaxHeight = 10
+
+	tests := []struct {
+		key      tcell.Key
+		expected int
+	}{
+		{key: tcell.KeyPgDn, expected: 10},
+		{key: tcell.KeyPgDn, expected: 20},
+		{key: tcell.KeyPgDn, expected: 24},
+		{key: tcell.KeyPgUp, expected: 14},
+		{key: tcell.KeyHome, expected: 0},
+		{key: tcell.KeyPgUp, expected: 0},
+		{key: tcell.KeyEnd, expected: 24},
+	}
+	for i, test := range tests {
+		if !c.HandleEvent(test.key) {
+			t.Errorf("%d: when the completer is active, the key should be handled", i)
+		}
+		if c.ActiveIndex != test.expected {
+			t.Errorf("%d: expected the active index to be %v, but was %v", i, test.expected, c.ActiveIndex)
+		}
+	}
+}
+
+func TestCompleterHandleMouse(t *testing.T) {
+	currentLocation := func() Loc { return Loc{X: 3, Y: 0} }
+	var receivedWith string
+	replacer := func(from, to Loc, with string) {
+		receivedWith = with
+	}
+	c := NewCompleter(nil, nil, nil, t.Logf, nil, currentLocation, nil, replacer, noopContentSetter, optionStyleInactive, optionStyleActive, enabledFlagSetToTrue)
+	c.Active = true
+	for i := 0; i < 5; i++ {
+		c.Options = append(c.Options, optionprovider.New(fmt.Sprintf("text%d", i), ""))
+	}
+	c.MaxHeight = 3
+	c.X = 2
+	// The list is drawn from x:2 to x:8, including the scrollbar, and on lines 1 to 3.
+	c.Display()
+
+	if c.HandleMouse(10, 1, tcell.WheelDown) {
+		t.Error("expected the wheel outside of the list to be left for the view")
+	}
+	if !c.HandleMouse(3, 2, tcell.WheelDown) {
+		t.Fatal("expected the wheel over the list to be handled")
+	}
+	if c.top != 1 || c.ActiveIndex != 1 {
+		t.Errorf("expected the list to scroll by one and keep the highlight visible, but top was %v and the active index %v", c.top, c.ActiveIndex)
+	}
+	c.HandleMouse(3, 2, tcell.WheelDown)
+	c.HandleMouse(3, 2, tcell.WheelDown)
+	if c.top != 2 {
+		t.Errorf("expected the list to stop scrolling at the end, but top was %v", c.top)
+	}
+	c.HandleMouse(3, 2, tcell.WheelUp)
+	if c.top != 1 || c.ActiveIndex != 2 {
+		t.Errorf("expected the list to scroll up by one, but top was %v and the active index %v", c.top, c.ActiveIndex)
+	}
+
+	c.Display()
+	if !c.HandleMouse(4, 3, tcell.Button1) {
+		t.Fatal("expected a click on an option to be handled")
+	}
+	if receivedWith != "text3" {
+		t.Errorf("expected the clicked option text3 to be used, but got %q", receivedWith)
+	}
+	if c.Active {
+		t.Error("expected accepting an option to close the list")
+	}
+
+	c.Active = true
+	c.Display()
+	if c.HandleMouse(20, 5, tcell.Button1) {
+		t.Error("expected a click outside of the list to be left for the view")
+	}
+	if c.Active {
+		t.Error("expected a click outside of the list to close it")
+	}
+}
+
+func TestCompleterTrigger(t *testing.T) {
+	text := "a = fo"
+	currentBytesAndOffset := func() (bytes []byte, offset int) {
+		return []byte(text), len(text)
+	}
+	currentLocation := func() Loc {
+		return Loc{X: len(text), Y: 0}
+	}
+	locationOffset := func(l Loc) int {
+		return l.X
+	}
+	var providerStart int
+	provider := func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, delta int, err error) {
+		providerStart = startOffset
+		options = []optionprovider.Option{
+			optionprovider.New("foo", ""),
+		}
+		return
+	}
+	c := NewCompleter(nil, nil, provider, t.Logf, currentBytesAndOffset, currentLocation, locationOffset, noopReplacer, noopContentSetter, optionStyleInactive, optionStyleActive, enabledFlagSetToTrue)
+	c.WordRune = unicode.IsLetter
+	// The word is too short to start the completer by itself.
+	c.MinPrefix = 3
+
+	if err := c.Trigger(); err != nil {
+		t.Fatalf("failed to trigger with error: %v", err)
+	}
+	if !c.Active {
+		t.Fatal("expected Trigger to activate the completer")
+	}
+	if c.X != 4 || providerStart != 4 {
+		t.Errorf("expected the options to replace the word before the cursor from x:4, but the start was %v and the provider got %v", c.X, providerStart)
+	}
+	if len(c.Options) != 1 {
+		t.Errorf("expected the provider's options, but got %v", c.Options)
+	}
+}
+
 func TestCompleterHandleEventKeyEscape(t *testing.T) {
 	c := NewCompleter(nil, nil, nil, t.Logf, nil, nil, nil, nil, nil, optionStyleInactive, optionStyleActive, enabledFlagSetToTrue)
 
@@ -497,6 +903,34 @@ func testCompleterHandleEventCompletion(key tcell.Key, t *testing.T) {
 	}
 }
 
+func TestCompleterHandleEventCompletesSnippets(t *testing.T) {
+	currentLocation := func() Loc { return Loc{X: 2, Y: 0} }
+	var replacedWith, snippetBody string
+	replacer := func(from, to Loc, with string) {
+		replacedWith = with
+	}
+	c := NewCompleter(nil, nil, nil, t.Logf, nil, currentLocation, nil, replacer, nil, optionStyleInactive, optionStyleActive, enabledFlagSetToTrue)
+	c.Active = true
+	c.Options = []optionprovider.Option{
+		{T: "f(a)", S: "f(${1:a})"},
+	}
+
+	// Without a snippet replacer, the text is used.
+	c.HandleEvent(tcell.KeyTab)
+	if replacedWith != "f(a)" {
+		t.Errorf("expected the text to be used without a snippet replacer, got %q", replacedWith)
+	}
+
+	c.Active = true
+	c.SnippetReplacer = func(from, to Loc, body string) {
+		snippetBody = body
+	}
+	c.HandleEvent(tcell.KeyTab)
+	if snippetBody != "f(${1:a})" {
+		t.Errorf("expected the snippet to be inserted, got %q", snippetBody)
+	}
+}
+
 func TestCompleterHandleEventKeyWhenActive(t *testing.T) {
 	c := NewCompleter(nil, nil, nil, t.Logf, nil, nil, nil, nil, nil, optionStyleInactive, optionStyleActive, enabledFlagSetToTrue)
 
@@ -720,6 +1154,7 @@ func TestCompleterDisplayRendersOptionsWhenActive(t *testing.T) {
 			selectedOptionIndex: -1,
 			expected: displayMap{
 				Loc{Y: 1, X: 0}: rs{'T', acs}, Loc{Y: 1, X: 1}: rs{'e', acs}, Loc{Y: 1, X: 2}: rs{'x', acs}, Loc{Y: 1, X: 3}: rs{'t', acs}, Loc{Y: 1, X: 4}: rs{rune(0), acs},
+				Loc{Y: 1, X: 5}: rs{'H', acs}, Loc{Y: 1, X: 6}: rs{'i', acs}, Loc{Y: 1, X: 7}: rs{'n', acs}, Loc{Y: 1, X: 8}: rs{'t', acs}, Loc{Y: 1, X: 9}: rs{rune(0), acs},
 			},
 		},
 		{
@@ -731,7 +1166,9 @@ func TestCompleterDisplayRendersOptionsWhenActive(t *testing.T) {
 			selectedOptionIndex: -1,
 			expected: displayMap{
 				Loc{Y: 1, X: 0}: rs{'T', acs}, Loc{Y: 1, X: 1}: rs{'e', acs}, Loc{Y: 1, X: 2}: rs{'x', acs}, Loc{Y: 1, X: 3}: rs{'t', acs}, Loc{Y: 1, X: 4}: rs{0, acs}, Loc{Y: 1, X: 5}: rs{0, acs},
+				Loc{Y: 1, X: 6}: rs{'H', acs}, Loc{Y: 1, X: 7}: rs{'i', acs}, Loc{Y: 1, X: 8}: rs{'n', acs}, Loc{Y: 1, X: 9}: rs{'t', acs}, Loc{Y: 1, X: 10}: rs{0, acs}, Loc{Y: 1, X: 11}: rs{0, acs},
 				Loc{Y: 2, X: 0}: rs{'T', acs}, Loc{Y: 2, X: 1}: rs{'e', acs}, Loc{Y: 2, X: 2}: rs{'x', acs}, Loc{Y: 2, X: 3}: rs{'t', acs}, Loc{Y: 2, X: 4}: rs{'2', acs}, Loc{Y: 2, X: 5}: rs{0, acs},
+				Loc{Y: 2, X: 6}: rs{'H', acs}, Loc{Y: 2, X: 7}: rs{'i', acs}, Loc{Y: 2, X: 8}: rs{'n', acs}, Loc{Y: 2, X: 9}: rs{'t', acs}, Loc{Y: 2, X: 10}: rs{'2', acs}, Loc{Y: 2, X: 11}: rs{0, acs},
 			},
 		},
 		{
@@ -743,7 +1180,45 @@ func TestCompleterDisplayRendersOptionsWhenActive(t *testing.T) {
 			selectedOptionIndex: 1,
 			expected: displayMap{
 				Loc{Y: 1, X: 0}: rs{'T', acs}, Loc{Y: 1, X: 1}: rs{'e', acs}, Loc{Y: 1, X: 2}: rs{'x', acs}, Loc{Y: 1, X: 3}: rs{'t', acs}, Loc{Y: 1, X: 4}: rs{0, acs}, Loc{Y: 1, X: 5}: rs{0, acs},
+				Loc{Y: 1, X: 6}: rs{'H', acs}, Loc{Y: 1, X: 7}: rs{'i', acs}, Loc{Y: 1, X: 8}: rs{'n', acs}, Loc{Y: 1, X: 9}: rs{'t', acs}, Loc{Y: 1, X: 10}: rs{0, acs}, Loc{Y: 1, X: 11}: rs{0, acs},
 				Loc{Y: 2, X: 0}: rs{'T', act}, Loc{Y: 2, X: 1}: rs{'e', act}, Loc{Y: 2, X: 2}: rs{'x', act}, Loc{Y: 2, X: 3}: rs{'t', act}, Loc{Y: 2, X: 4}: rs{'2', act}, Loc{Y: 2, X: 5}: rs{0, act},
+				Loc{Y: 2, X: 6}: rs{'H', act}, Loc{Y: 2, X: 7}: rs{'i', act}, Loc{Y: 2, X: 8}: rs{'n', act}, Loc{Y: 2, X: 9}: rs{'t', act}, Loc{Y: 2, X: 10}: rs{'2', act}, Loc{Y: 2, X: 11}: rs{0, act},
+			},
+		},
+		{
+			name: "hints are lined up",
+			options: []optionprovider.Option{
+				optionprovider.New("a", "x"),
+				optionprovider.New("bc", "y"),
+			},
+			selectedOptionIndex: 0,
+			expected: displayMap{
+				Loc{Y: 1, X: 0}: rs{'a', act}, Loc{Y: 1, X: 1}: rs{0, act}, Loc{Y: 1, X: 2}: rs{0, act}, Loc{Y: 1, X: 3}: rs{'x', act}, Loc{Y: 1, X: 4}: rs{0, act},
+				Loc{Y: 2, X: 0}: rs{'b', acs}, Loc{Y: 2, X: 1}: rs{'c', acs}, Loc{Y: 2, X: 2}: rs{0, acs}, Loc{Y: 2, X: 3}: rs{'y', acs}, Loc{Y: 2, X: 4}: rs{0, acs},
+			},
+		},
+		{
+			name: "wide characters take up two columns",
+			options: []optionprovider.Option{
+				optionprovider.New("世界", "x"),
+				optionprovider.New("a", "y"),
+			},
+			selectedOptionIndex: -1,
+			expected: displayMap{
+				Loc{Y: 1, X: 0}: rs{'世', acs}, Loc{Y: 1, X: 2}: rs{'界', acs}, Loc{Y: 1, X: 4}: rs{0, acs}, Loc{Y: 1, X: 5}: rs{'x', acs}, Loc{Y: 1, X: 6}: rs{0, acs},
+				Loc{Y: 2, X: 0}: rs{'a', acs}, Loc{Y: 2, X: 1}: rs{0, acs}, Loc{Y: 2, X: 2}: rs{0, acs}, Loc{Y: 2, X: 3}: rs{0, acs}, Loc{Y: 2, X: 4}: rs{0, acs}, Loc{Y: 2, X: 5}: rs{'y', acs}, Loc{Y: 2, X: 6}: rs{0, acs},
+			},
+		},
+		{
+			name: "kinds are shown as icons before the text",
+			options: []optionprovider.Option{
+				{T: "f", K: optionprovider.KindFunc},
+				{T: "g"},
+			},
+			selectedOptionIndex: 0,
+			expected: displayMap{
+				Loc{Y: 1, X: -2}: rs{'f', act}, Loc{Y: 1, X: -1}: rs{0, act}, Loc{Y: 1, X: 0}: rs{'f', act}, Loc{Y: 1, X: 1}: rs{0, act},
+				Loc{Y: 2, X: -2}: rs{' ', acs}, Loc{Y: 2, X: -1}: rs{0, acs}, Loc{Y: 2, X: 0}: rs{'g', acs}, Loc{Y: 2, X: 1}: rs{0, acs},
 			},
 		},
 	}
@@ -860,3 +1335,58 @@ func TestDeactivateIfOutOfBounds(t *testing.T) {
 		}
 	}
 }
+
+func TestCompletionDeltas(t *testing.T) {
+	lines := []string{
+		"a := fo",
+		"b := fo + fo",
+		"c := ba",
+	}
+	line := func(y int) []rune {
+		return []rune(lines[y])
+	}
+	locs := []Loc{{X: 7, Y: 0}, {X: 7, Y: 1}, {X: 12, Y: 1}, {X: 7, Y: 2}}
+
+	deltas := completionDeltas(line, locs, Loc{X: 5, Y: 0}, Loc{X: 7, Y: 0}, "foo")
+	expected := []Delta{
+		{Text: "foo", Start: Loc{X: 10, Y: 1}, End: Loc{X: 12, Y: 1}},
+		{Text: "foo", Start: Loc{X: 5, Y: 1}, End: Loc{X: 7, Y: 1}},
+		{Text: "foo", Start: Loc{X: 5, Y: 0}, End: Loc{X: 7, Y: 0}},
+	}
+	if !reflect.DeepEqual(deltas, expected) {
+		t.Fatalf("expected deltas %v, got %v", expected, deltas)
+	}
+
+	moved := []Loc{{X: 8, Y: 0}, {X: 8, Y: 1}, {X: 14, Y: 1}, {X: 7, Y: 2}}
+	for i, l := range locs {
+		if actual := locAfterDeltas(l, deltas); actual != moved[i] {
+			t.Errorf("expected %v to move to %v, got %v", l, moved[i], actual)
+		}
+	}
+}
+
+func TestCompletionDeltasOverlapping(t *testing.T) {
+	line := func(y int) []rune {
+		return []rune("aaa")
+	}
+	locs := []Loc{{X: 2, Y: 0}, {X: 3, Y: 0}, {X: 3, Y: 0}}
+
+	// The duplicate cursor is only completed once.
+	deltas := completionDeltas(line, locs, Loc{X: 1, Y: 0}, Loc{X: 2, Y: 0}, "ab")
+	expected := []Delta{
+		{Text: "ab", Start: Loc{X: 2, Y: 0}, End: Loc{X: 3, Y: 0}},
+		{Text: "ab", Start: Loc{X: 1, Y: 0}, End: Loc{X: 2, Y: 0}},
+	}
+	if !reflect.DeepEqual(deltas, expected) {
+		t.Errorf("expected deltas %v, got %v", expected, deltas)
+	}
+
+	// The text before the other cursor overlaps the text being completed, so it's left alone.
+	deltas = completionDeltas(line, locs, Loc{X: 0, Y: 0}, Loc{X: 2, Y: 0}, "aab")
+	expected = []Delta{
+		{Text: "aab", Start: Loc{X: 0, Y: 0}, End: Loc{X: 2, Y: 0}},
+	}
+	if !reflect.DeepEqual(deltas, expected) {
+		t.Errorf("expected deltas %v, got %v", expected, deltas)
+	}
+}
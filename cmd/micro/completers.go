@@ -0,0 +1,255 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/zyedidia/micro/cmd/micro/optionprovider"
+	"github.com/zyedidia/micro/cmd/micro/snippet"
+)
+
+// completerProviders holds the completion providers which can be chosen with the
+// "completer.<filetype>" option. Each entry creates the provider for a view.
+var completerProviders = map[string]func(v *View) OptionProvider{
+	"generic": func(v *View) OptionProvider {
+		indexBuffer(v.Buf)
+		return optionprovider.NewGeneric(int(globalSettings["autocompletelimit"].(float64)), wordIndex)
+	},
+	"gocode": func(*View) OptionProvider { return optionprovider.GoCode },
+	"lsp":    LSPProviderForView,
+	"noop":   func(*View) OptionProvider { return optionprovider.Noop },
+}
+
+// RegisterCompleter makes the lua function available as a completion provider
+// called name, which can then be used in the "completer.<filetype>" option.
+// See LuaFunctionCompleter for the arguments and return value of the function
+func RegisterCompleter(name, function string) {
+	completerProviders[name] = func(*View) OptionProvider {
+		return LuaFunctionCompleter(function)
+	}
+}
+
+// CompleterNames returns the names of the completion providers for a filetype.
+// They are set with the "completer.<filetype>" option, either as a list or as a
+// comma separated string. Without the option, the language server is used if one
+// is configured, then gocode for Go, and the generic provider for everything else.
+func CompleterNames(filetype string) (names []string) {
+	switch setting := globalSettings["completer."+filetype].(type) {
+	case []interface{}:
+		for _, n := range setting {
+			if name, ok := n.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return
+	case string:
+		for _, name := range strings.Split(setting, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		return
+	}
+
+	if LSPCommand(filetype) != nil {
+		return []string{"lsp"}
+	}
+	if filetype == "go" {
+		return []string{"gocode"}
+	}
+	return []string{"generic"}
+}
+
+// CompleterProviderForView returns the provider configured for the filetype of
+// the view's buffer. If several are configured, their options are merged.
+func CompleterProviderForView(v *View) OptionProvider {
+	if v.Buf.LargeFile {
+		// The providers would need all of the text.
+		return optionprovider.Noop
+	}
+	var providers []OptionProvider
+	for _, name := range CompleterNames(v.Buf.FileType()) {
+		newProvider, ok := completerProviders[name]
+		if !ok {
+			messenger.AddLog("completer: unknown provider ", name)
+			continue
+		}
+		providers = append(providers, newProvider(v))
+	}
+
+	switch len(providers) {
+	case 0:
+		// If no matching provider was found, we can't autocomplete.
+		return optionprovider.Noop
+	case 1:
+		return providers[0]
+	}
+	return ChainProviders(providers...)
+}
+
+// ReloadCompleter replaces the view's completer, so that changes to the options
+// which choose the completion providers take effect.
+func ReloadCompleter(v *View) {
+	if v.Completer != nil {
+		v.Completer.Cancel()
+	}
+	v.Completer = NewCompleterForView(v)
+}
+
+// filetypeActivators holds the sequences which start completion in filetypes
+// where "." and "(" aren't enough, used when "autocompleteactivators" isn't set.
+var filetypeActivators = map[string]string{
+	"c":           ". ( ->",
+	"c++":         ". ( -> ::",
+	"objective-c": ". ( ->",
+	"php":         "( -> :: $",
+	"perl":        "( -> :: $",
+	"ruby":        ". ( ::",
+	"rust":        ". ( ::",
+	"lua":         ". ( :",
+}
+
+// defaultActivators are the sequences which start completion in other filetypes.
+const defaultActivators = ". ("
+
+// filetypeWordRunes holds the characters, apart from letters, digits and
+// underscores, which can be part of a name in each filetype.
+var filetypeWordRunes = map[string]string{
+	"javascript":   "$",
+	"typescript":   "$",
+	"coffeescript": "$",
+	"php":          "$",
+	"css":          "-",
+	"lisp":         "-",
+	"clojure":      "-",
+}
+
+// CompleterTriggers returns the sequences which start completion in a buffer.
+// They're set with the "autocompleteactivators" option as a space separated
+// list, and default to the ones for the buffer's filetype. The language
+// server's trigger characters are added if the buffer is open on one.
+func CompleterTriggers(b *Buffer) []string {
+	setting, _ := b.Settings["autocompleteactivators"].(string)
+	if setting == "" {
+		setting = defaultActivators
+		if a, ok := filetypeActivators[b.FileType()]; ok {
+			setting = a
+		}
+	}
+	return mergeTriggers(strings.Fields(setting), lspTriggerCharacters(b))
+}
+
+// mergeTriggers adds the triggers in extra which aren't in triggers already.
+func mergeTriggers(triggers, extra []string) []string {
+	merged := append([]string(nil), triggers...)
+outer:
+	for _, e := range extra {
+		for _, t := range merged {
+			if t == e {
+				continue outer
+			}
+		}
+		merged = append(merged, e)
+	}
+	return merged
+}
+
+// CompleterDeactivators returns the characters which stop completion in a
+// buffer, which are whitespace and those in the "autocompletedeactivators"
+// option.
+func CompleterDeactivators(b *Buffer) []rune {
+	setting, _ := b.Settings["autocompletedeactivators"].(string)
+	return append([]rune(" \t\n"), []rune(setting)...)
+}
+
+// CompleterWordRune returns a function which says whether a character can be
+// part of a name in the filetype. Letters and digits from every script are.
+func CompleterWordRune(filetype string) func(r rune) bool {
+	extra := filetypeWordRunes[filetype]
+	return func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || strings.ContainsRune(extra, r)
+	}
+}
+
+// isCompleterOption returns whether the option changes how completers are created,
+// so that they need to be reloaded when it's set.
+func isCompleterOption(option string) bool {
+	switch option {
+	case "autocompletedetail", "autocompletelimit", "autocompleteproject", "autocompleteactivators",
+		"autocompletedeactivators", "autocompleteminlength", "autocompletedelay":
+		return true
+	}
+	return strings.HasPrefix(option, "completer.") || strings.HasPrefix(option, "lsp.")
+}
+
+// ChainProviders returns a provider which merges the options of several providers,
+// in order, leaving out options which have already been offered. Providers which
+// fail are skipped; an error is only returned if all of them fail.
+func ChainProviders(providers ...OptionProvider) OptionProvider {
+	return func(logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) ([]optionprovider.Option, int, error) {
+		type result struct {
+			options []optionprovider.Option
+			delta   int
+		}
+		var results []result
+		var err error
+		for _, provider := range providers {
+			select {
+			case <-cancel:
+				return nil, 0, nil
+			default:
+			}
+			options, delta, perr := provider(logger, cancel, buffer, startOffset, currentOffset)
+			if perr != nil {
+				logger("completer.ChainProviders: provider failed: %v", perr)
+				err = perr
+				continue
+			}
+			results = append(results, result{options, delta})
+		}
+		if len(results) == 0 {
+			return nil, 0, err
+		}
+
+		// Providers can move the start of the text to replace by different amounts, so
+		// use the earliest start and prefix options with the text that they would keep.
+		delta := results[0].delta
+		for _, r := range results {
+			if r.delta < delta {
+				delta = r.delta
+			}
+		}
+
+		var merged []optionprovider.Option
+		seen := make(map[string]bool)
+		for _, r := range results {
+			prefix := textBetween(buffer, startOffset+delta, startOffset+r.delta)
+			for _, o := range r.options {
+				o.T = prefix + o.T
+				if o.S != "" {
+					o.S = snippet.Escape(prefix) + o.S
+				}
+				if seen[o.T] {
+					continue
+				}
+				seen[o.T] = true
+				merged = append(merged, o)
+			}
+		}
+		return merged, delta, nil
+	}
+}
+
+// textBetween returns the text between two offsets in buffer, clamped to its bounds.
+func textBetween(buffer []byte, from, to int) string {
+	if from < 0 {
+		from = 0
+	}
+	if to > len(buffer) {
+		to = len(buffer)
+	}
+	if from >= to {
+		return ""
+	}
+	return string(buffer[from:to])
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/zyedidia/micro/cmd/micro/optionprovider"
+)
+
+func staticProvider(delta int, err error, texts ...string) OptionProvider {
+	return func(l func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, startOffsetDelta int, e error) {
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, text := range texts {
+			options = append(options, optionprovider.New(text, ""))
+		}
+		return options, delta, nil
+	}
+}
+
+func TestChainProviders(t *testing.T) {
+	tests := []struct {
+		name          string
+		providers     []OptionProvider
+		expected      []string
+		expectedDelta int
+		expectedErr   bool
+	}{
+		{
+			name:      "options are merged in order",
+			providers: []OptionProvider{staticProvider(0, nil, "Println", "Printf"), staticProvider(0, nil, "Print")},
+			expected:  []string{"Println", "Printf", "Print"},
+		},
+		{
+			name:      "duplicates are removed",
+			providers: []OptionProvider{staticProvider(0, nil, "Println", "Printf"), staticProvider(0, nil, "Printf", "Print")},
+			expected:  []string{"Println", "Printf", "Print"},
+		},
+		{
+			name:          "options are moved to the earliest start",
+			providers:     []OptionProvider{staticProvider(0, nil, "intln"), staticProvider(-2, nil, "Println", "Print")},
+			expected:      []string{"Println", "Print"},
+			expectedDelta: -2,
+		},
+		{
+			name:      "failing providers are skipped",
+			providers: []OptionProvider{staticProvider(0, errors.New("failed")), staticProvider(0, nil, "Print")},
+			expected:  []string{"Print"},
+		},
+		{
+			name:        "an error is returned if every provider fails",
+			providers:   []OptionProvider{staticProvider(0, errors.New("failed")), staticProvider(0, errors.New("failed"))},
+			expectedErr: true,
+		},
+	}
+
+	// The text being completed is "Pr", and the provider with a delta of zero
+	// completes the text after it.
+	buffer := []byte("fmt.Pr")
+	for _, test := range tests {
+		options, delta, err := ChainProviders(test.providers...)(t.Logf, nil, buffer, 6, 6)
+		if test.expectedErr != (err != nil) {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.expectedErr, err)
+		}
+		var actual []string
+		for _, o := range options {
+			actual = append(actual, o.Text())
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+		if delta != test.expectedDelta {
+			t.Errorf("%s: expected delta %d, got %d", test.name, test.expectedDelta, delta)
+		}
+	}
+}
+
+func TestCompleterNames(t *testing.T) {
+	defer func(settings map[string]interface{}) {
+		globalSettings = settings
+	}(globalSettings)
+
+	tests := []struct {
+		filetype string
+		settings map[string]interface{}
+		expected []string
+	}{
+		{filetype: "go", settings: map[string]interface{}{}, expected: []string{"gocode"}},
+		{filetype: "python", settings: map[string]interface{}{}, expected: []string{"generic"}},
+		{filetype: "go", settings: map[string]interface{}{"lsp.go": "gopls"}, expected: []string{"lsp"}},
+		{
+			filetype: "go",
+			settings: map[string]interface{}{"completer.go": []interface{}{"gocode", "generic"}},
+			expected: []string{"gocode", "generic"},
+		},
+		{
+			filetype: "lua",
+			settings: map[string]interface{}{"completer.lua": "mylua, generic"},
+			expected: []string{"mylua", "generic"},
+		},
+	}
+
+	for _, test := range tests {
+		globalSettings = test.settings
+		actual := CompleterNames(test.filetype)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s with %v: expected %v, got %v", test.filetype, test.settings, test.expected, actual)
+		}
+	}
+}
+
+func TestCompleterTriggers(t *testing.T) {
+	tests := []struct {
+		filetype   string
+		activators string
+		expected   []string
+	}{
+		{filetype: "python", expected: []string{".", "("}},
+		{filetype: "c++", expected: []string{".", "(", "->", "::"}},
+		{filetype: "c++", activators: ". ::", expected: []string{".", "::"}},
+	}
+
+	for _, test := range tests {
+		b := &Buffer{Settings: map[string]interface{}{
+			"filetype":               test.filetype,
+			"autocompleteactivators": test.activators,
+		}}
+		actual := CompleterTriggers(b)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s with %q: expected %v, got %v", test.filetype, test.activators, test.expected, actual)
+		}
+	}
+
+	merged := mergeTriggers([]string{".", "("}, []string{".", ":"})
+	if !reflect.DeepEqual(merged, []string{".", "(", ":"}) {
+		t.Errorf("expected the language server's triggers to be added once, got %v", merged)
+	}
+}
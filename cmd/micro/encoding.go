@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Buffers hold their text in UTF-8. A file in another encoding is decoded when
+// it's opened, and encoded again when it's saved, in the encoding given by the
+// encoding option. The encoding of a file is detected when it's opened, unless
+// the file is just ASCII, which reads the same in all of them.
+
+var byteOrderMarks = []struct {
+	encoding string
+	bom      []byte
+}{
+	{"utf-8", []byte{0xEF, 0xBB, 0xBF}},
+	{"utf-16le", []byte{0xFF, 0xFE}},
+	{"utf-16be", []byte{0xFE, 0xFF}},
+}
+
+// findEncoding returns the encoding with the given name, which may be any of
+// the names used for it on the web, such as "latin1" or "sjis"
+func findEncoding(name string) (encoding.Encoding, error) {
+	e, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, errors.New("Unknown encoding " + name)
+	}
+	return e, nil
+}
+
+// isUTF8 returns whether name is a name of UTF-8
+func isUTF8(name string) bool {
+	e, err := findEncoding(name)
+	return err == nil && e == unicode.UTF8
+}
+
+// byteOrderMark returns the byte order mark of the named encoding, or nil if
+// it doesn't have one
+func byteOrderMark(name string) []byte {
+	e, _ := findEncoding(name)
+	for _, m := range byteOrderMarks {
+		if f, _ := findEncoding(m.encoding); e != nil && e == f {
+			return m.bom
+		}
+	}
+	return nil
+}
+
+// detectEncoding guesses the encoding of data, and returns its name and the
+// length of its byte order mark, if it starts with one. The name is empty if
+// data is just ASCII.
+func detectEncoding(data []byte) (name string, bom int) {
+	for _, m := range byteOrderMarks {
+		if bytes.HasPrefix(data, m.bom) {
+			return m.encoding, len(m.bom)
+		}
+	}
+	if name := detectUTF16(data); name != "" {
+		return name, 0
+	}
+
+	ascii := true
+	for _, c := range data {
+		if c >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return "", 0
+	}
+	if utf8.Valid(data) {
+		return "utf-8", 0
+	}
+
+	// The other encodings can only be told apart by which of them the text is
+	// valid in. Shift_JIS and GBK overlap a lot, but Japanese text almost
+	// always has some kana, which decode to Chinese characters in GBK.
+	sjis, sjisValid := decodesCleanly(data, "shift_jis")
+	if sjisValid && bytes.IndexFunc(sjis, isKana) >= 0 {
+		return "shift_jis", 0
+	}
+	if _, gbkValid := decodesCleanly(data, "gbk"); gbkValid {
+		return "gbk", 0
+	}
+	if sjisValid {
+		return "shift_jis", 0
+	}
+	// Every byte is a character in Latin-1
+	return "iso-8859-1", 0
+}
+
+// detectUTF16 returns the byte order of data if it looks like mostly ASCII
+// text in UTF-16 without a byte order mark, in which every other byte is 0.
+func detectUTF16(data []byte) string {
+	if len(data) < 2 || len(data)%2 != 0 {
+		return ""
+	}
+	sample := data[:Min(len(data), 4096)]
+	var zeros [2]int
+	for i, c := range sample {
+		if c == 0 {
+			zeros[i%2]++
+		}
+	}
+	pairs := len(sample) / 2
+	for i, name := range []string{"utf-16be", "utf-16le"} {
+		if zeros[i] > pairs*3/4 && zeros[1-i] <= pairs/10 {
+			if _, ok := decodesCleanly(data, name); ok {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// decodesCleanly decodes data from the named encoding, and returns whether
+// all of it was valid
+func decodesCleanly(data []byte, name string) ([]byte, bool) {
+	text, err := decodeText(data, name)
+	return text, err == nil && !bytes.ContainsRune(text, utf8.RuneError)
+}
+
+func isKana(r rune) bool {
+	return r >= 0x3040 && r <= 0x30FF
+}
+
+// decodeText converts data from the named encoding to UTF-8. Invalid data is
+// replaced with U+FFFD.
+func decodeText(data []byte, name string) ([]byte, error) {
+	e, err := findEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	if e == unicode.UTF8 {
+		return data, nil
+	}
+	return e.NewDecoder().Bytes(data)
+}
+
+// encodeText converts UTF-8 text to the named encoding
+func encodeText(text []byte, name string) ([]byte, error) {
+	e, err := findEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	if e == unicode.UTF8 {
+		return text, nil
+	}
+	data, err := e.NewEncoder().Bytes(text)
+	if err != nil {
+		return nil, errors.New("Some characters can't be written in " + name)
+	}
+	return data, nil
+}
+
+// encodingSampleSize is how much of the start of a file its encoding is
+// detected from
+const encodingSampleSize = 64 * 1024
+
+// readText reads the contents of a file which is opened, decoding them as
+// they're read, and returns the lines, the encoding of the file, which is
+// empty if its start is just ASCII, and whether it started with a byte order
+// mark. The encoding is detected from the start of the file, so that the
+// file isn't kept in memory both before and after it's decoded. If reading
+// fails, the lines which were read before the error are returned with it.
+func readText(reader io.Reader) (la *LineArray, name string, bom bool, err error) {
+	br := bufio.NewReaderSize(reader, encodingSampleSize)
+	start, err := br.Peek(encodingSampleSize)
+	if err != nil && err != io.EOF {
+		return NewLineArray(0, bytes.NewReader(start)), "", false, err
+	}
+	name, n := detectEncoding(encodingSample(start, err == io.EOF))
+	br.Discard(n)
+
+	var r io.Reader = br
+	if name != "" {
+		if e, _ := findEncoding(name); e != nil && e != unicode.UTF8 {
+			r = transform.NewReader(br, e.NewDecoder())
+		}
+	}
+	la, err = readLineArray(r)
+	return la, name, n > 0, err
+}
+
+// encodingSample returns the part of the start of a file which its encoding
+// is detected from. Unless it's the whole file, it's cut so that it doesn't
+// end with part of a character: after a newline, which isn't part of any
+// other character in the encodings which are detected, and to an even length
+// for UTF-16.
+func encodingSample(start []byte, whole bool) []byte {
+	if whole {
+		return start
+	}
+	i := bytes.LastIndexByte(start, '\n')
+	if i >= 0 {
+		start = start[:i+1]
+	}
+	start = start[:len(start)&^1]
+	if i < 0 {
+		// At least UTF-8 isn't cut in the middle of a character
+		for j := len(start) - 1; j >= 0 && j >= len(start)-utf8.UTFMax; j-- {
+			if utf8.RuneStart(start[j]) {
+				if !utf8.FullRune(start[j:]) {
+					start = start[:j]
+				}
+				break
+			}
+		}
+	}
+	return start
+}
+
+// decode converts the contents of the buffer's file to UTF-8 from the
+// buffer's encoding
+func (b *Buffer) decode(data []byte) ([]byte, error) {
+	name := b.Settings["encoding"].(string)
+	bom := byteOrderMark(name)
+	b.bom = bom != nil && bytes.HasPrefix(data, bom)
+	if b.bom {
+		data = data[len(bom):]
+	}
+	return decodeText(data, name)
+}
+
+// isEncoded returns whether the text of the buffer is changed when it's
+// saved, rather than written as it is
+func (b *Buffer) isEncoded() bool {
+	return b.bom || !isUTF8(b.Settings["encoding"].(string))
+}
+
+// encode returns the text of the buffer as it's written to its file, with
+// format as the line ending of lines which have none, in the buffer's encoding
+func (b *Buffer) encode(format lineEnding) ([]byte, error) {
+	var text bytes.Buffer
+	if _, err := b.write(&text, format); err != nil {
+		return nil, err
+	}
+	name := b.Settings["encoding"].(string)
+	data, err := encodeText(text.Bytes(), name)
+	if err != nil {
+		return nil, err
+	}
+	if bom := byteOrderMark(name); b.bom && bom != nil {
+		data = append(append([]byte(nil), bom...), data...)
+	}
+	return data, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+		bom      int
+	}{
+		{"ascii", []byte("hello\nworld\n"), "", 0},
+		{"empty", nil, "", 0},
+		{"utf-8", []byte("naïve café\n"), "utf-8", 0},
+		{"utf-8 bom", []byte("\xEF\xBB\xBFhello"), "utf-8", 3},
+		{"utf-16le bom", []byte("\xFF\xFEh\x00i\x00"), "utf-16le", 2},
+		{"utf-16be bom", []byte("\xFE\xFF\x00h\x00i"), "utf-16be", 2},
+		{"utf-16le", []byte("h\x00e\x00l\x00l\x00o\x00\n\x00"), "utf-16le", 0},
+		{"utf-16be", []byte("\x00h\x00e\x00l\x00l\x00o\x00\n"), "utf-16be", 0},
+		// "café" in Latin-1
+		{"latin-1", []byte("caf\xE9\n"), "iso-8859-1", 0},
+		// "日本語のテキスト" in Shift_JIS
+		{"shift_jis", []byte("\x93\xFA\x96\x7B\x8C\xEA\x82\xCC\x83\x65\x83\x4C\x83\x58\x83\x67\n"), "shift_jis", 0},
+		// "中文文本" in GBK
+		{"gbk", []byte("\xD6\xD0\xCE\xC4\xCE\xC4\xB1\xBE\n"), "gbk", 0},
+	}
+
+	for _, test := range tests {
+		name, bom := detectEncoding(test.data)
+		if name != test.expected || bom != test.bom {
+			t.Errorf("%s: expected %q with a %d byte BOM, got %q with %d", test.name, test.expected, test.bom, name, bom)
+		}
+	}
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	tests := []struct {
+		encoding string
+		text     string
+	}{
+		{"utf-8", "naïve café"},
+		{"utf-16le", "hello ☺"},
+		{"utf-16be", "hello ☺"},
+		{"iso-8859-1", "café"},
+		{"shift_jis", "日本語のテキスト"},
+		{"gbk", "中文文本"},
+	}
+
+	for _, test := range tests {
+		data, err := encodeText([]byte(test.text), test.encoding)
+		if err != nil {
+			t.Errorf("%s: %v", test.encoding, err)
+			continue
+		}
+		text, err := decodeText(data, test.encoding)
+		if err != nil {
+			t.Errorf("%s: %v", test.encoding, err)
+			continue
+		}
+		if string(text) != test.text {
+			t.Errorf("%s: expected %q, got %q", test.encoding, test.text, text)
+		}
+	}
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	if _, err := encodeText([]byte("☺"), "shift_jis"); err == nil {
+		t.Errorf("expected an error for a character that Shift_JIS doesn't have")
+	}
+	if _, err := findEncoding("no-such-encoding"); err == nil {
+		t.Errorf("expected an error for an unknown encoding")
+	}
+}
+
+func TestReadText(t *testing.T) {
+	la, name, bom, err := readText(strings.NewReader("\xFF\xFEh\x00i\x00\n\x00"))
+	if err != nil || la.String() != "hi\n" || name != "utf-16le" || !bom {
+		t.Errorf("expected \"hi\\n\" in utf-16le with a BOM, got %q in %q, BOM %v (%v)", la.String(), name, bom, err)
+	}
+
+	// The encoding is detected from the start of a large file, which is cut
+	// after a newline
+	text := strings.Repeat("caf\xE9\n", encodingSampleSize/5+1)
+	la, name, _, err = readText(strings.NewReader(text))
+	if err != nil || name != "iso-8859-1" || la.String() != strings.Replace(text, "\xE9", "é", -1) {
+		t.Errorf("expected the text to be decoded from iso-8859-1, got %q (%v)", name, err)
+	}
+	if sample := encodingSample([]byte("abc\ncd\xC3"), false); string(sample) != "abc\n" {
+		t.Errorf("expected the sample to end after the newline, got %q", sample)
+	}
+	if sample := encodingSample([]byte("abc\xC3"), false); string(sample) != "abc" {
+		t.Errorf("expected the sample not to end with part of a character, got %q", sample)
+	}
+
+	if _, _, _, err := readText(iotest.TimeoutReader(strings.NewReader(text))); err == nil {
+		t.Errorf("expected an error when reading fails")
+	}
+	if !bytes.Equal(byteOrderMark("UTF-16LE"), []byte{0xFF, 0xFE}) || byteOrderMark("gbk") != nil {
+		t.Errorf("expected only UTF encodings to have a byte order mark")
+	}
+	if !isUTF8("utf8") || isUTF8("latin1") {
+		t.Errorf("expected utf8 and only utf8 to be UTF-8")
+	}
+}
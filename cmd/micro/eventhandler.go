@@ -25,7 +25,10 @@ type TextEvent struct {
 
 	EventType int
 	Deltas    []Delta
-	Time      time.Time
+	// Endings holds a line ending for each line, which the lines swap with
+	// their own when the event is executed
+	Endings []lineEnding
+	Time    time.Time
 }
 
 // A Delta is a change to the buffer
@@ -35,27 +38,47 @@ type Delta struct {
 	End   Loc
 }
 
+// A ChangeListener is called after the text between start and end has been
+// replaced. start and end are locations in the text before the change was made,
+// removed is the text that was between them and inserted is the new text
+type ChangeListener func(start, end Loc, removed, inserted string)
+
 // ExecuteTextEvent runs a text event
 func ExecuteTextEvent(t *TextEvent, buf *Buffer) {
 	if t.EventType == TextEventInsert {
 		for _, d := range t.Deltas {
 			buf.insert(d.Start, []byte(d.Text))
+			buf.notifyChange(d.Start, d.Start, "", d.Text)
 		}
 	} else if t.EventType == TextEventRemove {
 		for i, d := range t.Deltas {
 			t.Deltas[i].Text = buf.remove(d.Start, d.End)
+			buf.notifyChange(d.Start, d.End, t.Deltas[i].Text, "")
 		}
 	} else if t.EventType == TextEventReplace {
 		for i, d := range t.Deltas {
 			t.Deltas[i].Text = buf.remove(d.Start, d.End)
 			buf.insert(d.Start, []byte(d.Text))
+			buf.notifyChange(d.Start, d.End, t.Deltas[i].Text, d.Text)
 			t.Deltas[i].Start = d.Start
-			t.Deltas[i].End = Loc{d.Start.X + Count(d.Text), d.Start.Y}
+			t.Deltas[i].End = textEnd(d.Start, d.Text)
 		}
 		for i, j := 0, len(t.Deltas)-1; i < j; i, j = i+1, j-1 {
 			t.Deltas[i], t.Deltas[j] = t.Deltas[j], t.Deltas[i]
 		}
+		if t.Endings != nil {
+			t.Endings = buf.swapLineEndings(t.Endings)
+		}
+	}
+}
+
+// textEnd returns the location of the end of text when it's inserted at start
+func textEnd(start Loc, text string) Loc {
+	lines := strings.Count(text, "\n")
+	if lines == 0 {
+		return Loc{start.X + Count(text), start.Y}
 	}
+	return Loc{Count(text[strings.LastIndex(text, "\n")+1:]), start.Y + lines}
 }
 
 // UndoTextEvent undoes a text event
@@ -66,16 +89,16 @@ func UndoTextEvent(t *TextEvent, buf *Buffer) {
 
 // EventHandler executes text manipulations and allows undoing and redoing
 type EventHandler struct {
-	buf       *Buffer
-	UndoStack *Stack
-	RedoStack *Stack
+	buf *Buffer
+	// Tree holds every state of the buffer, including the ones which were
+	// undone before another change was made
+	Tree *UndoTree
 }
 
 // NewEventHandler returns a new EventHandler
 func NewEventHandler(buf *Buffer) *EventHandler {
 	eh := new(EventHandler)
-	eh.UndoStack = new(Stack)
-	eh.RedoStack = new(Stack)
+	eh.Tree = NewUndoTree()
 	eh.buf = buf
 	return eh
 }
@@ -175,12 +198,9 @@ func (eh *EventHandler) Replace(start, end Loc, replace string) {
 	eh.Insert(start, replace)
 }
 
-// Execute a textevent and add it to the undo stack
+// Execute a textevent and add it to the undo tree
 func (eh *EventHandler) Execute(t *TextEvent) {
-	if eh.RedoStack.Len() > 0 {
-		eh.RedoStack = new(Stack)
-	}
-	eh.UndoStack.Push(t)
+	eh.Tree.add(t)
 
 	for pl := range loadedPlugins {
 		ret, err := Call(pl+".onBeforeTextEvent", t)
@@ -195,9 +215,9 @@ func (eh *EventHandler) Execute(t *TextEvent) {
 	ExecuteTextEvent(t, eh.buf)
 }
 
-// Undo the first event in the undo stack
+// Undo the events made since the last pause in typing
 func (eh *EventHandler) Undo() {
-	t := eh.UndoStack.Peek()
+	t := eh.Tree.undoEvent()
 	if t == nil {
 		return
 	}
@@ -207,7 +227,7 @@ func (eh *EventHandler) Undo() {
 	eh.UndoOneEvent()
 
 	for {
-		t = eh.UndoStack.Peek()
+		t = eh.Tree.undoEvent()
 		if t == nil {
 			return
 		}
@@ -221,11 +241,10 @@ func (eh *EventHandler) Undo() {
 	}
 }
 
-// UndoOneEvent undoes one event
+// UndoOneEvent undoes one event, moving to the parent of the current state
 func (eh *EventHandler) UndoOneEvent() {
 	// This event should be undone
-	// Pop it off the stack
-	t := eh.UndoStack.Pop()
+	t := eh.Tree.undoEvent()
 	if t == nil {
 		return
 	}
@@ -233,23 +252,14 @@ func (eh *EventHandler) UndoOneEvent() {
 	// Undo it
 	// Modifies the text event
 	UndoTextEvent(t, eh.buf)
+	eh.restoreCursor(t)
 
-	// Set the cursor in the right place
-	teCursor := t.C
-	if teCursor.Num >= 0 && teCursor.Num < len(eh.buf.cursors) {
-		t.C = *eh.buf.cursors[teCursor.Num]
-		eh.buf.cursors[teCursor.Num].Goto(teCursor)
-	} else {
-		teCursor.Num = -1
-	}
-
-	// Push it to the redo stack
-	eh.RedoStack.Push(t)
+	eh.Tree.undo()
 }
 
-// Redo the first event in the redo stack
+// Redo the events which were undone by the last undo
 func (eh *EventHandler) Redo() {
-	t := eh.RedoStack.Peek()
+	t := eh.Tree.redoEvent()
 	if t == nil {
 		return
 	}
@@ -259,7 +269,7 @@ func (eh *EventHandler) Redo() {
 	eh.RedoOneEvent()
 
 	for {
-		t = eh.RedoStack.Peek()
+		t = eh.Tree.redoEvent()
 		if t == nil {
 			return
 		}
@@ -272,16 +282,24 @@ func (eh *EventHandler) Redo() {
 	}
 }
 
-// RedoOneEvent redoes one event
+// RedoOneEvent redoes one event, moving to the child of the current state
+// which was left or made most recently
 func (eh *EventHandler) RedoOneEvent() {
-	t := eh.RedoStack.Pop()
+	t := eh.Tree.redoEvent()
 	if t == nil {
 		return
 	}
 
 	// Modifies the text event
 	UndoTextEvent(t, eh.buf)
+	eh.restoreCursor(t)
+
+	eh.Tree.redo()
+}
 
+// restoreCursor puts the cursor back where it was when the event was made,
+// and remembers where it is now for when the event is undone or redone again
+func (eh *EventHandler) restoreCursor(t *TextEvent) {
 	teCursor := t.C
 	if teCursor.Num >= 0 && teCursor.Num < len(eh.buf.cursors) {
 		t.C = *eh.buf.cursors[teCursor.Num]
@@ -289,6 +307,56 @@ func (eh *EventHandler) RedoOneEvent() {
 	} else {
 		teCursor.Num = -1
 	}
+}
 
-	eh.UndoStack.Push(t)
+// GotoState undoes and redoes events until the buffer is in the given state
+// of the undo tree
+func (eh *EventHandler) GotoState(state int) {
+	undo, redo := eh.Tree.path(state)
+	for range undo {
+		eh.UndoOneEvent()
+	}
+	for _, n := range redo {
+		eh.Tree.Nodes[eh.Tree.Current].Redo = n
+		eh.RedoOneEvent()
+	}
+}
+
+// Earlier goes to the state the buffer was in d before the current state was
+// made, whichever branch of the undo tree it's on
+func (eh *EventHandler) Earlier(d time.Duration) {
+	eh.GotoState(eh.Tree.atTime(eh.Tree.Nodes[eh.Tree.Current].Time.Add(-d)))
+}
+
+// Later goes to the latest state which was made no more than d after the
+// current one, whichever branch of the undo tree it's on
+func (eh *EventHandler) Later(d time.Duration) {
+	eh.GotoState(eh.Tree.atTime(eh.Tree.Nodes[eh.Tree.Current].Time.Add(d)))
+}
+
+// EarlierSteps goes back n steps through the states in the order they were
+// made, counting events made without a pause in typing as one step
+func (eh *EventHandler) EarlierSteps(n int) {
+	state := eh.Tree.Current
+	for ; n > 0 && state > 0; n-- {
+		for state > 1 && eh.Tree.sameStep(state-1, state) {
+			state--
+		}
+		state--
+	}
+	eh.GotoState(state)
+}
+
+// LaterSteps goes forward n steps through the states in the order they were
+// made, counting events made without a pause in typing as one step
+func (eh *EventHandler) LaterSteps(n int) {
+	state := eh.Tree.Current
+	last := len(eh.Tree.Nodes) - 1
+	for ; n > 0 && state < last; n-- {
+		state++
+		for state < last && eh.Tree.sameStep(state, state+1) {
+			state++
+		}
+	}
+	eh.GotoState(state)
 }
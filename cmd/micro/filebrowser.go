@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zyedidia/tcell"
+)
+
+// A FileBrowser is a buffer which lists the files in a directory, where they
+// can be opened by pressing enter on them, like netrw in Vim. Opening a file
+// replaces the browser with it.
+type FileBrowser struct {
+	dir string
+	// entries holds the name of the file on each line, or "" for other lines
+	entries []string
+}
+
+// NewBufferFromDir returns a buffer which browses the given directory
+func NewBufferFromDir(dir string) (*Buffer, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	buf := NewBufferFromString("", "")
+	buf.browser = new(FileBrowser)
+	if err := buf.browser.show(buf, abs, ""); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// show lists the files in dir in the buffer, directories first, and puts the
+// cursor on the one named selected
+func (f *FileBrowser) show(buf *Buffer, dir, selected string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	isDir := func(fi os.FileInfo) bool {
+		if fi.Mode()&os.ModeSymlink != 0 {
+			// A link is shown as what it points to
+			target, err := os.Stat(filepath.Join(dir, fi.Name()))
+			return err == nil && target.IsDir()
+		}
+		return fi.IsDir()
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return isDir(files[i]) && !isDir(files[j])
+	})
+
+	lines := []string{
+		dir,
+		"Press enter to open a file, - to go up a directory, or q to close",
+		"",
+	}
+	entries := []string{"", "", ""}
+	if filepath.Dir(dir) != dir {
+		lines = append(lines, "../")
+		entries = append(entries, "..")
+	}
+	current := len(lines)
+	for _, fi := range files {
+		if fi.Name() == selected {
+			current = len(lines)
+		}
+		name := fi.Name()
+		if isDir(fi) {
+			name += string(filepath.Separator)
+		}
+		lines = append(lines, name)
+		entries = append(entries, fi.Name())
+	}
+	f.dir, f.entries = dir, entries
+
+	text := strings.Join(lines, "\n")
+	buf.LineArray = NewLineArray(int64(len(text)), strings.NewReader(text))
+	buf.name = dir
+	buf.Update()
+	buf.Cursor.GotoLoc(Loc{0, current})
+	return nil
+}
+
+// open opens the file with the given name in the browser's directory, either
+// in the browser if it's a directory or else in the view instead of the
+// browser
+func (f *FileBrowser) open(v *View, name string) {
+	path := filepath.Join(f.dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		messenger.Error(err)
+		return
+	}
+	if !info.IsDir() {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+		}
+		v.Open(path)
+		return
+	}
+
+	selected := ""
+	if name == ".." {
+		selected = filepath.Base(f.dir)
+	}
+	if err := f.show(v.Buf, path, selected); err != nil {
+		messenger.Error(err)
+	}
+	v.Relocate()
+}
+
+// HandleEvent handles an event in the browser, and returns whether it was
+// used. Enter opens the file on the cursor's line, - or backspace goes up a
+// directory, and escape or q closes the browser.
+func (f *FileBrowser) HandleEvent(v *View, event tcell.Event) bool {
+	e, ok := event.(*tcell.EventKey)
+	if !ok {
+		return false
+	}
+	switch {
+	case e.Key() == tcell.KeyEnter:
+		if y := v.Cursor.Y; y < len(f.entries) && f.entries[y] != "" {
+			f.open(v, f.entries[y])
+		}
+	case e.Key() == tcell.KeyRune && e.Rune() == '-', e.Key() == tcell.KeyBackspace, e.Key() == tcell.KeyBackspace2:
+		f.open(v, "..")
+	case e.Key() == tcell.KeyEscape, e.Key() == tcell.KeyRune && e.Rune() == 'q':
+		v.Quit(false)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isGlob returns whether the path has any of the special characters of a glob
+// pattern
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// ExpandGlob returns the files which match a glob pattern, with the files in
+// each directory before the ones in its subdirectories. As well as the
+// patterns of filepath.Match, ** matches any number of directories. Like in a
+// shell, * and ** don't match names which start with a dot unless the pattern
+// does, and directories aren't matched.
+func ExpandGlob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, errors.New("Bad glob pattern " + pattern)
+	}
+	pattern = filepath.Clean(ReplaceHome(pattern))
+
+	root := "."
+	if filepath.IsAbs(pattern) {
+		root = filepath.VolumeName(pattern) + string(filepath.Separator)
+		pattern = pattern[len(root):]
+	}
+
+	var matches []string
+	found := make(map[string]bool)
+	var match func(path string, parts []string)
+	match = func(path string, parts []string) {
+		if len(parts) == 0 {
+			return
+		}
+		part, rest := parts[0], parts[1:]
+		switch {
+		case part == "**":
+			// ** matches no directories too
+			match(path, rest)
+		case !isGlob(part) && len(rest) > 0:
+			match(filepath.Join(path, part), rest)
+			return
+		}
+
+		files, _ := ioutil.ReadDir(path)
+		for _, f := range files {
+			name := f.Name()
+			if strings.HasPrefix(name, ".") && !strings.HasPrefix(part, ".") {
+				continue
+			}
+			file := filepath.Join(path, name)
+			switch {
+			case part == "**":
+				if f.IsDir() {
+					match(file, parts)
+				}
+			case len(rest) > 0:
+				if ok, _ := filepath.Match(part, name); ok && f.IsDir() {
+					match(file, rest)
+				}
+			default:
+				if ok, _ := filepath.Match(part, name); ok && !f.IsDir() && !found[file] {
+					found[file] = true
+					matches = append(matches, file)
+				}
+			}
+		}
+	}
+	parts := strings.Split(pattern, string(filepath.Separator))
+	if parts[len(parts)-1] == "**" {
+		// ** at the end matches all the files in the directories
+		parts = append(parts, "*")
+	}
+	match(root, parts)
+	return matches, nil
+}
+
+// expandPaths expands the glob patterns among paths. Other paths are kept as
+// they are, so that files which don't exist yet can be opened, and so are
+// patterns which don't match any files, like in a shell, since they may be the
+// names of new files.
+func expandPaths(paths []string) []string {
+	var expanded []string
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil || !isGlob(path) {
+			expanded = append(expanded, path)
+			continue
+		}
+		matches, err := ExpandGlob(path)
+		if err != nil || len(matches) == 0 {
+			expanded = append(expanded, path)
+			continue
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		"main.go",
+		"README.md",
+		".hidden.go",
+		"cmd/cmd.go",
+		"cmd/sub/sub.go",
+		"cmd/sub/sub.txt",
+		".git/config.go",
+	}
+	for _, name := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"*.go", []string{"main.go"}},
+		{".*.go", []string{".hidden.go"}},
+		{"**/*.go", []string{"main.go", "cmd/cmd.go", "cmd/sub/sub.go"}},
+		{"cmd/**", []string{"cmd/cmd.go", "cmd/sub/sub.go", "cmd/sub/sub.txt"}},
+		{"*/sub/*.[gt]*", []string{"cmd/sub/sub.go", "cmd/sub/sub.txt"}},
+		{"cmd/*", []string{"cmd/cmd.go"}},
+		{"*.c", nil},
+	}
+	for _, test := range tests {
+		var expected []string
+		for _, name := range test.expected {
+			expected = append(expected, filepath.Join(dir, filepath.FromSlash(name)))
+		}
+		matches, err := ExpandGlob(filepath.Join(dir, filepath.FromSlash(test.pattern)))
+		if err != nil {
+			t.Errorf("%s: %v", test.pattern, err)
+		} else if !reflect.DeepEqual(matches, expected) {
+			t.Errorf("%s: expected %v, got %v", test.pattern, expected, matches)
+		}
+	}
+
+	if _, err := ExpandGlob("[a"); err == nil {
+		t.Errorf("expected an error for a bad pattern")
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.go", "b.go", "[x].txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	join := func(names ...string) (paths []string) {
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+		return
+	}
+	tests := []struct {
+		paths    []string
+		expected []string
+	}{
+		{join("*.go"), join("a.go", "b.go")},
+		{join("new.go"), join("new.go")},
+		// Files whose names look like patterns are opened as they are
+		{join("[x].txt"), join("[x].txt")},
+		// Patterns which don't match anything are new files
+		{join("new[1].go", "*.c", "[a"), join("new[1].go", "*.c", "[a")},
+	}
+	for _, test := range tests {
+		if actual := expandPaths(test.paths); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.paths, test.expected, actual)
+		}
+	}
+}
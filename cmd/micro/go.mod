@@ -6,6 +6,7 @@ require (
 	github.com/blang/semver v3.5.1+incompatible
 	github.com/dustin/go-humanize v1.0.0
 	github.com/flynn/json5 v0.0.0-20160717195620-7620272ed633
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gdamore/encoding v1.0.0 // indirect
 	github.com/go-errors/errors v1.0.1
 	github.com/kylelemons/godebug v1.1.0 // indirect
@@ -25,8 +26,8 @@ require (
 	github.com/zyedidia/pty v1.1.2-0.20180126010845-30364665a244 // indirect
 	github.com/zyedidia/tcell v0.0.0-20190212015332-5c58b4edc169
 	github.com/zyedidia/terminal v0.0.0-20180726154117-533c623e2415
-	golang.org/x/sys v0.0.0-20190927073244-c990c680b611 // indirect
-	golang.org/x/text v0.3.2 // indirect
+	golang.org/x/sys v0.0.0-20191005200804-aed5e4c7ecf9 // indirect
+	golang.org/x/text v0.3.2
 	gopkg.in/sourcemap.v1 v1.0.5 // indirect
 	gopkg.in/yaml.v2 v2.2.3
 	layeh.com/gopher-luar v1.0.7
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zyedidia/micro/cmd/micro/grep"
+	"github.com/zyedidia/tcell"
+)
+
+// grepFlushTime is how often the matches which have been found are added to the
+// results of a search
+const grepFlushTime = 100 * time.Millisecond
+
+// GrepResults is a buffer which lists the matches of a search of the files in
+// the working directory, where pressing enter on a match opens its file at
+// it. The matches are added as the files are searched.
+type GrepResults struct {
+	dir string
+	// entries holds the file and location of the match on each line, or an
+	// empty path for other lines
+	entries []grepEntry
+	files   int
+	matches int
+	stop    chan struct{}
+	stopped bool
+}
+
+type grepEntry struct {
+	path string
+	loc  Loc
+}
+
+// grepArgs parses the n arguments of a grep command and the flags after them.
+// -l makes the search literal, -i ignores case, and -c, which is accepted if
+// allowCase is set, keeps the case of replacements.
+func grepArgs(args []string, n int, allowCase bool) (regex *regexp.Regexp, literal, preserveCase bool, err error) {
+	if len(args) < n {
+		return nil, false, false, errors.New("Not enough arguments")
+	}
+	ignoreCase := false
+	for _, arg := range args[n:] {
+		switch {
+		case arg == "-l":
+			literal = true
+		case arg == "-i":
+			ignoreCase = true
+		case arg == "-c" && allowCase:
+			preserveCase = true
+		default:
+			return nil, false, false, errors.New("Invalid flag: " + arg)
+		}
+	}
+
+	search := args[0]
+	if literal {
+		search = regexp.QuoteMeta(search)
+	}
+	if ignoreCase {
+		search = "(?i)" + search
+	}
+	regex, err = regexp.Compile("(?m)" + search)
+	return regex, literal, preserveCase, err
+}
+
+// Grep searches the files in the working directory, and lists the matches in
+// a new tab
+func Grep(args []string) {
+	regex, _, _, err := grepArgs(args, 1, false)
+	if err != nil {
+		messenger.Error(err)
+		return
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		messenger.Error(err)
+		return
+	}
+
+	header := "Matches of " + args[0] + " in " + wd + "\n" +
+		"Press enter to open a match, or q to close"
+	buf := NewBufferFromString(header, "")
+	buf.name = "grep " + args[0]
+	r := &GrepResults{
+		dir:     wd,
+		entries: []grepEntry{{}, {}},
+		stop:    make(chan struct{}),
+	}
+	buf.results = r
+	openTab(NewView(buf))
+
+	results := make(chan grep.File)
+	go grep.Search(wd, regex, largeFileSize(), results, r.stop)
+	go func() {
+		ticker := time.NewTicker(grepFlushTime)
+		defer ticker.Stop()
+		var pending []grep.File
+		for {
+			select {
+			case f, ok := <-results:
+				if !ok {
+					RunInMainLoop(func() {
+						r.add(buf, pending)
+						r.finish()
+					})
+					return
+				}
+				pending = append(pending, f)
+			case <-ticker.C:
+				if len(pending) > 0 {
+					files := pending
+					RunInMainLoop(func() { r.add(buf, files) })
+					pending = nil
+				}
+			}
+		}
+	}()
+}
+
+// add lists the matches in the files at the end of the buffer
+func (r *GrepResults) add(buf *Buffer, files []grep.File) {
+	if r.stopped || len(files) == 0 {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	var lines []string
+	for _, f := range files {
+		for _, m := range f.Matches {
+			col := utf8.RuneCountInString(m.Text[:m.Start])
+			lines = append(lines, fmt.Sprintf("%s:%d:%d: %s", f.Path, m.Line+1, col+1, m.Text))
+			r.entries = append(r.entries, grepEntry{filepath.FromSlash(f.Path), Loc{col, m.Line}})
+		}
+		r.files++
+		r.matches += len(f.Matches)
+	}
+	// The results aren't changes which can be undone
+	buf.insert(buf.End(), []byte("\n"+strings.Join(lines, "\n")))
+}
+
+// finish tells the user how many matches were found once the search is done
+func (r *GrepResults) finish() {
+	if r.stopped {
+		return
+	}
+	r.Stop()
+	messenger.Message("Found ", r.matches, " matches in ", r.files, " files")
+}
+
+// Stop stops the search if it's still running
+func (r *GrepResults) Stop() {
+	if !r.stopped {
+		r.stopped = true
+		close(r.stop)
+	}
+}
+
+// HandleEvent handles an event in the results, and returns whether it was
+// used. Enter opens the match on the cursor's line, and escape or q closes the
+// results.
+func (r *GrepResults) HandleEvent(v *View, event tcell.Event) bool {
+	e, ok := event.(*tcell.EventKey)
+	if !ok {
+		return false
+	}
+	switch {
+	case e.Key() == tcell.KeyEnter:
+		if y := v.Cursor.Y; y < len(r.entries) && r.entries[y].path != "" {
+			if err := openLocation(filepath.Join(r.dir, r.entries[y].path), r.entries[y].loc); err != nil {
+				messenger.Error(err)
+			}
+		}
+	case e.Key() == tcell.KeyEscape, e.Key() == tcell.KeyRune && e.Rune() == 'q':
+		v.Quit(false)
+	default:
+		return false
+	}
+	return true
+}
+
+// openTab adds a tab with the view, and switches to it
+func openTab(v *View) {
+	tab := NewTabFromView(v)
+	tab.SetNum(len(tabs))
+	tabs = append(tabs, tab)
+	curTab = len(tabs) - 1
+	if len(tabs) == 2 {
+		for _, t := range tabs {
+			for _, v := range t.Views {
+				v.ToggleTabbar()
+			}
+		}
+	}
+}
+
+// findFileView returns the view which shows the file with the given absolute
+// path, or nil if it isn't open
+func findFileView(path string) *View {
+	for _, t := range tabs {
+		for _, v := range t.Views {
+			if v.Buf.AbsPath == path && v.Type == vtDefault {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// openFileView switches to the view which shows the file with the given
+// absolute path, or opens it in a new tab if it isn't open
+func openFileView(path string) (*View, error) {
+	if v := findFileView(path); v != nil {
+		curTab = v.TabNum
+		tabs[curTab].CurView = v.Num
+		return v, nil
+	}
+	buf, err := NewBufferFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v := NewView(buf)
+	openTab(v)
+	return v, nil
+}
+
+// openLocation shows the file with the given absolute path with the cursor at
+// loc
+func openLocation(path string, loc Loc) error {
+	v, err := openFileView(path)
+	if err != nil {
+		return err
+	}
+	v.Cursor.ResetSelection()
+	v.Cursor.GotoLoc(loc)
+	v.Cursor.Relocate()
+	v.Relocate()
+	return nil
+}
+
+// hiddenBuffers holds the buffers of the files which grepreplace changed
+// while they weren't open, by absolute path. Their changes haven't been saved,
+// and they're shown with them when the files are opened.
+var hiddenBuffers = make(map[string]*Buffer)
+
+// grepReplaceFile is a file which grepreplace would change
+type grepReplaceFile struct {
+	path    string
+	matches int
+	diff    string
+}
+
+// GrepReplace replaces the matches in the files in the working directory. The
+// files are searched in the background, and the changes are shown once the
+// search is done, which closing the preview cancels. Once they're accepted
+// they're made in the buffers of the files, where they can be undone before
+// saving them. The buffers of files which aren't open are kept hidden until
+// the files are opened or saved with saveall.
+func GrepReplace(args []string) {
+	regex, literal, preserveCase, err := grepArgs(args, 2, true)
+	if err != nil {
+		messenger.Error(err)
+		return
+	}
+	replacer := newReplacer(regex, args[1], literal, preserveCase)
+	wd, err := os.Getwd()
+	if err != nil {
+		messenger.Error(err)
+		return
+	}
+
+	// Open files may have changes which haven't been saved, so their buffers
+	// are searched instead of the files
+	open := make(map[string]string)
+	for _, t := range tabs {
+		for _, v := range t.Views {
+			if v.Type == vtDefault && v.Buf.AbsPath != "" && strings.HasPrefix(v.Buf.AbsPath, wd+string(filepath.Separator)) {
+				open[v.Buf.AbsPath] = v.Buf.String()
+			}
+		}
+	}
+	for path, b := range hiddenBuffers {
+		open[path] = b.String()
+	}
+
+	buf := NewBufferFromString("Searching for "+args[0]+" in "+wd+"\nPress q to cancel", "")
+	buf.name = "Replace " + args[0]
+	r := &GrepResults{
+		dir:  wd,
+		stop: make(chan struct{}),
+	}
+	buf.results = r
+	previewView := NewView(buf)
+	openTab(previewView)
+
+	results := make(chan grep.File)
+	go grep.Search(wd, regex, largeFileSize(), results, r.stop)
+	go func() {
+		var paths []string
+		for f := range results {
+			paths = append(paths, filepath.Join(wd, filepath.FromSlash(f.Path)))
+		}
+		for path := range open {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		var files []grepReplaceFile
+		for i, path := range paths {
+			if i > 0 && path == paths[i-1] {
+				continue
+			}
+			text, ok := open[path]
+			if !ok {
+				data, err := ioutil.ReadFile(path)
+				if err != nil {
+					continue
+				}
+				text = strings.Replace(string(data), "\r\n", "\n", -1)
+			}
+			replaced, n := replacer.replaceAll([]byte(text))
+			if n == 0 || replaced == text {
+				continue
+			}
+			files = append(files, grepReplaceFile{path, n, diffLines(text, replaced, 2)})
+		}
+		RunInMainLoop(func() {
+			if r.stopped {
+				return
+			}
+			r.Stop()
+			confirmGrepReplace(previewView, args[0], replacer, files)
+		})
+	}()
+}
+
+// confirmGrepReplace shows the changes grepreplace would make to the files in
+// the preview view, and makes them if the user accepts them
+func confirmGrepReplace(previewView *View, search string, replacer *replacer, files []grepReplaceFile) {
+	curTab = previewView.TabNum
+	tabs[curTab].CurView = previewView.Num
+	dir := previewView.Buf.results.dir
+	if len(files) == 0 {
+		previewView.Quit(false)
+		messenger.Message("Nothing matched ", search)
+		return
+	}
+
+	var preview []string
+	total := 0
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f.path)
+		preview = append(preview, fmt.Sprintf("%s: %d matches", rel, f.matches), f.diff, "")
+		total += f.matches
+	}
+	b := previewView.Buf
+	b.remove(b.Start(), b.End())
+	b.insert(b.Start(), []byte(strings.Join(preview, "\n")))
+	previewView.Cursor.GotoLoc(b.Start())
+	previewView.Relocate()
+	RedrawAll()
+
+	choice, canceled := messenger.YesNoPrompt(fmt.Sprintf("Replace %d matches in %d files? (y,n)", total, len(files)))
+	previewView.Quit(false)
+	messenger.Reset()
+	if canceled || !choice {
+		return
+	}
+
+	found, changed := 0, 0
+	var failed []string
+	var lastErr error
+	for _, f := range files {
+		n, err := replaceInBuffer(f.path, replacer)
+		if err != nil {
+			rel, _ := filepath.Rel(dir, f.path)
+			failed = append(failed, rel)
+			lastErr = err
+			continue
+		}
+		if n > 0 {
+			found += n
+			changed++
+		}
+	}
+	msg := fmt.Sprintf("Replaced %d matches in %d files, which haven't been saved", found, changed)
+	if len(hiddenBuffers) > 0 {
+		msg += ". Run saveall to save the ones which aren't open"
+	}
+	if len(failed) > 0 {
+		messenger.Error(msg, ". Couldn't change ", strings.Join(failed, ", "), ": ", lastErr)
+		return
+	}
+	messenger.Message(msg)
+}
+
+// replaceInBuffer replaces the matches in the buffer of a file. A file which
+// isn't open is read into a hidden buffer, so that the replacements can be
+// undone like in any other buffer, and the file's encoding and line endings
+// are kept when it's saved.
+func replaceInBuffer(path string, replacer *replacer) (int, error) {
+	var buf *Buffer
+	loaded := false
+	if v := findFileView(path); v != nil {
+		buf = v.Buf
+	} else if b, ok := hiddenBuffers[path]; ok {
+		buf = b
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		buf, err = loadBuffer(file, FSize(file), path, nil)
+		file.Close()
+		if err != nil {
+			buf.closeFile()
+			return 0, err
+		}
+		loaded = true
+	}
+
+	deltas := replacer.deltas(buf.LineArray.Bytes(false), buf.Start())
+	if len(deltas) == 0 {
+		if loaded {
+			buf.closeFile()
+		}
+		return 0, nil
+	}
+	buf.MultipleReplace(deltas)
+	buf.Cursor.Relocate()
+	if loaded {
+		hiddenBuffers[path] = buf
+	}
+	return len(deltas), nil
+}
+
+// takeHiddenBuffer returns the hidden buffer of a file which is being opened,
+// or nil if it doesn't have one. It's watched from then on, like the buffers
+// of other open files.
+func takeHiddenBuffer(path string) *Buffer {
+	abs, _ := filepath.Abs(path)
+	b := hiddenBuffers[abs]
+	if b != nil {
+		delete(hiddenBuffers, abs)
+		b.watch()
+	}
+	return b
+}
+
+// saveHiddenBuffers saves the hidden buffers, and closes the ones which were
+// saved
+func saveHiddenBuffers() error {
+	var failed []string
+	var lastErr error
+	for path, b := range hiddenBuffers {
+		err := b.Save()
+		b.unwatch()
+		if err != nil {
+			failed = append(failed, b.GetName())
+			lastErr = err
+			continue
+		}
+		b.closeFile()
+		delete(hiddenBuffers, path)
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return errors.New("Couldn't save " + strings.Join(failed, ", ") + ": " + lastErr.Error())
+	}
+	return nil
+}
+
+// closeHiddenBuffers asks whether to save the hidden buffers before micro
+// exits, and returns false if micro shouldn't exit
+func closeHiddenBuffers() bool {
+	if len(hiddenBuffers) == 0 {
+		return true
+	}
+	choice, canceled := messenger.YesNoPrompt(fmt.Sprintf("Save the changes grepreplace made to %d files which aren't open? (y,n,esc)", len(hiddenBuffers)))
+	if canceled {
+		return false
+	}
+	if choice {
+		if err := saveHiddenBuffers(); err != nil {
+			messenger.Error(err)
+			return false
+		}
+	}
+	return true
+}
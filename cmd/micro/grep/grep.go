@@ -0,0 +1,251 @@
+// Package grep searches the files in a directory for matches of a regular
+// expression.
+//
+// Files are searched in parallel. The .git directory, files which are ignored
+// by the .gitignore files in the directory, binary files and files over a
+// size limit are skipped, as are symbolic links. Only the .gitignore files
+// inside the directory are used, not the ones above it.
+package grep
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// binaryCheckSize is how much of a file is checked for a NUL byte, which
+// makes it binary, like in git.
+const binaryCheckSize = 8000
+
+// Match is a match of the regular expression in a file.
+type Match struct {
+	// Line is the line where the match starts, counted from 0.
+	Line int
+	// Start and End are the byte offsets of the match in Text. End is past
+	// the end of Text if the match spans lines.
+	Start, End int
+	// Text is the line where the match starts, without its line ending.
+	Text string
+}
+
+// File is a file which has matches.
+type File struct {
+	// Path is the path of the file relative to the directory which was
+	// searched, separated by slashes.
+	Path    string
+	Matches []Match
+}
+
+// IsBinary returns whether the data of a file is binary.
+func IsBinary(data []byte) bool {
+	if len(data) > binaryCheckSize {
+		data = data[:binaryCheckSize]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// Search searches the files under dir for matches of r, and sends each file
+// which has any to results, which it closes when it's done. Files larger than
+// maxSize bytes are skipped, unless it's 0. Files are sent in no particular
+// order, since they're searched in parallel. Closing stop ends the search
+// early.
+func Search(dir string, r *regexp.Regexp, maxSize int64, results chan<- File, stop <-chan struct{}) {
+	defer close(results)
+
+	paths := make(chan string)
+	go func() {
+		walk(dir, "", nil, paths, stop)
+		close(paths)
+	}()
+
+	wholeFiles := matchesNewline(r)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				matches := searchFile(r, filepath.Join(dir, filepath.FromSlash(p)), maxSize, wholeFiles)
+				if len(matches) > 0 {
+					select {
+					case results <- File{p, matches}:
+					case <-stop:
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// searchFile returns the matches of r in a file, or nil if it can't be read,
+// is binary or is larger than maxSize. The file is searched a line at a time,
+// unless wholeFile is set for a regular expression which can match across
+// lines, in which case all of it is read.
+func searchFile(r *regexp.Regexp, name string, maxSize int64, wholeFile bool) []Match {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || maxSize > 0 && info.Size() > maxSize {
+		return nil
+	}
+
+	br := bufio.NewReaderSize(f, 64*1024)
+	if start, _ := br.Peek(binaryCheckSize); IsBinary(start) {
+		return nil
+	}
+	if wholeFile {
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil
+		}
+		return find(r, data)
+	}
+	matches, err := findLines(r, br)
+	if err != nil {
+		return nil
+	}
+	return matches
+}
+
+// matchesNewline returns whether r can match a newline, so that its matches
+// can span lines.
+func matchesNewline(r *regexp.Regexp) bool {
+	re, err := syntax.Parse(r.String(), syntax.Perl)
+	if err != nil {
+		return true
+	}
+	var check func(re *syntax.Regexp) bool
+	check = func(re *syntax.Regexp) bool {
+		switch re.Op {
+		case syntax.OpAnyChar:
+			return true
+		case syntax.OpLiteral:
+			for _, c := range re.Rune {
+				if c == '\n' {
+					return true
+				}
+			}
+		case syntax.OpCharClass:
+			for i := 0; i+1 < len(re.Rune); i += 2 {
+				if re.Rune[i] <= '\n' && '\n' <= re.Rune[i+1] {
+					return true
+				}
+			}
+		}
+		for _, sub := range re.Sub {
+			if check(sub) {
+				return true
+			}
+		}
+		return false
+	}
+	return check(re)
+}
+
+// walk sends the path of each file in the directory rel, relative to dir, to
+// paths. It returns false if the search was stopped.
+func walk(dir, rel string, ig *ignorer, paths chan<- string, stop <-chan struct{}) bool {
+	full := filepath.Join(dir, filepath.FromSlash(rel))
+	if data, err := ioutil.ReadFile(filepath.Join(full, ".gitignore")); err == nil {
+		ig = &ignorer{ig, rel, parsePatterns(string(data))}
+	}
+	files, err := ioutil.ReadDir(full)
+	if err != nil {
+		return true
+	}
+	// Directories are searched after the files around them
+	sort.SliceStable(files, func(i, j int) bool {
+		return !files[i].IsDir() && files[j].IsDir()
+	})
+	for _, f := range files {
+		p := path.Join(rel, f.Name())
+		switch {
+		case f.IsDir():
+			if f.Name() == ".git" || ig.ignored(p, true) {
+				continue
+			}
+			if !walk(dir, p, ig, paths, stop) {
+				return false
+			}
+		case f.Mode().IsRegular():
+			if ig.ignored(p, false) {
+				continue
+			}
+			select {
+			case paths <- p:
+			case <-stop:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// findLines returns the matches of r in a file which is read a line at a
+// time, for a regular expression which can't match a newline. Empty matches
+// are left out.
+func findLines(r *regexp.Regexp, br *bufio.Reader) ([]Match, error) {
+	var matches []Match
+	for line := 0; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = bytes.TrimSuffix(data, []byte{'\n'})
+		var text string
+		for _, m := range r.FindAllIndex(data, -1) {
+			if m[0] == m[1] {
+				continue
+			}
+			if text == "" {
+				text = string(bytes.TrimSuffix(data, []byte{'\r'}))
+			}
+			matches = append(matches, Match{line, m[0], m[1], text})
+		}
+		if err == io.EOF {
+			return matches, nil
+		}
+	}
+}
+
+// find returns the matches of r in the data of a file. Empty matches are left
+// out.
+func find(r *regexp.Regexp, data []byte) []Match {
+	var matches []Match
+	line, lineStart := 0, 0
+	for _, m := range r.FindAllIndex(data, -1) {
+		if m[0] == m[1] {
+			continue
+		}
+		for {
+			i := bytes.IndexByte(data[lineStart:m[0]], '\n')
+			if i < 0 {
+				break
+			}
+			line++
+			lineStart += i + 1
+		}
+		lineEnd := bytes.IndexByte(data[lineStart:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(data)
+		} else {
+			lineEnd += lineStart
+		}
+		text := bytes.TrimSuffix(data[lineStart:lineEnd], []byte{'\r'})
+		matches = append(matches, Match{line, m[0] - lineStart, m[1] - lineStart, string(text)})
+	}
+	return matches
+}
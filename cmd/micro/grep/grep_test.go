@@ -0,0 +1,141 @@
+package grep
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	data := []byte("one\r\ntwo two\n\nthree\nfour")
+	expected := []Match{
+		{1, 0, 3, "two two"},
+		{1, 4, 7, "two two"},
+		{3, 3, 8, "three"},
+	}
+	if got := find(regexp.MustCompile(`two|ee\nfo`), data); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if got := find(regexp.MustCompile(`x*`), data); got != nil {
+		t.Errorf("expected no empty matches, got %v", got)
+	}
+}
+
+func TestFindLines(t *testing.T) {
+	data := "one\r\ntwo two\n\nthree\nfour"
+	expected := []Match{
+		{1, 0, 3, "two two"},
+		{1, 4, 7, "two two"},
+		{3, 0, 5, "three"},
+	}
+	got, err := findLines(regexp.MustCompile(`(?m)two|^th.*$`), bufio.NewReader(strings.NewReader(data)))
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v %v", expected, got, err)
+	}
+}
+
+func TestMatchesNewline(t *testing.T) {
+	tests := []struct {
+		regex   string
+		newline bool
+	}{
+		{`foo.*bar`, false},
+		{`(?i)foo$`, false},
+		{`[^a-z]+`, true},
+		{`foo\s+bar`, true},
+		{`(?s)foo.bar`, true},
+		{`a|b\n`, true},
+	}
+	for _, test := range tests {
+		if got := matchesNewline(regexp.MustCompile(test.regex)); got != test.newline {
+			t.Errorf("%s: expected %v, got %v", test.regex, test.newline, got)
+		}
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	root := &ignorer{nil, "", parsePatterns("# comment\n*.o\n/build\ndocs/*.html\n!keep.o\nlogs/\n")}
+	sub := &ignorer{root, "sub", parsePatterns("*.txt\n!/main.o\n")}
+
+	tests := []struct {
+		ig      *ignorer
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{root, "a.o", false, true},
+		{root, "x/y/a.o", false, true},
+		{root, "keep.o", false, false},
+		{root, "build", true, true},
+		{root, "x/build", true, false},
+		{root, "docs/index.html", false, true},
+		{root, "docs/api/index.html", false, false},
+		{root, "logs", true, true},
+		{root, "logs", false, false},
+		{root, "a.go", false, false},
+		{sub, "sub/notes.txt", false, true},
+		{sub, "notes.txt", false, false},
+		{sub, "sub/main.o", false, false},
+		{sub, "sub/x/main.o", false, true},
+	}
+	for _, test := range tests {
+		if got := test.ig.ignored(test.rel, test.isDir); got != test.ignored {
+			t.Errorf("%s: expected ignored to be %v", test.rel, test.ignored)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		".gitignore":     "*.log\nvendor/\n",
+		"main.go":        "package main\n\nfunc main() {}\n",
+		"debug.log":      "func main\n",
+		"binary":         "func\x00main",
+		"vendor/lib.go":  "func main() {}\n",
+		"cmd/cmd.go":     "func run() {\n\tmain()\n}\n",
+		".git/HEAD":      "main\n",
+		"cmd/.gitignore": "!debug.log\n",
+		"cmd/debug.log":  "main\n",
+		"large.go":       "main\n" + strings.Repeat("x", 100),
+	}
+	for name, text := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := make(chan File)
+	go Search(dir, regexp.MustCompile(`main`), 100, results, make(chan struct{}))
+	var found []string
+	for f := range results {
+		found = append(found, f.Path)
+	}
+	sort.Strings(found)
+	if expected := []string{"cmd/cmd.go", "cmd/debug.log", "main.go"}; !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v", expected, found)
+	}
+	// Matches which span lines are found in whole files
+	results = make(chan File)
+	go Search(dir, regexp.MustCompile(`\{\s+main`), 100, results, make(chan struct{}))
+	found = nil
+	for f := range results {
+		found = append(found, f.Path)
+	}
+	if expected := []string{"cmd/cmd.go"}; !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v", expected, found)
+	}
+}
@@ -0,0 +1,110 @@
+package grep
+
+import (
+	"path"
+	"strings"
+)
+
+// pattern is a line of a .gitignore file.
+type pattern struct {
+	// parts are the parts of the pattern between slashes. A pattern without
+	// a slash matches names at any depth, so it starts with **.
+	parts   []string
+	negate  bool
+	dirOnly bool
+}
+
+// parsePatterns parses the lines of a .gitignore file.
+func parsePatterns(data string) []pattern {
+	var patterns []pattern
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var p pattern
+		if line[0] == '!' {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		if strings.Contains(line, "/") {
+			p.parts = strings.Split(strings.TrimPrefix(line, "/"), "/")
+		} else {
+			p.parts = []string{"**", line}
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// matches returns whether the pattern matches a path, which is relative to the
+// directory of the .gitignore file and separated by slashes.
+func (p pattern) matches(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return matchParts(p.parts, strings.Split(rel, "/"))
+}
+
+// matchParts matches the parts of a path against the parts of a pattern, where
+// ** matches any number of parts.
+func matchParts(glob, parts []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchParts(glob[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], parts[0]); !ok {
+			return false
+		}
+		glob, parts = glob[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// ignorer holds the patterns of the .gitignore file in a directory, and the
+// ignorer of the directory above it, if any.
+type ignorer struct {
+	parent *ignorer
+	// dir is the directory of the .gitignore file, relative to the directory
+	// which is searched
+	dir      string
+	patterns []pattern
+}
+
+// ignored returns whether a path, relative to the directory which is searched,
+// is ignored. Like in git, deeper .gitignore files take precedence, and so do
+// later patterns in a file.
+func (ig *ignorer) ignored(rel string, isDir bool) bool {
+	for ; ig != nil; ig = ig.parent {
+		name := rel
+		if ig.dir != "" {
+			if !strings.HasPrefix(rel, ig.dir+"/") {
+				continue
+			}
+			name = rel[len(ig.dir)+1:]
+		}
+		for i := len(ig.patterns) - 1; i >= 0; i-- {
+			if ig.patterns[i].matches(name, isDir) {
+				return !ig.patterns[i].negate
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// highlightedSearch is the search whose matches are highlighted and counted in
+// the statusline, or "" if there isn't one
+var highlightedSearch string
+
+// searchMatches holds the matches of the highlighted search in a buffer. A
+// search which can only match within a line is done a line at a time, where
+// the matches on each line are found when they're first needed, and found
+// again when the line is changed. Other searches are done over all of the
+// text, like findDown and findUp, and done again when the buffer is changed.
+type searchMatches struct {
+	search     string
+	ignoreCase bool
+	// regex is nil if the search isn't a valid regex
+	regex *regexp.Regexp
+	// multiline is set if the regex has to be matched against all of the text
+	// rather than a line at a time
+	multiline bool
+	// la is the text which was searched, which is replaced when a file is
+	// opened again without comparing it with the buffer
+	la *LineArray
+	// lines holds the columns where each match starts and ends on each line,
+	// or nil for the lines which haven't been searched yet. A match which
+	// spans lines is split between them.
+	lines [][][2]int
+	// starts holds where each match of a multiline search starts
+	starts []Loc
+}
+
+// compileSearch compiles a search typed by the user, where ^ and $ match at
+// the start and end of each line
+func compileSearch(search string, ignoreCase bool) (*regexp.Regexp, error) {
+	if ignoreCase {
+		search = "(?i)" + search
+	}
+	return regexp.Compile("(?m)" + search)
+}
+
+// searchMatches returns the matches of the highlighted search in the buffer,
+// or nil if there isn't one
+func (b *Buffer) searchMatches() *searchMatches {
+	if highlightedSearch == "" {
+		return nil
+	}
+	ignoreCase := b.Settings["ignorecase"].(bool)
+	m := b.matches
+	if m == nil || m.search != highlightedSearch || m.ignoreCase != ignoreCase {
+		if m == nil {
+			b.AddChangeListener(func(start, end Loc, removed, inserted string) {
+				b.matches.change(start, end, inserted)
+			})
+		}
+		regex, _ := compileSearch(highlightedSearch, ignoreCase)
+		m = &searchMatches{search: highlightedSearch, ignoreCase: ignoreCase, regex: regex}
+		m.multiline = regex != nil && spansLines(regex)
+		b.matches = m
+	}
+	if m.regex == nil {
+		return nil
+	}
+	return m
+}
+
+// spansLines returns whether the matches of a regex can span lines, or depend
+// on where the text starts or ends, so that it can't be matched against one
+// line at a time.
+func spansLines(regex *regexp.Regexp) bool {
+	re, err := syntax.Parse(regex.String(), syntax.Perl)
+	if err != nil {
+		return true
+	}
+	var check func(re *syntax.Regexp) bool
+	check = func(re *syntax.Regexp) bool {
+		switch re.Op {
+		case syntax.OpAnyChar, syntax.OpBeginText, syntax.OpEndText:
+			return true
+		case syntax.OpLiteral:
+			for _, c := range re.Rune {
+				if c == '\n' {
+					return true
+				}
+			}
+		case syntax.OpCharClass:
+			for i := 0; i+1 < len(re.Rune); i += 2 {
+				if re.Rune[i] <= '\n' && '\n' <= re.Rune[i+1] {
+					return true
+				}
+			}
+		}
+		for _, sub := range re.Sub {
+			if check(sub) {
+				return true
+			}
+		}
+		return false
+	}
+	return check(re)
+}
+
+// change forgets the matches on the lines from start to end, which have been
+// replaced with the inserted text
+func (m *searchMatches) change(start, end Loc, inserted string) {
+	if m.multiline || end.Y >= len(m.lines) {
+		m.lines = nil
+		return
+	}
+	changed := make([][][2]int, strings.Count(inserted, "\n")+1)
+	m.lines = append(m.lines[:start.Y], append(changed, m.lines[end.Y+1:]...)...)
+}
+
+// line returns the columns where each match on line y starts and ends. A
+// multiline search can't be done in a large file, since all of it would have
+// to be read, so it's done a line at a time there.
+func (m *searchMatches) line(b *Buffer, y int) [][2]int {
+	if m.la != b.LineArray || len(m.lines) != b.LinesNum() {
+		m.la = b.LineArray
+		m.lines = make([][][2]int, b.LinesNum())
+		if m.multiline && !b.LargeFile {
+			m.searchAll(b)
+		}
+	}
+	if m.lines[y] == nil {
+		data := b.LineBytes(y)
+		matches := [][2]int{}
+		for _, loc := range m.regex.FindAllIndex(data, -1) {
+			// Empty matches can't be seen
+			if loc[0] != loc[1] {
+				matches = append(matches, [2]int{runePos(loc[0], string(data)), runePos(loc[1], string(data))})
+			}
+		}
+		m.lines[y] = matches
+	}
+	return m.lines[y]
+}
+
+// searchAll finds the matches of a multiline search in all of the text of the
+// buffer, with the lines joined by newlines like in findDown
+func (m *searchMatches) searchAll(b *Buffer) {
+	for y := range m.lines {
+		m.lines[y] = [][2]int{}
+	}
+	m.starts = nil
+	text := b.LineArray.Bytes(false)
+	locate := locator(text, b.Start())
+	for _, loc := range m.regex.FindAllIndex(text, -1) {
+		// Empty matches can't be seen
+		if loc[0] == loc[1] {
+			continue
+		}
+		from, to := locate(loc[0]), locate(loc[1])
+		m.starts = append(m.starts, from)
+		for y := from.Y; y <= to.Y; y++ {
+			start, end := 0, to.X
+			if y == from.Y {
+				start = from.X
+			}
+			if y < to.Y {
+				end = utf8.RuneCount(b.LineBytes(y))
+			}
+			if start < end {
+				m.lines[y] = append(m.lines[y], [2]int{start, end})
+			}
+		}
+	}
+}
+
+// count returns the number of matches in the buffer, and the number of them
+// which start at or before loc. Large files aren't counted, since all of
+// their lines would have to be read.
+func (m *searchMatches) count(b *Buffer, loc Loc) (total, before int, ok bool) {
+	if b.LargeFile {
+		return 0, 0, false
+	}
+	if m.multiline {
+		m.line(b, 0)
+		for _, start := range m.starts {
+			if start.LessEqual(loc) {
+				before++
+			}
+		}
+		return len(m.starts), before, true
+	}
+	for y := 0; y < b.LinesNum(); y++ {
+		matches := m.line(b, y)
+		total += len(matches)
+		for _, match := range matches {
+			if y < loc.Y || y == loc.Y && match[0] <= loc.X {
+				before++
+			}
+		}
+	}
+	return total, before, true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchMatches(t *testing.T) {
+	b := NewBufferFromString("foo bar\nbaz foo foo\nqux", "")
+	highlightedSearch = "foo"
+	defer func() { highlightedSearch = "" }()
+
+	m := b.searchMatches()
+	if m == nil {
+		t.Fatal("expected the search to be highlighted")
+	}
+	check := func(loc Loc, total, before int) {
+		t.Helper()
+		gotTotal, gotBefore, ok := m.count(b, loc)
+		if !ok || gotTotal != total || gotBefore != before {
+			t.Errorf("expected match %d/%d at %v, got %d/%d", before, total, loc, gotBefore, gotTotal)
+		}
+	}
+	check(Loc{4, 1}, 3, 2)
+	check(Loc{0, 0}, 3, 1)
+	if expected := [][2]int{{4, 7}, {8, 11}}; !reflect.DeepEqual(m.line(b, 1), expected) {
+		t.Errorf("expected matches at %v, got %v", expected, m.line(b, 1))
+	}
+
+	// Only the changed lines are searched again
+	b.Insert(Loc{0, 2}, "foo\nfoo ")
+	check(Loc{0, 2}, 5, 4)
+	if expected := [][2]int{{0, 3}}; !reflect.DeepEqual(m.line(b, 3), expected) {
+		t.Errorf("expected matches at %v, got %v", expected, m.line(b, 3))
+	}
+	b.Remove(Loc{0, 0}, Loc{4, 1})
+	check(Loc{0, 0}, 4, 1)
+
+	// Matches which span lines are found in all of the text, like by
+	// findDown, and split between the lines
+	highlightedSearch = `o\n\w`
+	m = b.searchMatches()
+	check(Loc{0, 1}, 2, 1)
+	if expected := [][2]int{{0, 1}, {2, 3}}; !reflect.DeepEqual(m.line(b, 1), expected) {
+		t.Errorf("expected matches at %v, got %v", expected, m.line(b, 1))
+	}
+	b.Insert(Loc{0, 1}, "baz ")
+	check(Loc{0, 1}, 2, 1)
+	if expected := [][2]int{{0, 1}, {6, 7}}; !reflect.DeepEqual(m.line(b, 1), expected) {
+		t.Errorf("expected matches at %v, got %v", expected, m.line(b, 1))
+	}
+
+	// The matches follow a replacement which is only being previewed
+	highlightedSearch = "foo"
+	b = NewBufferFromString("foo bar", "")
+	m = b.searchMatches()
+	check(Loc{0, 0}, 1, 1)
+	_, restore := previewReplacement(b, region{Loc{0, 0}, Loc{3, 0}}, "baz")
+	if len(m.line(b, 0)) != 0 {
+		t.Errorf("expected no matches in the preview, got %v", m.line(b, 0))
+	}
+	restore()
+	check(Loc{0, 0}, 1, 1)
+
+	highlightedSearch = "(foo"
+	if b.searchMatches() != nil {
+		t.Errorf("expected an invalid search not to be highlighted")
+	}
+}
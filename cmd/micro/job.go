@@ -23,6 +23,12 @@ type JobFunction struct {
 	args     []string
 }
 
+// RunInMainLoop runs f in the main loop, where it's safe to use the
+// editor's state. It can be called from any goroutine
+func RunInMainLoop(f func()) {
+	callbacks <- f
+}
+
 // A CallbackFile is the data structure that makes it possible to catch stderr and stdout write events
 type CallbackFile struct {
 	io.Writer
@@ -30,112 +30,303 @@ func runeToByteIndex(n int, txt []byte) int {
 	return count
 }
 
-// A Line contains the data in bytes as well as a highlight state, match
-// and a flag for whether the highlighting needs to be updated
+// A Line contains the data in bytes as well as its line ending, a highlight
+// state, match and a flag for whether the highlighting needs to be updated
 type Line struct {
 	data []byte
+	eol  lineEnding
 
 	state       highlight.State
 	match       highlight.LineMatch
 	rehighlight bool
 }
 
-// A LineArray simply stores and array of lines and makes it easy to insert
-// and delete in it
+// A LineArray stores the lines of a buffer in a balanced tree (see
+// linetree.go), so that lines can be found, inserted and removed in O(log n)
+// however large the file is. Line data is never changed in place, which lets
+// Snapshot share it.
 type LineArray struct {
-	lines []Line
-}
-
-// Append efficiently appends lines together
-// It allocates an additional 10000 lines if the original estimate
-// is incorrect
-func Append(slice []Line, data ...Line) []Line {
-	l := len(slice)
-	if l+len(data) > cap(slice) { // reallocate
-		newSlice := make([]Line, (l+len(data))+10000)
-		// The copy function is predeclared and works for any slice type.
-		copy(newSlice, slice)
-		slice = newSlice
+	root  *lineNode
+	owner *lineOwner
+	// source is the large file which the chunks are read from, if any
+	source *chunkSource
+}
+
+// lineArena hands out the memory for lines read from a file in large blocks,
+// instead of allocating each line separately.
+type lineArena struct {
+	block []byte
+}
+
+const lineArenaBlockSize = 1 << 20
+
+// store copies data into the arena. The copy has no spare capacity, so
+// appending to it can't overwrite the next line.
+func (a *lineArena) store(data []byte) []byte {
+	if len(data) > lineArenaBlockSize/4 {
+		return append([]byte(nil), data...)
 	}
-	slice = slice[0 : l+len(data)]
-	for i, c := range data {
-		slice[l+i] = c
+	if len(data) > cap(a.block)-len(a.block) {
+		a.block = make([]byte, 0, lineArenaBlockSize)
 	}
-	return slice
+	start := len(a.block)
+	a.block = append(a.block, data...)
+	return a.block[start:len(a.block):len(a.block)]
 }
 
 // NewLineArray returns a new line array from an array of bytes
 func NewLineArray(size int64, reader io.Reader) *LineArray {
-	la := new(LineArray)
+	la, _ := readLineArray(reader)
+	return la
+}
 
-	la.lines = make([]Line, 0, 1000)
+// readLineArray reads a new line array from reader. If reading fails, the
+// lines which were read before the error are returned with it.
+func readLineArray(reader io.Reader) (*LineArray, error) {
+	la := &LineArray{owner: new(lineOwner)}
+	var readErr error
+
+	br := bufio.NewReaderSize(reader, 64*1024)
+	var arena lineArena
+	var leaves []*lineNode
+	lines := make([]Line, 0, maxLeafLines)
+	add := func(data []byte, eol lineEnding) {
+		lines = append(lines, Line{data: arena.store(data), eol: eol})
+		if len(lines) == maxLeafLines {
+			leaves = append(leaves, newLeaf(la.owner, lines))
+			lines = make([]Line, 0, maxLeafLines)
+		}
+	}
 
-	br := bufio.NewReader(reader)
-	var loaded int
+	// A carriage return on its own only ends a line if the first line ends
+	// with one, since it's more likely to be part of the text otherwise
+	mac := false
+	if start, _ := br.Peek(64 * 1024); len(start) > 0 {
+		if i := bytes.IndexAny(start, "\r\n"); i >= 0 && start[i] == '\r' {
+			mac = i+1 < len(start) && start[i+1] != '\n'
+		}
+	}
 
-	n := 0
 	for {
-		data, err := br.ReadBytes('\n')
-		if len(data) > 1 && data[len(data)-2] == '\r' {
-			data = append(data[:len(data)-2], '\n')
-			if fileformat == 0 {
-				fileformat = 2
-			}
-		} else if len(data) > 0 {
-			if fileformat == 0 {
-				fileformat = 1
+		data, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// The line is longer than the reader's buffer.
+			long := append([]byte(nil), data...)
+			for err == bufio.ErrBufferFull {
+				data, err = br.ReadSlice('\n')
+				long = append(long, data...)
 			}
+			data = long
 		}
-
-		if n >= 1000 && loaded >= 0 {
-			totalLinesNum := int(float64(size) * (float64(n) / float64(loaded)))
-			newSlice := make([]Line, len(la.lines), totalLinesNum+10000)
-			copy(newSlice, la.lines)
-			la.lines = newSlice
-			loaded = -1
+		if err != nil && err != io.EOF {
+			readErr = err
+			break
 		}
 
-		if loaded >= 0 {
-			loaded += len(data)
+		eol := eolDefault
+		if err == nil {
+			data, eol = trimNewline(data[:len(data)-1])
 		}
+		if mac {
+			for i := bytes.IndexByte(data, '\r'); i >= 0; i = bytes.IndexByte(data, '\r') {
+				add(data[:i], eolCR)
+				data = data[i+1:]
+			}
+		}
+		add(data, eol)
 
 		if err != nil {
-			if err == io.EOF {
-				la.lines = Append(la.lines, Line{data[:], nil, nil, false})
-				// la.lines = Append(la.lines, Line{data[:len(data)]})
-			}
 			// Last line was read
 			break
-		} else {
-			// la.lines = Append(la.lines, Line{data[:len(data)-1]})
-			la.lines = Append(la.lines, Line{data[:len(data)-1], nil, nil, false})
 		}
-		n++
 	}
+	if len(lines) > 0 {
+		leaves = append(leaves, newLeaf(la.owner, lines))
+	}
+	la.root = buildTree(la.owner, leaves)
 
-	return la
+	return la, readErr
+}
+
+// NewFileLineArray returns a line array for a large file, whose lines are
+// read from r when they're needed until they're edited. The file is read once
+// to count its lines, and is kept open until closeFile is called.
+func NewFileLineArray(r io.ReaderAt, size int64) (*LineArray, error) {
+	src := &chunkSource{r: r}
+	la := &LineArray{owner: new(lineOwner), source: src}
+	root, err := buildFileChunks(la.owner, src, size)
+	if err != nil {
+		return nil, err
+	}
+	la.root = root
+	return la, nil
+}
+
+// fileError returns the error which stopped the lines which haven't been
+// edited from being read from the file which the line array was made from,
+// after which the line array can't be written. It's nil if they can still be
+// read, or if the line array wasn't made from a file.
+func (la *LineArray) fileError() error {
+	if la.source == nil {
+		return nil
+	}
+	return la.source.failed()
+}
+
+// closeFile stops reading the lines which haven't been edited from the file
+// which the line array was made from, if there is one, and closes it. Its
+// snapshots read the lines as empty after that, and can't be written.
+func (la *LineArray) closeFile() {
+	if la.source != nil {
+		la.source.close()
+	}
+}
+
+// Snapshot returns a copy of the line array which isn't affected by later
+// changes to it. It takes constant time, and the copy can be read from another
+// goroutine while the original is being edited.
+func (la *LineArray) Snapshot() *LineArray {
+	// From now on, the nodes which the snapshot shares have to be copied
+	// before they're changed.
+	la.owner = new(lineOwner)
+	return &LineArray{root: la.root, owner: new(lineOwner)}
+}
+
+// restore puts back the lines the line array had when snapshot was taken.
+func (la *LineArray) restore(snapshot *LineArray) {
+	la.root = snapshot.root
+}
+
+// lineCount returns the number of lines.
+func (la *LineArray) lineCount() int {
+	return la.root.count
+}
+
+// lineData returns the bytes of line n, which must not be changed.
+func (la *LineArray) lineData(n int) []byte {
+	return la.root.get(n).data
+}
+
+// lineOffset returns the byte offset of the start of line n, counting the
+// newlines before it.
+func (la *LineArray) lineOffset(n int) int {
+	return la.root.offset(n) + n
+}
+
+// eachLine calls fn with the data of lines start to end (exclusive), stopping
+// if it returns false.
+func (la *LineArray) eachLine(start, end int, fn func(n int, data []byte) bool) {
+	la.root.each(start, end, func(i int, l *Line) bool {
+		return fn(i, l.data)
+	})
+}
+
+// setLine changes line n with fn.
+func (la *LineArray) setLine(n int, fn func(l *Line)) {
+	la.root = la.root.update(la.owner, n, fn)
+}
+
+// setData replaces the bytes of line n.
+func (la *LineArray) setData(n int, data []byte) {
+	la.setLine(n, func(l *Line) {
+		l.data = data
+	})
+}
+
+// splice replaces lines start to end (exclusive) with lines.
+func (la *LineArray) splice(start, end int, lines ...Line) {
+	la.root = la.root.splice(la.owner, start, end, lines)
+}
+
+// concatBytes returns a new slice holding all of the parts.
+func concatBytes(parts ...[]byte) []byte {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	b := make([]byte, 0, n)
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
 }
 
 // Buffer gets the lineArray as a bytes.Buffer.
 func (la *LineArray) Buffer(useCrlf bool) *bytes.Buffer {
-	buf := bytes.NewBuffer([]byte{})
-	for i, l := range la.lines {
-		buf.Write(l.data)
-		if i != len(la.lines)-1 {
-			if useCrlf {
-				buf.WriteRune('\r')
+	buf := bytes.NewBuffer(la.Bytes(useCrlf))
+	return buf
+}
+
+// Bytes returns the lines joined with newlines, or with crlf line endings
+// if useCrlf is true.
+func (la *LineArray) Bytes(useCrlf bool) []byte {
+	newline := []byte{'\n'}
+	if useCrlf {
+		newline = []byte{'\r', '\n'}
+	}
+	count := la.lineCount()
+	b := make([]byte, 0, la.root.bytes+Max(count-1, 0)*len(newline))
+	la.eachLine(0, count, func(i int, data []byte) bool {
+		b = append(b, data...)
+		if i != count-1 {
+			b = append(b, newline...)
+		}
+		return true
+	})
+	return b
+}
+
+// write writes the lines to w, each but the last followed by its line ending,
+// or by format if it has none. Chunks of a large file which haven't been
+// edited are written as they are.
+func (la *LineArray) write(w io.Writer, format lineEnding) (n int, err error) {
+	write := func(b []byte) {
+		if err == nil {
+			var written int
+			written, err = w.Write(b)
+			n += written
+		}
+	}
+	last := la.lineCount() - 1
+	var walk func(node *lineNode, base int)
+	walk = func(node *lineNode, base int) {
+		if !node.isLeaf() {
+			walk(node.left, base)
+			walk(node.right, base+node.left.count)
+			return
+		}
+		if node.count == 0 || err != nil {
+			return
+		}
+		if node.isChunk() {
+			data, e := node.chunk.read()
+			if e != nil {
+				err = e
+				return
+			}
+			write(data)
+			if base+node.count-1 != last {
+				write(node.end.bytes(format))
 			}
-			buf.WriteRune('\n')
+			return
 		}
+		node.each(0, node.count, func(i int, l *Line) bool {
+			write(l.data)
+			if base+i != last {
+				write(l.eol.bytes(format))
+			}
+			return err == nil
+		})
 	}
-	return buf
+	walk(la.root, 0)
+	return n, err
 }
 
 // SaveString returns the string that should be written to disk when
 // the line array is saved
 // It is the same as string but uses crlf or lf line endings depending
 func (la *LineArray) SaveString(useCrlf bool) string {
-	return la.Buffer(useCrlf).String()
+	return string(la.Bytes(useCrlf))
 }
 
 // Returns the String representation of the LineArray
@@ -145,122 +336,153 @@ func (la *LineArray) String() string {
 
 // NewlineBelow adds a newline below the given line number
 func (la *LineArray) NewlineBelow(y int) {
-	la.lines = append(la.lines, Line{[]byte{' '}, nil, nil, false})
-	copy(la.lines[y+2:], la.lines[y+1:])
-	la.lines[y+1] = Line{[]byte{}, la.lines[y].state, nil, false}
+	la.splice(y+1, y+1, Line{data: []byte{}, state: la.State(y)})
 }
 
 // inserts a byte array at a given location
 func (la *LineArray) insert(pos Loc, value []byte) {
-	x, y := runeToByteIndex(pos.X, la.lines[pos.Y].data), pos.Y
-	// x, y := pos.x, pos.y
-	for i := 0; i < len(value); i++ {
-		if value[i] == '\n' {
-			la.Split(Loc{x, y})
-			x = 0
-			y++
-			continue
-		}
-		la.insertByte(Loc{x, y}, value[i])
-		x++
+	l := la.root.get(pos.Y)
+	line := l.data
+	x := runeToByteIndex(pos.X, line)
+	parts := bytes.Split(value, []byte{'\n'})
+	if len(parts) == 1 {
+		la.setData(pos.Y, concatBytes(line[:x], value, line[x:]))
+		return
+	}
+
+	// The line is split, and the highlighting state and line ending at its end
+	// move to the last of the new lines.
+	lines := make([]Line, len(parts))
+	for i, p := range parts {
+		lines[i] = Line{data: concatBytes(p), rehighlight: true}
 	}
+	last := len(lines) - 1
+	lines[0].data = concatBytes(line[:x], parts[0])
+	lines[last].data = concatBytes(parts[last], line[x:])
+	lines[last].eol = l.eol
+	lines[last].state = l.state
+	lines[last].rehighlight = false
+	la.splice(pos.Y, pos.Y+1, lines...)
 }
 
 // inserts a byte at a given location
 func (la *LineArray) insertByte(pos Loc, value byte) {
-	la.lines[pos.Y].data = append(la.lines[pos.Y].data, 0)
-	copy(la.lines[pos.Y].data[pos.X+1:], la.lines[pos.Y].data[pos.X:])
-	la.lines[pos.Y].data[pos.X] = value
+	line := la.lineData(pos.Y)
+	la.setData(pos.Y, concatBytes(line[:pos.X], []byte{value}, line[pos.X:]))
 }
 
 // JoinLines joins the two lines a and b
 func (la *LineArray) JoinLines(a, b int) {
-	la.insert(Loc{len(la.lines[a].data), a}, la.lines[b].data)
+	lb := la.root.get(b)
+	data, eol := concatBytes(la.lineData(a), lb.data), lb.eol
+	la.setLine(a, func(l *Line) {
+		l.data, l.eol = data, eol
+	})
 	la.DeleteLine(b)
 }
 
 // Split splits a line at a given position
 func (la *LineArray) Split(pos Loc) {
-	la.NewlineBelow(pos.Y)
-	la.insert(Loc{0, pos.Y + 1}, la.lines[pos.Y].data[pos.X:])
-	la.lines[pos.Y+1].state = la.lines[pos.Y].state
-	la.lines[pos.Y].state = nil
-	la.lines[pos.Y].match = nil
-	la.lines[pos.Y+1].match = nil
-	la.lines[pos.Y].rehighlight = true
-	la.DeleteToEnd(Loc{pos.X, pos.Y})
+	l := la.root.get(pos.Y)
+	la.splice(pos.Y, pos.Y+1,
+		Line{data: concatBytes(l.data[:pos.X]), rehighlight: true},
+		Line{data: concatBytes(l.data[pos.X:]), eol: l.eol, state: l.state},
+	)
 }
 
 // removes from start to end
 func (la *LineArray) remove(start, end Loc) string {
 	sub := la.Substr(start, end)
-	startX := runeToByteIndex(start.X, la.lines[start.Y].data)
-	endX := runeToByteIndex(end.X, la.lines[end.Y].data)
+	startLine, endLine := la.lineData(start.Y), la.lineData(end.Y)
+	startX := runeToByteIndex(start.X, startLine)
+	endX := runeToByteIndex(end.X, endLine)
+	data := concatBytes(startLine[:startX], endLine[endX:])
 	if start.Y == end.Y {
-		la.lines[start.Y].data = append(la.lines[start.Y].data[:startX], la.lines[start.Y].data[endX:]...)
+		la.setData(start.Y, data)
 	} else {
-		for i := start.Y + 1; i <= end.Y-1; i++ {
-			la.DeleteLine(start.Y + 1)
-		}
-		la.DeleteToEnd(Loc{startX, start.Y})
-		la.DeleteFromStart(Loc{endX - 1, start.Y + 1})
-		la.JoinLines(start.Y, start.Y+1)
+		l := *la.root.get(start.Y)
+		l.data = data
+		l.eol = la.root.get(end.Y).eol
+		la.splice(start.Y, end.Y+1, l)
 	}
 	return sub
 }
 
 // DeleteToEnd deletes from the end of a line to the position
 func (la *LineArray) DeleteToEnd(pos Loc) {
-	la.lines[pos.Y].data = la.lines[pos.Y].data[:pos.X]
+	la.setData(pos.Y, la.lineData(pos.Y)[:pos.X])
 }
 
 // DeleteFromStart deletes from the start of a line to the position
 func (la *LineArray) DeleteFromStart(pos Loc) {
-	la.lines[pos.Y].data = la.lines[pos.Y].data[pos.X+1:]
+	la.setData(pos.Y, la.lineData(pos.Y)[pos.X+1:])
 }
 
 // DeleteLine deletes the line number
 func (la *LineArray) DeleteLine(y int) {
-	la.lines = la.lines[:y+copy(la.lines[y:], la.lines[y+1:])]
+	la.splice(y, y+1)
 }
 
 // DeleteByte deletes the byte at a position
 func (la *LineArray) DeleteByte(pos Loc) {
-	la.lines[pos.Y].data = la.lines[pos.Y].data[:pos.X+copy(la.lines[pos.Y].data[pos.X:], la.lines[pos.Y].data[pos.X+1:])]
+	line := la.lineData(pos.Y)
+	la.setData(pos.Y, concatBytes(line[:pos.X], line[pos.X+1:]))
 }
 
 // Substr returns the string representation between two locations
 func (la *LineArray) Substr(start, end Loc) string {
-	startX := runeToByteIndex(start.X, la.lines[start.Y].data)
-	endX := runeToByteIndex(end.X, la.lines[end.Y].data)
+	startLine, endLine := la.lineData(start.Y), la.lineData(end.Y)
+	startX := runeToByteIndex(start.X, startLine)
+	endX := runeToByteIndex(end.X, endLine)
 	if start.Y == end.Y {
-		return string(la.lines[start.Y].data[startX:endX])
-	}
-	var str string
-	str += string(la.lines[start.Y].data[startX:]) + "\n"
-	for i := start.Y + 1; i <= end.Y-1; i++ {
-		str += string(la.lines[i].data) + "\n"
+		return string(startLine[startX:endX])
 	}
-	str += string(la.lines[end.Y].data[:endX])
-	return str
+	var b bytes.Buffer
+	b.Write(startLine[startX:])
+	b.WriteByte('\n')
+	la.eachLine(start.Y+1, end.Y, func(i int, data []byte) bool {
+		b.Write(data)
+		b.WriteByte('\n')
+		return true
+	})
+	b.Write(endLine[:endX])
+	return b.String()
 }
 
 // State gets the highlight state for the given line number
 func (la *LineArray) State(lineN int) highlight.State {
-	return la.lines[lineN].state
+	return la.root.get(lineN).state
 }
 
 // SetState sets the highlight state at the given line number
 func (la *LineArray) SetState(lineN int, s highlight.State) {
-	la.lines[lineN].state = s
+	la.setLine(lineN, func(l *Line) {
+		l.state = s
+	})
 }
 
 // SetMatch sets the match at the given line number
 func (la *LineArray) SetMatch(lineN int, m highlight.LineMatch) {
-	la.lines[lineN].match = m
+	la.setLine(lineN, func(l *Line) {
+		l.match = m
+	})
 }
 
 // Match retrieves the match for the given line number
 func (la *LineArray) Match(lineN int) highlight.LineMatch {
-	return la.lines[lineN].match
+	return la.root.get(lineN).match
+}
+
+// rehighlight returns whether the given line number needs to be highlighted
+// again.
+func (la *LineArray) rehighlight(lineN int) bool {
+	return la.root.get(lineN).rehighlight
+}
+
+// setRehighlight sets whether the given line number needs to be highlighted
+// again.
+func (la *LineArray) setRehighlight(lineN int, r bool) {
+	la.setLine(lineN, func(l *Line) {
+		l.rehighlight = r
+	})
 }
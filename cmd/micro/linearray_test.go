@@ -1,8 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"math/rand"
+	"os"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/zyedidia/micro/cmd/micro/highlight"
 )
 
 func TestLineArraySubstr(t *testing.T) {
@@ -114,3 +122,390 @@ func TestLineArrayRemove(t *testing.T) {
 		}
 	}
 }
+
+func TestLineArrayInsert(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		pos      Loc
+		value    string
+		expected string
+	}{
+		{
+			name:     "in a line",
+			input:    "abc\ndef",
+			pos:      Loc{Y: 1, X: 1},
+			value:    "xy",
+			expected: "abc\ndxyef",
+		},
+		{
+			name:     "newline",
+			input:    "abc\ndef",
+			pos:      Loc{Y: 0, X: 2},
+			value:    "\n",
+			expected: "ab\nc\ndef",
+		},
+		{
+			name:     "several lines",
+			input:    "abc\ndef",
+			pos:      Loc{Y: 1, X: 3},
+			value:    "\nx\ny\n",
+			expected: "abc\ndef\nx\ny\n",
+		},
+		{
+			name:     "after unicode",
+			input:    "äbc",
+			pos:      Loc{Y: 0, X: 1},
+			value:    "\n",
+			expected: "ä\nbc",
+		},
+	}
+
+	for _, test := range tests {
+		r := strings.NewReader(test.input)
+		la := NewLineArray(10, r)
+		la.insert(test.pos, []byte(test.value))
+		if la.String() != test.expected {
+			t.Errorf("%s: expected '%v', got '%v'", test.name, test.expected, la.String())
+		}
+	}
+}
+
+// TestLineArrayEdits makes random edits to a large line array and checks that it always has the same
+// lines as a slice which is edited in the same way.
+func TestLineArrayEdits(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var expected [][]byte
+	var text bytes.Buffer
+	for i := 0; i < 5000; i++ {
+		line := []byte(strings.Repeat("x", rnd.Intn(10)))
+		expected = append(expected, line)
+		if i > 0 {
+			text.WriteByte('\n')
+		}
+		text.Write(line)
+	}
+	la := NewLineArray(int64(text.Len()), &text)
+
+	for i := 0; i < 2000; i++ {
+		y := rnd.Intn(len(expected))
+		switch op := rnd.Intn(4); {
+		case op == 0 && len(expected) > 1:
+			end := Min(y+rnd.Intn(200)+1, len(expected)-1)
+			if end == y {
+				continue
+			}
+			la.remove(Loc{0, y}, Loc{0, end})
+			expected = append(expected[:y], expected[end:]...)
+		case op == 1:
+			lines := rnd.Intn(200)
+			la.insert(Loc{0, y}, bytes.Repeat([]byte("ab\n"), lines))
+			inserted := make([][]byte, lines)
+			for j := range inserted {
+				inserted[j] = []byte("ab")
+			}
+			expected = append(expected[:y], append(inserted, expected[y:]...)...)
+		default:
+			la.insert(Loc{0, y}, []byte("z"))
+			expected[y] = append([]byte("z"), expected[y]...)
+		}
+
+		if la.lineCount() != len(expected) {
+			t.Fatalf("edit %d: expected %d lines, got %d", i, len(expected), la.lineCount())
+		}
+	}
+
+	offset := 0
+	for y, line := range expected {
+		if !bytes.Equal(la.lineData(y), line) {
+			t.Fatalf("line %d: expected '%s', got '%s'", y, line, la.lineData(y))
+		}
+		if la.lineOffset(y) != offset {
+			t.Fatalf("line %d: expected offset %d, got %d", y, offset, la.lineOffset(y))
+		}
+		offset += len(line) + 1
+	}
+	if h, max := la.root.height, 2*bitLength(la.lineCount()); h > max {
+		t.Errorf("tree of %d lines has height %d, expected at most %d", la.lineCount(), h, max)
+	}
+}
+
+func bitLength(n int) (bits int) {
+	for ; n > 0; n >>= 1 {
+		bits++
+	}
+	return bits
+}
+
+func TestLineArraySnapshot(t *testing.T) {
+	input := strings.Repeat("abc\n", 1000) + "def"
+	la := NewLineArray(int64(len(input)), strings.NewReader(input))
+	la.SetMatch(5, highlight.LineMatch{})
+
+	s := la.Snapshot()
+	la.insert(Loc{0, 500}, []byte("x\ny"))
+	la.remove(Loc{0, 0}, Loc{0, 10})
+	la.SetMatch(5, nil)
+	la.DeleteLine(la.lineCount() - 1)
+
+	if s.String() != input {
+		t.Errorf("snapshot changed after the line array was edited")
+	}
+	if s.Match(5) == nil {
+		t.Errorf("snapshot lost the match of a line after the line array was edited")
+	}
+
+	expected := strings.Repeat("abc\n", 490) + "x\nyabc\n" + strings.Repeat("abc\n", 499)
+	if la.String() != expected[:len(expected)-1] {
+		t.Errorf("line array wasn't edited correctly after taking a snapshot")
+	}
+
+	// Editing the snapshot doesn't change the line array either.
+	s.DeleteLine(0)
+	if la.String() != expected[:len(expected)-1] {
+		t.Errorf("line array changed after its snapshot was edited")
+	}
+}
+
+func TestFileLineArray(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"lf", strings.Repeat("abc\ndéf\n", 100) + "end"},
+		{"crlf", strings.Repeat("abc\r\ndéf\r\n", 100)},
+		{"mixed", strings.Repeat("abc\ndéf\r\n", 100) + "\r"},
+	}
+
+	for _, test := range tests {
+		expected := NewLineArray(int64(len(test.input)), strings.NewReader(test.input))
+		la, err := NewFileLineArray(strings.NewReader(test.input), int64(len(test.input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		check := func(when string) {
+			if la.lineCount() != expected.lineCount() {
+				t.Fatalf("%s %s: expected %d lines, got %d", test.name, when, expected.lineCount(), la.lineCount())
+			}
+			if la.String() != expected.String() {
+				t.Fatalf("%s %s: expected '%s', got '%s'", test.name, when, expected.String(), la.String())
+			}
+			for y := 0; y < la.lineCount(); y++ {
+				if la.lineOffset(y) != expected.lineOffset(y) {
+					t.Fatalf("%s %s: expected line %d at offset %d, got %d", test.name, when, y, expected.lineOffset(y), la.lineOffset(y))
+				}
+			}
+			var a, b bytes.Buffer
+			expected.write(&a, eolLF)
+			la.write(&b, eolLF)
+			if a.String() != b.String() {
+				t.Fatalf("%s %s: expected to write %q, wrote %q", test.name, when, a.String(), b.String())
+			}
+		}
+		check("after loading")
+
+		for _, la := range []*LineArray{expected, la} {
+			la.insert(Loc{1, 70}, []byte("x\ny"))
+			la.remove(Loc{1, 10}, Loc{2, 20})
+			la.DeleteLine(100)
+			la.SetMatch(120, highlight.LineMatch{})
+		}
+		check("after editing")
+	}
+}
+
+func TestFileLineArrayReload(t *testing.T) {
+	input := strings.Repeat("abc\r\ndéf\n", 500)
+	defer func(max int) { maxLoadedChunkBytes = max }(maxLoadedChunkBytes)
+	maxLoadedChunkBytes = 1000
+
+	// Chunks which have been dropped from memory are read again.
+	la, _ := NewFileLineArray(strings.NewReader(input), int64(len(input)))
+	for i := 0; i < 2; i++ {
+		if la.String() != strings.Replace(input, "\r\n", "\n", -1) {
+			t.Fatalf("expected the text to be read again")
+		}
+	}
+
+	load := func() (*LineArray, *os.File) {
+		f, err := ioutil.TempFile("", "linearray")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(input)
+		la, err := NewFileLineArray(f, int64(len(input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(la.lineData(0)) != "abc" {
+			t.Fatalf("expected the first line, got %q", la.lineData(0))
+		}
+		return la, f
+	}
+
+	// A file which another program appended to is still read as it was.
+	la, f := load()
+	defer os.Remove(f.Name())
+	defer la.closeFile()
+	f.WriteString("more\n")
+	var b bytes.Buffer
+	if _, err := la.write(&b, eolLF); err != nil || b.String() != input || la.fileError() != nil {
+		t.Errorf("expected the lines from before the file grew to be written, got %v", err)
+	}
+
+	// A file which is truncated by another program can't be written, and the
+	// lines which can't be read are shown as empty.
+	la, f = load()
+	defer os.Remove(f.Name())
+	defer la.closeFile()
+	f.Truncate(0)
+	if la.lineCount() != 1001 || len(la.lineData(900)) != 0 {
+		t.Errorf("expected the changed lines to be empty, got %q of %d lines", la.lineData(900), la.lineCount())
+	}
+	if string(la.lineData(0)) != "abc" {
+		t.Errorf("expected the lines which were read to be kept, got %q", la.lineData(0))
+	}
+	if _, err := la.write(ioutil.Discard, eolLF); err != errFileChanged || la.fileError() != errFileChanged {
+		t.Errorf("expected writing the changed file to fail, got %v", err)
+	}
+}
+
+func TestLineArrayWrite(t *testing.T) {
+	input := strings.Repeat("abc\r\ndef\n", 100) + "end"
+	tests := []struct {
+		format lineEnding
+		eol    string
+	}{
+		{eolLF, "\n"},
+		{eolCRLF, "\r\n"},
+		{eolCR, "\r"},
+	}
+
+	for _, test := range tests {
+		la, _ := NewFileLineArray(strings.NewReader(input), int64(len(input)))
+		var b bytes.Buffer
+		n, err := la.write(&b, test.format)
+		if err != nil || n != len(input) || b.String() != input {
+			t.Errorf("%q: expected %d bytes, wrote %d (%v)", test.eol, len(input), n, err)
+		}
+
+		// Lines which are edited keep their line endings, and new lines are
+		// given the format's.
+		la.insert(Loc{0, 150}, []byte("\n"))
+		b.Reset()
+		la.write(&b, test.format)
+		lines := strings.SplitAfter(input, "\n")
+		expected := strings.Join(lines[:150], "") + test.eol + strings.Join(lines[150:], "")
+		if b.String() != expected {
+			t.Errorf("%q: wrote %q after editing, expected %q", test.eol, b.String(), expected)
+		}
+	}
+}
+
+var benchmarkSize = flag.Int("linearray.size", 256<<20, "size in bytes of the text used by line array benchmarks")
+
+var benchmarkText struct {
+	once sync.Once
+	data []byte
+}
+
+// benchmarkInput returns text with lines of varying lengths, like source code.
+func benchmarkInput() []byte {
+	benchmarkText.once.Do(func() {
+		rnd := rand.New(rand.NewSource(1))
+		data := make([]byte, 0, *benchmarkSize+100)
+		for len(data) < *benchmarkSize {
+			data = append(data, strings.Repeat("\t", rnd.Intn(4))...)
+			data = append(data, strings.Repeat("word ", rnd.Intn(16))...)
+			data = append(data, '\n')
+		}
+		benchmarkText.data = data
+	})
+	return benchmarkText.data
+}
+
+func benchmarkLineArray() *LineArray {
+	data := benchmarkInput()
+	return NewLineArray(int64(len(data)), bytes.NewReader(data))
+}
+
+func BenchmarkLineArrayLoad(b *testing.B) {
+	data := benchmarkInput()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewLineArray(int64(len(data)), bytes.NewReader(data))
+	}
+}
+
+func BenchmarkLineArrayLine(b *testing.B) {
+	la := benchmarkLineArray()
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		la.lineData(rnd.Intn(la.lineCount()))
+	}
+}
+
+func BenchmarkLineArrayInsertNewline(b *testing.B) {
+	la := benchmarkLineArray()
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		la.insert(Loc{0, rnd.Intn(la.lineCount())}, []byte("\n"))
+	}
+}
+
+func BenchmarkLineArrayRemoveLines(b *testing.B) {
+	la := benchmarkLineArray()
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		y := rnd.Intn(la.lineCount() - 10)
+		la.remove(Loc{0, y}, Loc{0, y + 10})
+	}
+}
+
+func BenchmarkLineArrayOffset(b *testing.B) {
+	la := benchmarkLineArray()
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		la.lineOffset(rnd.Intn(la.lineCount()))
+	}
+}
+
+// BenchmarkLineArraySnapshotEdit takes a snapshot before each edit, as autocomplete does while typing.
+func BenchmarkLineArraySnapshotEdit(b *testing.B) {
+	la := benchmarkLineArray()
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		la.Snapshot()
+		la.insert(Loc{0, rnd.Intn(la.lineCount())}, []byte("x"))
+	}
+}
+
+func BenchmarkFileLineArrayLoad(b *testing.B) {
+	data := benchmarkInput()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewFileLineArray(bytes.NewReader(data), int64(len(data)))
+	}
+}
+
+func BenchmarkFileLineArrayWrite(b *testing.B) {
+	data := benchmarkInput()
+	la, _ := NewFileLineArray(bytes.NewReader(data), int64(len(data)))
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		la.insert(Loc{0, rnd.Intn(la.lineCount())}, []byte("x"))
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		la.write(ioutil.Discard, eolLF)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import "time"
+
+// Each line keeps the line ending it had in the file, so that a file with
+// mixed line endings is saved the way it was read. Lines which are added
+// while editing have no ending of their own, and are given the one of the
+// fileformat option when the file is saved.
+
+// A lineEnding is the line ending after a line
+type lineEnding uint8
+
+const (
+	// eolDefault is the ending of the fileformat option
+	eolDefault lineEnding = iota
+	eolLF
+	eolCRLF
+	eolCR
+
+	lineEndings = iota
+)
+
+// fileFormats maps the values of the fileformat option to their line endings
+var fileFormats = map[string]lineEnding{
+	"unix": eolLF,
+	"dos":  eolCRLF,
+	"mac":  eolCR,
+}
+
+// bytes returns the line ending, using format for eolDefault
+func (e lineEnding) bytes(format lineEnding) []byte {
+	if e == eolDefault {
+		e = format
+	}
+	switch e {
+	case eolCRLF:
+		return []byte{'\r', '\n'}
+	case eolCR:
+		return []byte{'\r'}
+	}
+	return []byte{'\n'}
+}
+
+// String returns the name of the line ending in the fileformat option
+func (e lineEnding) String() string {
+	for name, f := range fileFormats {
+		if f == e {
+			return name
+		}
+	}
+	return ""
+}
+
+// lineEndingCounts returns the number of lines with each line ending, not
+// counting the last line, whose ending isn't written
+func (la *LineArray) lineEndingCounts() [lineEndings]int {
+	counts := la.root.endings
+	counts[la.root.get(la.lineCount()-1).eol]--
+	return counts
+}
+
+// commonLineEnding returns the line ending of the most lines, or eolDefault if
+// there's only one line
+func (la *LineArray) commonLineEnding() lineEnding {
+	counts := la.lineEndingCounts()
+	common, most := eolDefault, 0
+	for e := eolLF; e < lineEndings; e++ {
+		if counts[e] > most {
+			common, most = e, counts[e]
+		}
+	}
+	return common
+}
+
+// swapLineEndings gives line i the line ending endings[i], and returns the
+// endings the lines had instead of them
+func (la *LineArray) swapLineEndings(endings []lineEnding) []lineEnding {
+	la.root = la.root.updateEach(la.owner, 0, func(i int, l *Line) {
+		if i < len(endings) {
+			l.eol, endings[i] = endings[i], l.eol
+		}
+	})
+	return endings
+}
+
+// lineEnding returns the line ending of the fileformat option
+func (b *Buffer) lineEnding() lineEnding {
+	return fileFormats[b.Settings["fileformat"].(string)]
+}
+
+// MixedLineEndings returns whether the lines of the buffer don't all end the
+// same way
+func (b *Buffer) MixedLineEndings() bool {
+	counts := b.lineEndingCounts()
+	counts[b.lineEnding()] += counts[eolDefault]
+	kinds := 0
+	for e := eolLF; e < lineEndings; e++ {
+		if counts[e] > 0 {
+			kinds++
+		}
+	}
+	return kinds > 1
+}
+
+// setLineEndings gives line i the line ending endings[i], as one change which
+// can be undone, if any of them is different
+func (b *Buffer) setLineEndings(endings []lineEnding) {
+	same := true
+	b.root.each(0, len(endings), func(i int, l *Line) bool {
+		same = l.eol == endings[i]
+		return same
+	})
+	if same {
+		return
+	}
+	b.Execute(&TextEvent{
+		C:         *b.cursors[b.curCursor],
+		EventType: TextEventReplace,
+		Endings:   endings,
+		Time:      time.Now(),
+	})
+	b.IsModified = true
+}
+
+// NormalizeLineEndings gives every line the line ending of the fileformat
+// option, as one change which can be undone
+func (b *Buffer) NormalizeLineEndings() {
+	b.setLineEndings(make([]lineEnding, b.lineCount()))
+}
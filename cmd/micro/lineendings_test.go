@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLineEndings(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		lines  int
+		common lineEnding
+		counts [lineEndings]int
+	}{
+		{"unix", "a\nb\n", 3, eolLF, [lineEndings]int{0, 2, 0, 0}},
+		{"dos", "a\r\nb\r\n", 3, eolCRLF, [lineEndings]int{0, 0, 2, 0}},
+		{"mac", "a\rb\rc", 3, eolCR, [lineEndings]int{0, 0, 0, 2}},
+		{"mixed", "a\r\nb\nc\r\n", 4, eolCRLF, [lineEndings]int{0, 1, 2, 0}},
+		{"one line", "abc", 1, eolDefault, [lineEndings]int{}},
+		// A lone carriage return only ends a line in a mac file
+		{"lone cr", "a\nb\rc", 2, eolLF, [lineEndings]int{0, 1, 0, 0}},
+	}
+
+	for _, test := range tests {
+		la := NewLineArray(int64(len(test.input)), strings.NewReader(test.input))
+		if la.lineCount() != test.lines {
+			t.Errorf("%s: expected %d lines, got %d", test.name, test.lines, la.lineCount())
+		}
+		if counts := la.lineEndingCounts(); counts != test.counts {
+			t.Errorf("%s: expected %v line endings, got %v", test.name, test.counts, counts)
+		}
+		if common := la.commonLineEnding(); common != test.common {
+			t.Errorf("%s: expected %q to be the most common line ending, got %q", test.name, test.common, common)
+		}
+		var b bytes.Buffer
+		la.write(&b, eolLF)
+		if b.String() != test.input {
+			t.Errorf("%s: wrote %q, expected the file as it was", test.name, b.String())
+		}
+	}
+}
+
+func TestLineEndingEdits(t *testing.T) {
+	input := "a\r\nb\nc"
+	la := NewLineArray(int64(len(input)), strings.NewReader(input))
+	check := func(when string, format lineEnding, expected string) {
+		var b bytes.Buffer
+		la.write(&b, format)
+		if b.String() != expected {
+			t.Errorf("%s: wrote %q, expected %q", when, b.String(), expected)
+		}
+	}
+
+	// The first half of a split line is a new line
+	la.Split(Loc{1, 0})
+	check("after splitting", eolCR, "a\r\r\nb\nc")
+	la.JoinLines(0, 1)
+	check("after joining", eolCR, input)
+
+	la.insert(Loc{1, 1}, []byte("x\ny"))
+	check("after inserting", eolCR, "a\r\nbx\ry\nc")
+	la.remove(Loc{1, 0}, Loc{0, 1})
+	check("after removing", eolCR, "abx\ry\nc")
+
+	endings := la.swapLineEndings(make([]lineEnding, la.lineCount()))
+	check("after normalizing", eolCRLF, "abx\r\ny\r\nc")
+	la.swapLineEndings(endings)
+	check("after restoring", eolCRLF, "abx\r\ny\nc")
+}
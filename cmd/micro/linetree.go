@@ -0,0 +1,426 @@
+package main
+
+import "bytes"
+
+// The lines of a LineArray are kept in a balanced binary tree, with the lines
+// themselves in the leaves. Each node knows how many lines and bytes are below
+// it, so a line or the offset of a line is found in O(log n), and lines are
+// inserted and removed by splitting and joining trees, which is also O(log n).
+//
+// Trees are persistent: a node which may be shared with a snapshot is never
+// changed, but copied along with the path to it. Copying every path on every
+// edit would be slow when highlighting a whole file, so each node records the
+// owner which created it, and the LineArray with the same owner changes its
+// own nodes in place. Taking a snapshot gives the LineArray a new owner, so
+// that from then on it copies the nodes that the snapshot can see.
+//
+// The leaves of a large file hold chunks of the file instead of lines, which
+// are read from it when they're needed, so that the file doesn't have to be
+// kept in memory, and only the leaves which are edited are split up into
+// lines. A chunk keeps the line
+// endings between its lines, and the ending after its last line is kept in
+// the node.
+
+const (
+	// maxLeafLines is the most lines that a leaf holds.
+	maxLeafLines = 64
+)
+
+// A lineOwner marks the nodes which a LineArray may change in place. It isn't
+// empty, because pointers to distinct zero sized values may be equal.
+type lineOwner struct {
+	_ byte
+}
+
+// lineNode is a node of the tree of lines. Leaves have lines, or a chunk, and
+// no children, and other nodes have exactly two children.
+type lineNode struct {
+	owner *lineOwner
+
+	lines       []Line
+	left, right *lineNode
+
+	// chunk holds the text of the lines of a leaf which hasn't been split
+	// into lines, with their original line endings but without the last one,
+	// which is end.
+	chunk *fileChunk
+	end   lineEnding
+
+	// count is the number of lines below the node, and bytes is the number of
+	// bytes in them, not counting newlines.
+	count, bytes int
+	height       int
+	// endings is the number of lines below the node with each line ending
+	endings [lineEndings]int
+}
+
+func newLeaf(owner *lineOwner, lines []Line) *lineNode {
+	n := &lineNode{owner: owner, lines: lines, count: len(lines)}
+	for _, l := range lines {
+		n.bytes += len(l.data)
+		n.endings[l.eol]++
+	}
+	return n
+}
+
+// newChunk returns a leaf holding a chunk, which has the given number of
+// newlines, crlf of which follow a carriage return.
+func newChunk(owner *lineOwner, chunk *fileChunk, newlines, crlf int, end lineEnding) *lineNode {
+	n := &lineNode{
+		owner: owner,
+		chunk: chunk,
+		end:   end,
+		count: newlines + 1,
+		bytes: chunk.size - newlines - crlf,
+	}
+	n.endings[eolLF] = newlines - crlf
+	n.endings[eolCRLF] = crlf
+	n.endings[end]++
+	return n
+}
+
+// newDataChunk returns a leaf holding a chunk whose text is data.
+func newDataChunk(owner *lineOwner, chunk *fileChunk, data []byte, end lineEnding) *lineNode {
+	return newChunk(owner, chunk, bytes.Count(data, []byte{'\n'}), bytes.Count(data, []byte{'\r', '\n'}), end)
+}
+
+func newBranch(owner *lineOwner, left, right *lineNode) *lineNode {
+	n := &lineNode{
+		owner:  owner,
+		left:   left,
+		right:  right,
+		count:  left.count + right.count,
+		height: Max(left.height, right.height) + 1,
+	}
+	n.sum()
+	return n
+}
+
+// sum updates the totals of a branch from its children.
+func (n *lineNode) sum() {
+	n.bytes = n.left.bytes + n.right.bytes
+	for i := range n.endings {
+		n.endings[i] = n.left.endings[i] + n.right.endings[i]
+	}
+}
+
+func (n *lineNode) isLeaf() bool {
+	return n.left == nil
+}
+
+func (n *lineNode) isChunk() bool {
+	return n.chunk != nil
+}
+
+// nextLine returns the first line of a chunk, without its line ending, the
+// rest of the chunk, and the line ending, which is eolDefault for the last
+// line.
+func nextLine(chunk []byte) (line, rest []byte, eol lineEnding) {
+	i := bytes.IndexByte(chunk, '\n')
+	if i < 0 {
+		return chunk, nil, eolDefault
+	}
+	line, rest, eol = chunk[:i:i], chunk[i+1:], eolLF
+	if i > 0 && line[i-1] == '\r' {
+		line, eol = line[:i-1:i-1], eolCRLF
+	}
+	return line, rest, eol
+}
+
+// chunkStart returns the index in the text of a chunk of the start of line i.
+func chunkStart(chunk []byte, i int) int {
+	start := 0
+	for ; i > 0; i-- {
+		start += bytes.IndexByte(chunk[start:], '\n') + 1
+	}
+	return start
+}
+
+// chunkLine returns line i of a chunk.
+func (n *lineNode) chunkLine(i int) *Line {
+	chunk := n.chunk.bytes()
+	line, _, eol := nextLine(chunk[chunkStart(chunk, i):])
+	if i == n.count-1 {
+		eol = n.end
+	}
+	return &Line{data: line, eol: eol}
+}
+
+// chunkLines splits a chunk into lines.
+func (n *lineNode) chunkLines() []Line {
+	lines := make([]Line, 0, n.count)
+	for rest := n.chunk.bytes(); len(lines) < n.count; {
+		var l Line
+		l.data, rest, l.eol = nextLine(rest)
+		lines = append(lines, l)
+	}
+	lines[len(lines)-1].eol = n.end
+	return lines
+}
+
+// buildTree builds a balanced tree from a list of leaves.
+func buildTree(owner *lineOwner, leaves []*lineNode) *lineNode {
+	switch len(leaves) {
+	case 0:
+		return newLeaf(owner, nil)
+	case 1:
+		return leaves[0]
+	}
+	mid := len(leaves) / 2
+	return newBranch(owner, buildTree(owner, leaves[:mid]), buildTree(owner, leaves[mid:]))
+}
+
+// buildLines builds a tree holding lines, which it takes ownership of.
+func buildLines(owner *lineOwner, lines []Line) *lineNode {
+	var leaves []*lineNode
+	for len(lines) > 0 {
+		n := Min(len(lines), maxLeafLines)
+		leaves = append(leaves, newLeaf(owner, lines[:n:n]))
+		lines = lines[n:]
+	}
+	return buildTree(owner, leaves)
+}
+
+// trimNewline removes the carriage return from a line ending which has had its
+// newline removed, and returns the chunk before it and the line ending.
+func trimNewline(chunk []byte) ([]byte, lineEnding) {
+	if n := len(chunk); n > 0 && chunk[n-1] == '\r' {
+		return chunk[:n-1], eolCRLF
+	}
+	return chunk, eolLF
+}
+
+// get returns line i, which must not be changed.
+func (n *lineNode) get(i int) *Line {
+	for !n.isLeaf() {
+		if i < n.left.count {
+			n = n.left
+		} else {
+			i -= n.left.count
+			n = n.right
+		}
+	}
+	if n.isChunk() {
+		return n.chunkLine(i)
+	}
+	return &n.lines[i]
+}
+
+// offset returns the number of bytes in the lines before line i.
+func (n *lineNode) offset(i int) (bytes int) {
+	for !n.isLeaf() {
+		if i < n.left.count {
+			n = n.left
+		} else {
+			i -= n.left.count
+			bytes += n.left.bytes
+			n = n.right
+		}
+	}
+	if n.isChunk() {
+		for rest := n.chunk.bytes(); i > 0; i-- {
+			var line []byte
+			line, rest, _ = nextLine(rest)
+			bytes += len(line)
+		}
+		return bytes
+	}
+	for _, l := range n.lines[:i] {
+		bytes += len(l.data)
+	}
+	return bytes
+}
+
+// mutable returns a copy of n which owner can change, or n itself if it
+// already belongs to owner. Chunks are always split into lines.
+func (n *lineNode) mutable(owner *lineOwner) *lineNode {
+	if n.owner == owner && !n.isChunk() {
+		return n
+	}
+	c := *n
+	c.owner = owner
+	if c.isChunk() {
+		c.lines, c.chunk = n.chunkLines(), nil
+	} else if c.isLeaf() {
+		c.lines = append([]Line(nil), n.lines...)
+	}
+	return &c
+}
+
+// update calls fn to change line i, copying the nodes on the way to it which
+// don't belong to owner, and returns the new root.
+func (n *lineNode) update(owner *lineOwner, i int, fn func(l *Line)) *lineNode {
+	n = n.mutable(owner)
+	if n.isLeaf() {
+		n.bytes -= len(n.lines[i].data)
+		n.endings[n.lines[i].eol]--
+		fn(&n.lines[i])
+		n.bytes += len(n.lines[i].data)
+		n.endings[n.lines[i].eol]++
+		return n
+	}
+	if i < n.left.count {
+		n.left = n.left.update(owner, i, fn)
+	} else {
+		n.right = n.right.update(owner, i-n.left.count, fn)
+	}
+	n.sum()
+	return n
+}
+
+// updateEach calls fn to change each line, which is numbered from base, and
+// returns the new root.
+func (n *lineNode) updateEach(owner *lineOwner, base int, fn func(i int, l *Line)) *lineNode {
+	n = n.mutable(owner)
+	if n.isLeaf() {
+		for i := range n.lines {
+			n.endings[n.lines[i].eol]--
+			fn(base+i, &n.lines[i])
+			n.endings[n.lines[i].eol]++
+		}
+		return n
+	}
+	n.left = n.left.updateEach(owner, base, fn)
+	n.right = n.right.updateEach(owner, base+n.left.count, fn)
+	n.sum()
+	return n
+}
+
+// each calls fn with lines start to end (exclusive) in order, stopping if fn
+// returns false.
+func (n *lineNode) each(start, end int, fn func(i int, l *Line) bool) bool {
+	return n.walk(0, start, end, fn)
+}
+
+func (n *lineNode) walk(base, start, end int, fn func(i int, l *Line) bool) bool {
+	if start >= base+n.count || end <= base {
+		return true
+	}
+	if n.isChunk() {
+		from, to := Max(start-base, 0), Min(end-base, n.count)
+		chunk := n.chunk.bytes()
+		rest := chunk[chunkStart(chunk, from):]
+		for j := from; j < to; j++ {
+			var l Line
+			l.data, rest, l.eol = nextLine(rest)
+			if j == n.count-1 {
+				l.eol = n.end
+			}
+			if !fn(base+j, &l) {
+				return false
+			}
+		}
+		return true
+	}
+	if n.isLeaf() {
+		from, to := Max(start-base, 0), Min(end-base, len(n.lines))
+		for j := from; j < to; j++ {
+			if !fn(base+j, &n.lines[j]) {
+				return false
+			}
+		}
+		return true
+	}
+	return n.left.walk(base, start, end, fn) && n.right.walk(base+n.left.count, start, end, fn)
+}
+
+// split splits the tree into the lines before i and the rest.
+func (n *lineNode) split(owner *lineOwner, i int) (left, right *lineNode) {
+	if n.isChunk() {
+		switch i {
+		case 0:
+			return newLeaf(owner, nil), n
+		case n.count:
+			return n, newLeaf(owner, nil)
+		}
+		data := n.chunk.bytes()
+		start := chunkStart(data, i)
+		before, eol := trimNewline(data[:start-1])
+		after := data[start:]
+		return newDataChunk(owner, n.chunk.slice(0, len(before), before), before, eol),
+			newDataChunk(owner, n.chunk.slice(start, len(data), after), after, n.end)
+	}
+	if n.isLeaf() {
+		return newLeaf(owner, append([]Line(nil), n.lines[:i]...)),
+			newLeaf(owner, append([]Line(nil), n.lines[i:]...))
+	}
+	if i <= n.left.count {
+		l, r := n.left.split(owner, i)
+		return l, join(owner, r, n.right)
+	}
+	l, r := n.right.split(owner, i-n.left.count)
+	return join(owner, n.left, l), r
+}
+
+// join joins two trees into one which has the lines of left followed by the
+// lines of right, keeping it balanced. Small leaves which meet are merged.
+func join(owner *lineOwner, left, right *lineNode) *lineNode {
+	switch {
+	case left.count == 0:
+		return right
+	case right.count == 0:
+		return left
+	case left.isLeaf() && right.isLeaf() && !left.isChunk() && !right.isChunk() &&
+		left.count+right.count <= maxLeafLines:
+		lines := make([]Line, 0, left.count+right.count)
+		lines = append(append(lines, left.lines...), right.lines...)
+		return newLeaf(owner, lines)
+	case left.height > right.height+1:
+		return joinRight(owner, left, right)
+	case right.height > left.height+1:
+		return joinLeft(owner, left, right)
+	}
+	return newBranch(owner, left, right)
+}
+
+// joinRight joins right onto the right hand side of left, which is taller.
+func joinRight(owner *lineOwner, left, right *lineNode) *lineNode {
+	var t *lineNode
+	if left.right.height <= right.height+1 {
+		t = join(owner, left.right, right)
+	} else {
+		t = joinRight(owner, left.right, right)
+	}
+	if t.height <= left.left.height+1 {
+		return newBranch(owner, left.left, t)
+	}
+	if t.right.height < t.left.height {
+		t = rotateRight(owner, t)
+	}
+	return rotateLeft(owner, newBranch(owner, left.left, t))
+}
+
+// joinLeft joins left onto the left hand side of right, which is taller.
+func joinLeft(owner *lineOwner, left, right *lineNode) *lineNode {
+	var t *lineNode
+	if right.left.height <= left.height+1 {
+		t = join(owner, left, right.left)
+	} else {
+		t = joinLeft(owner, left, right.left)
+	}
+	if t.height <= right.right.height+1 {
+		return newBranch(owner, t, right.right)
+	}
+	if t.left.height < t.right.height {
+		t = rotateLeft(owner, t)
+	}
+	return rotateRight(owner, newBranch(owner, t, right.right))
+}
+
+func rotateLeft(owner *lineOwner, n *lineNode) *lineNode {
+	r := n.right
+	return newBranch(owner, newBranch(owner, n.left, r.left), r.right)
+}
+
+func rotateRight(owner *lineOwner, n *lineNode) *lineNode {
+	l := n.left
+	return newBranch(owner, l.left, newBranch(owner, l.right, n.right))
+}
+
+// splice replaces lines start to end (exclusive) with lines, and returns the
+// new root.
+func (n *lineNode) splice(owner *lineOwner, start, end int, lines []Line) *lineNode {
+	before, rest := n.split(owner, start)
+	_, after := rest.split(owner, end-start)
+	return join(owner, join(owner, before, buildLines(owner, lines)), after)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zyedidia/micro/cmd/micro/lsp"
+	"github.com/zyedidia/micro/cmd/micro/optionprovider"
+	"github.com/zyedidia/micro/cmd/micro/shellwords"
+)
+
+// lspServers stores the language servers by filetype. They're started in the
+// background, and one which fails to start is tried again after a delay which
+// doubles each time, so that it isn't retried on every keystroke.
+var lspServers = make(map[string]*lspServer)
+
+const (
+	lspRetryDelay    = 5 * time.Second
+	lspMaxRetryDelay = 5 * time.Minute
+)
+
+type lspServer struct {
+	// client is nil until the server has started
+	client   *lsp.Client
+	starting bool
+	failures int
+	retryAt  time.Time
+}
+
+// lspDocuments stores the documents opened on a language server by buffer. It's
+// read by completion providers, which run in the background, so lspLock guards it.
+var lspDocuments = make(map[*Buffer]*lsp.Document)
+var lspLock sync.Mutex
+
+// LSPCommand returns the language server command configured for a filetype with
+// the "lsp.<filetype>" option, or nil if there isn't one.
+func LSPCommand(filetype string) []string {
+	command, ok := globalSettings["lsp."+filetype].(string)
+	if !ok || command == "" {
+		return nil
+	}
+	args, err := shellwords.Split(command)
+	if err != nil {
+		messenger.AddLog("lsp: invalid command for ", filetype, ": ", err.Error())
+		return nil
+	}
+	return args
+}
+
+// lspClient returns the language server for a filetype, or nil if it isn't
+// running yet. If it hasn't been started, it's started in the background, and
+// once it has initialized the buffers of the filetype are opened on it.
+func lspClient(filetype string) *lsp.Client {
+	s := lspServers[filetype]
+	if s == nil {
+		s = new(lspServer)
+		lspServers[filetype] = s
+	}
+	if s.client != nil || s.starting || time.Now().Before(s.retryAt) {
+		return s.client
+	}
+	command := LSPCommand(filetype)
+	if command == nil {
+		return nil
+	}
+	wd, _ := os.Getwd()
+	s.starting = true
+	go func() {
+		c, err := lsp.Start(command, wd)
+		RunInMainLoop(func() {
+			s.starting = false
+			if err == nil && lspServers[filetype] != s {
+				// The servers were shut down while it was starting
+				c.Shutdown()
+				return
+			}
+			if err != nil {
+				delay := lspRetryDelay << uint(Min(s.failures, 6))
+				if delay > lspMaxRetryDelay {
+					delay = lspMaxRetryDelay
+				}
+				s.failures++
+				s.retryAt = time.Now().Add(delay)
+				messenger.Error("Failed to start the language server for ", filetype, ": ", err)
+				return
+			}
+			s.client, s.failures = c, 0
+			for _, t := range tabs {
+				for _, v := range t.Views {
+					if v.Buf.FileType() == filetype {
+						lspDocument(v.Buf)
+					}
+				}
+			}
+		})
+	}()
+	return nil
+}
+
+// lspDocument returns the language server document for a buffer, opening it and
+// keeping it in sync with the buffer if it isn't open yet.
+func lspDocument(b *Buffer) *lsp.Document {
+	if d := openLSPDocument(b); d != nil {
+		return d
+	}
+	if b.AbsPath == "" {
+		return nil
+	}
+	c := lspClient(b.FileType())
+	if c == nil {
+		return nil
+	}
+
+	d, err := c.Open(lsp.PathToURI(b.AbsPath), b.FileType(), b.String())
+	if err != nil {
+		messenger.AddLog("lsp: failed to open ", b.AbsPath, ": ", err.Error())
+		return nil
+	}
+	b.AddChangeListener(func(start, end Loc, removed, inserted string) {
+		if openLSPDocument(b) != d {
+			return
+		}
+		var err error
+		if d.SyncKind() == lsp.SyncIncremental {
+			err = d.Change(lsp.TextDocumentContentChangeEvent{
+				Range: lspRange(b, start, end, removed),
+				Text:  inserted,
+			})
+		} else {
+			err = d.Change(lsp.TextDocumentContentChangeEvent{Text: b.String()})
+		}
+		if err != nil {
+			messenger.AddLog("lsp: failed to send a change to ", b.AbsPath, ": ", err.Error())
+		}
+	})
+	lspLock.Lock()
+	lspDocuments[b] = d
+	lspLock.Unlock()
+
+	// The server's trigger characters are only known once it has started, after
+	// the completers were created.
+	for _, t := range tabs {
+		for _, v := range t.Views {
+			if v.Buf == b && v.Completer != nil {
+				v.Completer.Triggers = mergeTriggers(v.Completer.Triggers, d.TriggerCharacters())
+			}
+		}
+	}
+	return d
+}
+
+// openLSPDocument returns the language server document for a buffer if it's
+// already open, or nil.
+func openLSPDocument(b *Buffer) *lsp.Document {
+	lspLock.Lock()
+	defer lspLock.Unlock()
+	return lspDocuments[b]
+}
+
+// lspTriggerCharacters returns the characters which the language server of a
+// buffer would like to start completion, if the buffer is open on one.
+func lspTriggerCharacters(b *Buffer) []string {
+	if d := openLSPDocument(b); d != nil {
+		return d.TriggerCharacters()
+	}
+	return nil
+}
+
+// lspRange converts the bounds of a change into a language server range. It's
+// called after the change has been made, so the end of the range is worked out
+// from the text that was removed.
+func lspRange(b *Buffer, start, end Loc, removed string) *lsp.Range {
+	startLine := []rune(b.Line(start.Y))
+	if start.X < len(startLine) {
+		startLine = startLine[:start.X]
+	}
+	r := &lsp.Range{
+		Start: lsp.Position{Line: start.Y, Character: lsp.UTF16Len(string(startLine))},
+		End:   lsp.Position{Line: end.Y},
+	}
+	if end.Y == start.Y {
+		r.End.Character = r.Start.Character + lsp.UTF16Len(removed)
+	} else {
+		removedLines := []rune(removed)
+		lastLine := len(removedLines)
+		for lastLine > 0 && removedLines[lastLine-1] != '\n' {
+			lastLine--
+		}
+		r.End.Character = lsp.UTF16Len(string(removedLines[lastLine:]))
+	}
+	return r
+}
+
+// LSPProviderForView returns an OptionProvider which uses the language server
+// configured for the view's filetype. The provider runs in the background, so
+// the buffer is opened in the main loop, once the server has started in the
+// background. Until then, or if the server can't be used, the Generic provider
+// is used instead.
+func LSPProviderForView(v *View) OptionProvider {
+	b := v.Buf
+	limit := int(globalSettings["autocompletelimit"].(float64))
+	return func(logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) ([]optionprovider.Option, int, error) {
+		d := openLSPDocument(b)
+		if d == nil {
+			RunInMainLoop(func() { lspDocument(b) })
+			return optionprovider.NewGeneric(limit, nil)(logger, cancel, buffer, startOffset, currentOffset)
+		}
+		return d.NewProvider(limit)(logger, cancel, buffer, startOffset, currentOffset)
+	}
+}
+
+// lspCloseBuffer tells the language server that a buffer has been closed.
+func lspCloseBuffer(b *Buffer) {
+	lspLock.Lock()
+	d, ok := lspDocuments[b]
+	delete(lspDocuments, b)
+	lspLock.Unlock()
+	if ok {
+		d.Close()
+	}
+}
+
+// lspShutdown stops all of the running language servers.
+func lspShutdown() {
+	for ft, s := range lspServers {
+		if s.client != nil {
+			s.client.Shutdown()
+		}
+		delete(lspServers, ft)
+	}
+}
@@ -0,0 +1,277 @@
+// Package lsp is a minimal Language Server Protocol client which talks to a
+// server over its standard input and output.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned when the server doesn't answer a request in time.
+var ErrTimeout = errors.New("lsp: timed out waiting for the server")
+
+// ErrClosed is returned when the server has exited or the client was shut down.
+var ErrClosed = errors.New("lsp: the server is not running")
+
+// ErrCanceled is returned when a request is canceled before the server answers it.
+var ErrCanceled = errors.New("lsp: the request was canceled")
+
+// DefaultTimeout is how long a request waits for a response by default.
+const DefaultTimeout = 2 * time.Second
+
+// InitializeTimeout is how long Start waits for the server to initialize, which
+// can take a while for a large workspace.
+const InitializeTimeout = 30 * time.Second
+
+// Client is a connection to a running language server.
+type Client struct {
+	// Capabilities are the capabilities reported by the server during initialization.
+	Capabilities ServerCapabilities
+	// Timeout is how long requests wait for a response.
+	Timeout time.Duration
+
+	cmd *exec.Cmd
+	in  io.WriteCloser
+
+	// writeLock serialises writes to the server.
+	writeLock sync.Mutex
+
+	// lock protects the fields below.
+	lock    sync.Mutex
+	nextID  int
+	pending map[int]chan incoming
+	closed  bool
+	done    chan struct{}
+}
+
+// Start launches the server given by command and performs the initialization
+// handshake, using rootPath as the workspace root. It blocks until the server
+// has initialized, for up to InitializeTimeout.
+func Start(command []string, rootPath string) (*Client, error) {
+	if len(command) == 0 {
+		return nil, errors.New("lsp: no server command given")
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = rootPath
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c := NewClient(in, out)
+	c.cmd = cmd
+	c.Timeout = InitializeTimeout
+	err = c.initialize(rootPath)
+	c.Timeout = DefaultTimeout
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient creates a client which sends messages to in and reads them from out.
+// The caller is responsible for the initialization handshake; see Start.
+func NewClient(in io.WriteCloser, out io.Reader) *Client {
+	c := &Client{
+		Timeout: DefaultTimeout,
+		in:      in,
+		pending: make(map[int]chan incoming),
+		done:    make(chan struct{}),
+	}
+	go c.read(bufio.NewReader(out))
+	return c
+}
+
+func (c *Client) initialize(rootPath string) error {
+	params := map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   PathToURI(rootPath),
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"synchronization": map[string]interface{}{
+					"dynamicRegistration": false,
+				},
+				"completion": map[string]interface{}{
+					"completionItem": map[string]interface{}{
+						"snippetSupport": true,
+					},
+				},
+			},
+		},
+	}
+	var result struct {
+		Capabilities ServerCapabilities `json:"capabilities"`
+	}
+	if err := c.Call("initialize", params, &result); err != nil {
+		return err
+	}
+	c.Capabilities = result.Capabilities
+	return c.Notify("initialized", struct{}{})
+}
+
+// Call sends a request and waits for the response, which is decoded into result.
+func (c *Client) Call(method string, params, result interface{}) error {
+	return c.CallCancel(method, params, result, nil)
+}
+
+// CallCancel is like Call, but stops waiting for the response when cancel is
+// closed, and asks the server to cancel the request.
+func (c *Client) CallCancel(method string, params, result interface{}, cancel <-chan struct{}) error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return ErrClosed
+	}
+	c.nextID++
+	id := c.nextID
+	response := make(chan incoming, 1)
+	c.pending[id] = response
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+	}()
+
+	rawID := json.RawMessage(strconv.Itoa(id))
+	if err := c.send(message{JSONRPC: "2.0", ID: &rawID, Method: method, Params: params}); err != nil {
+		return err
+	}
+
+	select {
+	case m := <-response:
+		if m.Error != nil {
+			return m.Error
+		}
+		if result == nil || len(m.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(m.Result, result)
+	case <-c.done:
+		return ErrClosed
+	case <-cancel:
+		c.Notify("$/cancelRequest", map[string]interface{}{"id": id})
+		return ErrCanceled
+	case <-time.After(c.Timeout):
+		return ErrTimeout
+	}
+}
+
+// Notify sends a notification, which has no response.
+func (c *Client) Notify(method string, params interface{}) error {
+	return c.send(message{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (c *Client) send(m message) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return writeMessage(c.in, m)
+}
+
+// read dispatches messages from the server until the connection is closed.
+func (c *Client) read(r *bufio.Reader) {
+	defer c.markClosed()
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		var m incoming
+		if json.Unmarshal(body, &m) != nil {
+			continue
+		}
+
+		switch {
+		case m.Method == "" && m.ID != nil:
+			// A response to one of our requests.
+			id, err := strconv.Atoi(string(*m.ID))
+			if err != nil {
+				continue
+			}
+			c.lock.Lock()
+			response, ok := c.pending[id]
+			c.lock.Unlock()
+			if ok {
+				response <- m
+			}
+		case m.ID != nil:
+			// A request from the server. None of them are supported, but servers
+			// can wait for an answer, so reply with an empty result.
+			go c.reply(m)
+		}
+		// Notifications from the server, e.g. diagnostics, are ignored.
+	}
+}
+
+func (c *Client) reply(m incoming) {
+	result := json.RawMessage("null")
+	if m.Method == "workspace/configuration" {
+		// One (empty) configuration value is expected per requested item.
+		var params struct {
+			Items []interface{} `json:"items"`
+		}
+		json.Unmarshal(m.Params, &params)
+		values := make([]interface{}, len(params.Items))
+		result, _ = json.Marshal(values)
+	}
+	c.send(message{JSONRPC: "2.0", ID: m.ID, Result: &result})
+}
+
+func (c *Client) markClosed() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+}
+
+// Shutdown asks the server to exit and closes the connection.
+func (c *Client) Shutdown() error {
+	err := c.Call("shutdown", nil, nil)
+	c.Notify("exit", nil)
+	c.Close()
+	return err
+}
+
+// Close closes the connection and stops the server process if it's still running.
+func (c *Client) Close() {
+	c.in.Close()
+	if c.cmd == nil {
+		c.markClosed()
+		return
+	}
+	exited := make(chan struct{})
+	go func() {
+		c.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(c.Timeout):
+		c.cmd.Process.Kill()
+		<-exited
+	}
+	c.markClosed()
+}
@@ -0,0 +1,301 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+// When this environment variable is set, the test binary acts as a language server.
+const stubServerEnv = "MICRO_LSP_STUB_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(stubServerEnv) == "1" {
+		runStubServer(os.Stdin, os.Stdout)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runStubServer is a tiny language server which keeps track of open documents and
+// offers every identifier in a document as a completion.
+func runStubServer(in io.Reader, out io.Writer) {
+	r := bufio.NewReader(in)
+	documents := make(map[string][]byte)
+	identifier := regexp.MustCompile(`[a-zA-Z_]\w*`)
+
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		var m incoming
+		if json.Unmarshal(body, &m) != nil {
+			continue
+		}
+
+		var result interface{}
+		switch m.Method {
+		case "initialize":
+			result = map[string]interface{}{
+				"capabilities": map[string]interface{}{
+					"textDocumentSync": map[string]interface{}{"openClose": true, "change": SyncIncremental},
+					"completionProvider": map[string]interface{}{
+						"triggerCharacters": []string{"."},
+					},
+				},
+			}
+		case "textDocument/didOpen":
+			var params struct {
+				TextDocument TextDocumentItem `json:"textDocument"`
+			}
+			json.Unmarshal(m.Params, &params)
+			documents[params.TextDocument.URI] = []byte(params.TextDocument.Text)
+		case "textDocument/didChange":
+			var params struct {
+				TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+				ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+			}
+			json.Unmarshal(m.Params, &params)
+			text := documents[params.TextDocument.URI]
+			for _, c := range params.ContentChanges {
+				if c.Range == nil {
+					text = []byte(c.Text)
+					continue
+				}
+				start := PositionToOffset(text, c.Range.Start)
+				end := PositionToOffset(text, c.Range.End)
+				text = append(append(append([]byte{}, text[:start]...), c.Text...), text[end:]...)
+			}
+			documents[params.TextDocument.URI] = text
+		case "textDocument/completion":
+			var params struct {
+				TextDocument TextDocumentIdentifier `json:"textDocument"`
+				Position     Position               `json:"position"`
+			}
+			json.Unmarshal(m.Params, &params)
+			seen := make(map[string]bool)
+			items := []CompletionItem{}
+			for _, w := range identifier.FindAllString(string(documents[params.TextDocument.URI]), -1) {
+				if !seen[w] {
+					seen[w] = true
+					items = append(items, CompletionItem{Label: w, Detail: "identifier"})
+				}
+			}
+			sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+			result = CompletionList{Items: items}
+		case "shutdown":
+		case "exit":
+			return
+		}
+
+		if m.ID != nil {
+			raw, _ := json.Marshal(result)
+			rawResult := json.RawMessage(raw)
+			writeMessage(out, message{JSONRPC: "2.0", ID: m.ID, Result: &rawResult})
+		}
+	}
+}
+
+func startStubServer(t *testing.T) *Client {
+	os.Setenv(stubServerEnv, "1")
+	defer os.Unsetenv(stubServerEnv)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get the working directory: %v", err)
+	}
+	c, err := Start([]string{os.Args[0]}, wd)
+	if err != nil {
+		t.Fatalf("failed to start the stub server: %v", err)
+	}
+	return c
+}
+
+func TestClientInitialize(t *testing.T) {
+	c := startStubServer(t)
+	defer c.Shutdown()
+
+	if c.Capabilities.TextDocumentSync != SyncIncremental {
+		t.Errorf("expected incremental sync, got %v", c.Capabilities.TextDocumentSync)
+	}
+	if !c.Capabilities.CompletionProvider {
+		t.Error("expected the server to support completion")
+	}
+	if len(c.Capabilities.TriggerCharacters) != 1 || c.Capabilities.TriggerCharacters[0] != "." {
+		t.Errorf("expected trigger characters [.], got %v", c.Capabilities.TriggerCharacters)
+	}
+}
+
+func TestDocumentProvider(t *testing.T) {
+	c := startStubServer(t)
+	defer c.Shutdown()
+
+	text := "package main\n\nfunc hello() {}\n"
+	d, err := c.Open("file:///tmp/main.go", "go", text)
+	if err != nil {
+		t.Fatalf("failed to open the document: %v", err)
+	}
+
+	// Type "he" on a new line at the end of the document.
+	end := OffsetToPosition([]byte(text), len(text))
+	err = d.Change(TextDocumentContentChangeEvent{Range: &Range{Start: end, End: end}, Text: "he"})
+	if err != nil {
+		t.Fatalf("failed to change the document: %v", err)
+	}
+	text += "he"
+
+	options, delta, err := d.Provider(t.Logf, nil, []byte(text), len(text)-2, len(text))
+	if err != nil {
+		t.Fatalf("failed to get completions: %v", err)
+	}
+	if delta != 0 {
+		t.Errorf("expected a delta of 0, got %d", delta)
+	}
+	// The stub offers "he" too, which shows that the change reached the server.
+	if len(options) != 2 || options[0].Text() != "he" || options[1].Text() != "hello" || options[1].Hint() != "identifier" {
+		t.Errorf("expected the options 'he' and 'hello', got %v", options)
+	}
+}
+
+func TestDocumentNewProviderLimit(t *testing.T) {
+	c := startStubServer(t)
+	defer c.Shutdown()
+
+	text := "package main\n\nfunc hello() {}\n"
+	d, err := c.Open("file:///tmp/main.go", "go", text)
+	if err != nil {
+		t.Fatalf("failed to open the document: %v", err)
+	}
+
+	options, _, err := d.NewProvider(2)(t.Logf, nil, []byte(text), len(text), len(text))
+	if err != nil {
+		t.Fatalf("failed to get completions: %v", err)
+	}
+	if len(options) != 2 || options[0].Text() != "func" || options[1].Text() != "hello" {
+		t.Errorf("expected the options 'func' and 'hello', got %v", options)
+	}
+}
+
+func TestClientClosed(t *testing.T) {
+	c := startStubServer(t)
+	c.Shutdown()
+
+	if err := c.Call("textDocument/completion", nil, nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed after shutdown, got %v", err)
+	}
+}
+
+func TestClientCallCancel(t *testing.T) {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := NewClient(clientOut, clientIn)
+	defer c.Close()
+	defer serverOut.Close()
+
+	// The server never answers, so the request only ends when it's canceled.
+	received := make(chan incoming)
+	go func() {
+		r := bufio.NewReader(serverIn)
+		for {
+			body, err := readMessage(r)
+			if err != nil {
+				close(received)
+				return
+			}
+			var m incoming
+			json.Unmarshal(body, &m)
+			received <- m
+		}
+	}()
+
+	cancel := make(chan struct{})
+	errs := make(chan error)
+	go func() {
+		errs <- c.CallCancel("textDocument/completion", nil, nil, cancel)
+	}()
+
+	request := <-received
+	close(cancel)
+	if err := <-errs; err != ErrCanceled {
+		t.Errorf("expected ErrCanceled, got %v", err)
+	}
+	notification := <-received
+	var params struct {
+		ID json.RawMessage `json:"id"`
+	}
+	json.Unmarshal(notification.Params, &params)
+	if notification.Method != "$/cancelRequest" || string(params.ID) != string(*request.ID) {
+		t.Errorf("expected the request %s to be canceled, got %s %s", *request.ID, notification.Method, notification.Params)
+	}
+}
+
+func TestOffsetToPosition(t *testing.T) {
+	tests := []struct {
+		buffer   string
+		offset   int
+		expected Position
+	}{
+		{buffer: "", offset: 0, expected: Position{Line: 0, Character: 0}},
+		{buffer: "abc", offset: 2, expected: Position{Line: 0, Character: 2}},
+		{buffer: "abc\nde", offset: 5, expected: Position{Line: 1, Character: 1}},
+		{buffer: "abc\n", offset: 4, expected: Position{Line: 1, Character: 0}},
+		{buffer: "é€x", offset: 5, expected: Position{Line: 0, Character: 2}},
+		{buffer: "😀x", offset: 5, expected: Position{Line: 0, Character: 3}},
+		{buffer: "abc", offset: 10, expected: Position{Line: 0, Character: 3}},
+	}
+
+	for _, test := range tests {
+		actual := OffsetToPosition([]byte(test.buffer), test.offset)
+		if actual != test.expected {
+			t.Errorf("%q at offset %d: expected %v, got %v", test.buffer, test.offset, test.expected, actual)
+		}
+	}
+}
+
+func TestPositionToOffset(t *testing.T) {
+	tests := []struct {
+		buffer   string
+		position Position
+		expected int
+	}{
+		{buffer: "", position: Position{Line: 0, Character: 0}, expected: 0},
+		{buffer: "abc\nde", position: Position{Line: 1, Character: 1}, expected: 5},
+		{buffer: "abc\nde", position: Position{Line: 0, Character: 10}, expected: 3},
+		{buffer: "abc\nde", position: Position{Line: 5, Character: 0}, expected: 6},
+		{buffer: "😀x", position: Position{Line: 0, Character: 2}, expected: 4},
+	}
+
+	for _, test := range tests {
+		actual := PositionToOffset([]byte(test.buffer), test.position)
+		if actual != test.expected {
+			t.Errorf("%q at %v: expected %d, got %d", test.buffer, test.position, test.expected, actual)
+		}
+	}
+}
+
+func TestCompletionItemDocumentation(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected Markup
+	}{
+		{json: `{"label": "a"}`, expected: ""},
+		{json: `{"label": "a", "documentation": "plain"}`, expected: "plain"},
+		{json: `{"label": "a", "documentation": {"kind": "markdown", "value": "*marked*"}}`, expected: "*marked*"},
+	}
+
+	for _, test := range tests {
+		var item CompletionItem
+		if err := json.Unmarshal([]byte(test.json), &item); err != nil {
+			t.Errorf("%s: failed to unmarshal: %v", test.json, err)
+			continue
+		}
+		if item.Documentation != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.json, test.expected, item.Documentation)
+		}
+	}
+}
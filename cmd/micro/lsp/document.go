@@ -0,0 +1,171 @@
+package lsp
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/zyedidia/micro/cmd/micro/optionprovider"
+	"github.com/zyedidia/micro/cmd/micro/snippet"
+)
+
+// maxCompletionItems is the default number of completion items offered by Provider.
+const maxCompletionItems = 10
+
+// Document is a text document which has been opened on the server.
+type Document struct {
+	URI     string
+	client  *Client
+	version int
+}
+
+// Open tells the server that the client is now managing the document at uri.
+func (c *Client) Open(uri, languageID, text string) (*Document, error) {
+	d := &Document{URI: uri, client: c, version: 1}
+	err := c.Notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": TextDocumentItem{
+			URI:        uri,
+			LanguageID: languageID,
+			Version:    d.version,
+			Text:       text,
+		},
+	})
+	return d, err
+}
+
+// SyncKind returns how the server would like to receive changes to the document.
+func (d *Document) SyncKind() int {
+	return d.client.Capabilities.TextDocumentSync
+}
+
+// TriggerCharacters returns the characters which the server would like to start completion.
+func (d *Document) TriggerCharacters() []string {
+	return d.client.Capabilities.TriggerCharacters
+}
+
+// Change sends changes made to the document to the server.
+func (d *Document) Change(changes ...TextDocumentContentChangeEvent) error {
+	d.version++
+	return d.client.Notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   VersionedTextDocumentIdentifier{URI: d.URI, Version: d.version},
+		"contentChanges": changes,
+	})
+}
+
+// Close tells the server that the document is no longer being edited.
+func (d *Document) Close() error {
+	return d.client.Notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": TextDocumentIdentifier{URI: d.URI},
+	})
+}
+
+// Completion requests the completion items available at pos. The request is
+// canceled if cancel is closed before the server answers it.
+func (d *Document) Completion(pos Position, cancel <-chan struct{}) ([]CompletionItem, error) {
+	var result json.RawMessage
+	err := d.client.CallCancel("textDocument/completion", map[string]interface{}{
+		"textDocument": TextDocumentIdentifier{URI: d.URI},
+		"position":     pos,
+	}, &result, cancel)
+	if err != nil || len(result) == 0 {
+		return nil, err
+	}
+
+	// The result is either a list of items, a CompletionList or null.
+	var items []CompletionItem
+	if json.Unmarshal(result, &items) == nil {
+		return items, nil
+	}
+	var list CompletionList
+	err = json.Unmarshal(result, &list)
+	return list.Items, err
+}
+
+// Provider is an OptionProvider which offers the server's completions. The server must
+// already have been sent the contents of buffer. At most 10 options are returned, see
+// NewProvider to change this.
+func (d *Document) Provider(logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, startOffsetDelta int, err error) {
+	return d.provider(maxCompletionItems, logger, cancel, buffer, startOffset, currentOffset)
+}
+
+// NewProvider creates a Provider which returns at most limit options.
+func (d *Document) NewProvider(limit int) func(logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, startOffsetDelta int, err error) {
+	return func(logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, startOffsetDelta int, err error) {
+		return d.provider(limit, logger, cancel, buffer, startOffset, currentOffset)
+	}
+}
+
+func (d *Document) provider(limit int, logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []optionprovider.Option, startOffsetDelta int, err error) {
+	pos := OffsetToPosition(buffer, currentOffset)
+	logger("lsp.Provider: requesting completion for %s at %d:%d", d.URI, pos.Line, pos.Character)
+	items, err := d.Completion(pos, cancel)
+	if err != nil {
+		return
+	}
+
+	// Use the start of the server's edit range as the start of the text to replace.
+	for _, item := range items {
+		if item.TextEdit != nil {
+			startOffsetDelta = PositionToOffset(buffer, item.TextEdit.Range.Start) - startOffset
+			break
+		}
+	}
+	prefix := ""
+	if from := startOffset + startOffsetDelta; from >= 0 && from <= currentOffset {
+		prefix = strings.ToLower(string(buffer[from:currentOffset]))
+	}
+
+	for _, item := range items {
+		filter := item.FilterText
+		if filter == "" {
+			filter = item.Label
+		}
+		if !strings.HasPrefix(strings.ToLower(filter), prefix) {
+			continue
+		}
+		o := optionprovider.New(itemText(item), item.Detail)
+		if item.InsertTextFormat == SnippetFormat {
+			// Offer the plain text too, for completers which can't insert snippets.
+			o.S = o.T
+			if s, err := snippet.Parse(o.S); err == nil {
+				o.T = s.Text
+			}
+		}
+		o.K = itemKind(item.Kind)
+		o.D = string(item.Documentation)
+		options = append(options, o)
+		if len(options) == limit {
+			break
+		}
+	}
+	return
+}
+
+// itemKind converts the kind of a completion item to the kind of an option.
+func itemKind(kind int) string {
+	switch kind {
+	case KindMethod, KindFunction, KindConstructor:
+		return optionprovider.KindFunc
+	case KindField, KindVariable, KindProperty:
+		return optionprovider.KindVar
+	case KindClass, KindInterface, KindEnum, KindStruct, KindTypeParameter:
+		return optionprovider.KindType
+	case KindConstant, KindEnumMember:
+		return optionprovider.KindConst
+	case KindModule:
+		return optionprovider.KindPackage
+	case KindKeyword:
+		return optionprovider.KindKeyword
+	}
+	return ""
+}
+
+// itemText returns the text which should be inserted for item.
+func itemText(item CompletionItem) string {
+	switch {
+	case item.TextEdit != nil:
+		return item.TextEdit.NewText
+	case item.InsertText != "":
+		return item.InsertText
+	}
+	return item.Label
+}
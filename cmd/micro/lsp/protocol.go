@@ -0,0 +1,226 @@
+package lsp
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+)
+
+// Position in a text document expressed as a zero-based line and a zero-based
+// character offset, counted in UTF-16 code units as the protocol requires.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range in a text document expressed as start and end positions.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// TextDocumentItem is an item to transfer a text document from the client to the server.
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+// TextDocumentIdentifier identifies a text document using its URI.
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+// VersionedTextDocumentIdentifier identifies a specific version of a text document.
+type VersionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+}
+
+// TextDocumentContentChangeEvent describes a change to a text document. If Range is
+// nil, Text is the full content of the document.
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+// TextEdit is a textual edit applicable to a text document.
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// CompletionItem is a single completion returned by the server.
+type CompletionItem struct {
+	Label            string    `json:"label"`
+	Kind             int       `json:"kind,omitempty"`
+	Detail           string    `json:"detail,omitempty"`
+	Documentation    Markup    `json:"documentation,omitempty"`
+	FilterText       string    `json:"filterText,omitempty"`
+	InsertText       string    `json:"insertText,omitempty"`
+	InsertTextFormat int       `json:"insertTextFormat,omitempty"`
+	TextEdit         *TextEdit `json:"textEdit,omitempty"`
+}
+
+// Formats of CompletionItem.InsertText and TextEdit.NewText.
+const (
+	PlainTextFormat = 1
+	SnippetFormat   = 2
+)
+
+// Completion item kinds, see CompletionItem.Kind. Only the kinds which the client
+// tells apart are listed.
+const (
+	KindMethod        = 2
+	KindFunction      = 3
+	KindConstructor   = 4
+	KindField         = 5
+	KindVariable      = 6
+	KindClass         = 7
+	KindInterface     = 8
+	KindModule        = 9
+	KindProperty      = 10
+	KindEnum          = 13
+	KindKeyword       = 14
+	KindEnumMember    = 20
+	KindConstant      = 21
+	KindStruct        = 22
+	KindTypeParameter = 25
+)
+
+// Markup is documentation text, which the server sends either as a string or as
+// MarkupContent with a kind and a value. Only the value is kept.
+type Markup string
+
+// UnmarshalJSON decodes either form of the documentation.
+func (m *Markup) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*m = Markup(s)
+		return nil
+	}
+	var content struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(data, &content); err != nil {
+		return err
+	}
+	*m = Markup(content.Value)
+	return nil
+}
+
+// CompletionList is a collection of completion items.
+type CompletionList struct {
+	IsIncomplete bool             `json:"isIncomplete"`
+	Items        []CompletionItem `json:"items"`
+}
+
+// Text document synchronisation kinds, see ServerCapabilities.TextDocumentSync.
+const (
+	SyncNone        = 0
+	SyncFull        = 1
+	SyncIncremental = 2
+)
+
+// ServerCapabilities holds the parts of the server's capabilities that the client uses.
+type ServerCapabilities struct {
+	// TextDocumentSync is how the server wants document changes to be sent.
+	TextDocumentSync int
+	// CompletionProvider is true when the server supports textDocument/completion.
+	CompletionProvider bool
+	// TriggerCharacters are the characters which the server would like to trigger completion.
+	TriggerCharacters []string
+}
+
+// UnmarshalJSON decodes the capabilities, which allow several shapes for the same field.
+func (sc *ServerCapabilities) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		TextDocumentSync   json.RawMessage `json:"textDocumentSync"`
+		CompletionProvider *struct {
+			TriggerCharacters []string `json:"triggerCharacters"`
+		} `json:"completionProvider"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	// textDocumentSync is either a number or an object with a change field.
+	if len(raw.TextDocumentSync) > 0 {
+		var kind int
+		if err := json.Unmarshal(raw.TextDocumentSync, &kind); err == nil {
+			sc.TextDocumentSync = kind
+		} else {
+			var options struct {
+				Change int `json:"change"`
+			}
+			if err := json.Unmarshal(raw.TextDocumentSync, &options); err == nil {
+				sc.TextDocumentSync = options.Change
+			}
+		}
+	}
+
+	if raw.CompletionProvider != nil {
+		sc.CompletionProvider = true
+		sc.TriggerCharacters = raw.CompletionProvider.TriggerCharacters
+	}
+	return nil
+}
+
+// PathToURI converts an absolute file path into a file:// URI.
+func PathToURI(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
+
+// UTF16Len returns the number of UTF-16 code units needed to encode s.
+func UTF16Len(s string) (n int) {
+	for _, r := range s {
+		n += utf16Width(r)
+	}
+	return
+}
+
+func utf16Width(r rune) int {
+	if r >= 0x10000 {
+		// Runes outside the basic multilingual plane are encoded as a surrogate pair.
+		return 2
+	}
+	return 1
+}
+
+// OffsetToPosition converts a byte offset in buffer into a Position.
+func OffsetToPosition(buffer []byte, offset int) (p Position) {
+	if offset > len(buffer) {
+		offset = len(buffer)
+	}
+	for _, r := range string(buffer[:offset]) {
+		if r == '\n' {
+			p.Line++
+			p.Character = 0
+			continue
+		}
+		p.Character += utf16Width(r)
+	}
+	return
+}
+
+// PositionToOffset converts a Position into a byte offset in buffer. Positions past
+// the end of a line are clamped to the end of that line.
+func PositionToOffset(buffer []byte, p Position) int {
+	line, character := 0, 0
+	for i, r := range string(buffer) {
+		if line == p.Line && (character >= p.Character || r == '\n') {
+			return i
+		}
+		if r == '\n' {
+			line++
+			character = 0
+			continue
+		}
+		if line == p.Line {
+			character += utf16Width(r)
+		}
+	}
+	return len(buffer)
+}
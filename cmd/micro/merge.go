@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+
+	dmp "github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Markers around the two versions of a part of the text which was changed in
+// both of them
+const (
+	conflictStart  = "<<<<<<< buffer\n"
+	conflictMiddle = "=======\n"
+	conflictEnd    = ">>>>>>> file\n"
+)
+
+// A hunk replaces the lines from start to end (exclusive) of a text with
+// other lines
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// splitLines splits text after each newline
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineHunks returns the changes to the lines of base which make text
+func lineHunks(base, text string) []hunk {
+	differ := dmp.New()
+	a, b, lines := differ.DiffLinesToChars(base, text)
+	diffs := differ.DiffCharsToLines(differ.DiffMain(a, b, false), lines)
+
+	var hunks []hunk
+	pos, changing := 0, false
+	for _, d := range diffs {
+		n := splitLines(d.Text)
+		if d.Type == dmp.DiffEqual {
+			pos += len(n)
+			changing = false
+			continue
+		}
+		if !changing {
+			hunks = append(hunks, hunk{start: pos, end: pos})
+			changing = true
+		}
+		h := &hunks[len(hunks)-1]
+		if d.Type == dmp.DiffDelete {
+			h.end += len(n)
+			pos += len(n)
+		} else {
+			h.lines = append(h.lines, n...)
+		}
+	}
+	return hunks
+}
+
+// applyHunks returns the lines from start to end of base with the hunks in
+// them applied
+func applyHunks(base []string, hunks []hunk, start, end int) []string {
+	var lines []string
+	for _, h := range hunks {
+		lines = append(lines, base[start:h.start]...)
+		lines = append(lines, h.lines...)
+		start = h.end
+	}
+	return append(lines, base[start:end]...)
+}
+
+// merge3 merges the changes which were made to base in ours and in theirs, and
+// returns the merged text and the number of places where they both changed the
+// same lines, which are marked as conflicts with both versions of the lines
+func merge3(base, ours, theirs string) (string, int) {
+	// Every line has to end with a newline, or the last line would differ from
+	// the same line with another one added after it
+	base, ours, theirs = base+"\n", ours+"\n", theirs+"\n"
+	lines := splitLines(base)
+	oh, th := lineHunks(base, ours), lineHunks(base, theirs)
+
+	var merged []string
+	conflicts := 0
+	pos, i, j := 0, 0, 0
+	for i < len(oh) || j < len(th) {
+		var start int
+		if j == len(th) || (i < len(oh) && oh[i].start <= th[j].start) {
+			start = oh[i].start
+		} else {
+			start = th[j].start
+		}
+
+		// Changes which overlap or touch are merged into one, until neither
+		// version has another change which starts before its end
+		end := start
+		i0, j0 := i, j
+		for grew := true; grew; {
+			grew = false
+			for ; i < len(oh) && oh[i].start <= end; i++ {
+				end, grew = Max(end, oh[i].end), true
+			}
+			for ; j < len(th) && th[j].start <= end; j++ {
+				end, grew = Max(end, th[j].end), true
+			}
+		}
+
+		merged = append(merged, lines[pos:start]...)
+		a := applyHunks(lines, oh[i0:i], start, end)
+		b := applyHunks(lines, th[j0:j], start, end)
+		switch {
+		case j == j0:
+			merged = append(merged, a...)
+		case i == i0 || strings.Join(a, "") == strings.Join(b, ""):
+			merged = append(merged, b...)
+		default:
+			merged = append(merged, conflictStart)
+			merged = append(merged, a...)
+			merged = append(merged, conflictMiddle)
+			merged = append(merged, b...)
+			merged = append(merged, conflictEnd)
+			conflicts++
+		}
+		pos = end
+	}
+	merged = append(merged, lines[pos:]...)
+	return strings.TrimSuffix(strings.Join(merged, ""), "\n"), conflicts
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		expected           string
+		conflicts          int
+	}{
+		{"unchanged", "a\nb\nc", "a\nb\nc", "a\nb\nc", "a\nb\nc", 0},
+		{"ours", "a\nb\nc", "a\nB\nc", "a\nb\nc", "a\nB\nc", 0},
+		{"theirs", "a\nb\nc", "a\nb\nc", "a\nb\nC", "a\nb\nC", 0},
+		{"both", "a\nb\nc\nd\ne", "A\nb\nc\nd\ne", "a\nb\nc\nd\nE", "A\nb\nc\nd\nE", 0},
+		{"same change", "a\nb\nc", "a\nX\nc", "a\nX\nc", "a\nX\nc", 0},
+		{"appended", "a\nb", "a\nb\nc", "A\nb", "A\nb\nc", 0},
+		{"deleted", "a\nb\nc\nd\ne", "a\nc\nd\ne", "a\nb\nc\nd", "a\nc\nd", 0},
+		{"conflict", "a\nb\nc", "a\nX\nc", "a\nY\nc", "a\n<<<<<<< buffer\nX\n=======\nY\n>>>>>>> file\nc", 1},
+		{"conflict at end", "a\nb", "a\nX", "a\nY", "a\n<<<<<<< buffer\nX\n=======\nY\n>>>>>>> file", 1},
+		{"empty base", "", "x", "", "x", 0},
+	}
+
+	for _, test := range tests {
+		merged, conflicts := merge3(test.base, test.ours, test.theirs)
+		if merged != test.expected || conflicts != test.conflicts {
+			t.Errorf("%s: expected %q with %d conflicts, got %q with %d", test.name, test.expected, test.conflicts, merged, conflicts)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/mattn/go-runewidth"
 	"github.com/zyedidia/clipboard"
@@ -38,6 +39,33 @@ func TermMessage(msg ...interface{}) {
 	}
 }
 
+// TermPrompt asks the user a question in the terminal, like TermMessage, and
+// waits for them to answer with one of the given letters. It returns 0 if
+// there's no more input.
+func TermPrompt(prompt string, responses ...rune) rune {
+	screenWasNil := screen == nil
+	if !screenWasNil {
+		screen.Fini()
+		screen = nil
+		defer InitScreen()
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Print(prompt, " ")
+		answer, err := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		for _, r := range responses {
+			if answer == string(r) {
+				return r
+			}
+		}
+		if err != nil {
+			return 0
+		}
+	}
+}
+
 // TermError sends an error to the user in the terminal. Like TermMessage except formatted
 // as an error
 func TermError(filename string, lineNum int, err string) {
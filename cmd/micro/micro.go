@@ -60,9 +60,13 @@ var (
 	// Channel of jobs running in the background
 	jobs chan JobFunction
 
+	// Channel of functions from other goroutines which need to run in the main loop
+	callbacks chan func()
+
 	// Event channel
 	events   chan tcell.Event
 	autosave chan bool
+	backup   chan bool
 
 	// Channels for the terminal emulator
 	updateterm chan bool
@@ -106,13 +110,15 @@ func LoadInput() []*Buffer {
 				continue
 			}
 
-			buf, err := NewBufferFromFile(args[i])
-			if err != nil {
-				TermMessage(err)
-				continue
+			for _, path := range expandPaths(args[i : i+1]) {
+				buf, err := NewBufferFromFile(path)
+				if err != nil {
+					TermMessage(err)
+					continue
+				}
+				// If the file didn't exist, input will be empty, and we'll open an empty buffer
+				buffers = append(buffers, buf)
 			}
-			// If the file didn't exist, input will be empty, and we'll open an empty buffer
-			buffers = append(buffers, buf)
 		}
 	} else if !isatty.IsTerminal(os.Stdin.Fd()) {
 		// Option 2
@@ -286,6 +292,10 @@ var flagStartPos = flag.String("startpos", "", "LINE,COL to start the cursor at
 var flagConfigDir = flag.String("config-dir", "", "Specify a custom location for the configuration directory")
 var flagOptions = flag.Bool("options", false, "Show all option help")
 
+// Used by SaveAsWithSudo, which runs micro as another user to save a file
+var flagSaveFile = flag.String("save-file", "", "Save standard input to the given file")
+var flagSaveBackup = flag.Bool("save-backup", false, "Keep a backup of the file saved with -save-file")
+
 func main() {
 	flag.Usage = func() {
 		fmt.Println("Usage: micro [OPTIONS] [FILE]...")
@@ -323,6 +333,14 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *flagSaveFile != "" {
+		if err := saveStdin(*flagSaveFile, *flagSaveBackup); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if *flagOptions {
 		// If -options was passed
 		for k, v := range DefaultGlobalSettings() {
@@ -372,6 +390,7 @@ func main() {
 	// This is used for sending the user messages in the bottom of the editor
 	messenger = new(Messenger)
 	messenger.LoadHistory()
+	InitWatcher()
 
 	// Now we load the input
 	buffers := LoadInput()
@@ -380,7 +399,22 @@ func main() {
 		os.Exit(1)
 	}
 
-	for _, buf := range buffers {
+	// The multiopen option is usually given on the command line, but options
+	// from there are only set once the buffers are open
+	multiopen := globalSettings["multiopen"]
+	if *optionFlags["multiopen"] != "" {
+		multiopen = *optionFlags["multiopen"]
+	}
+
+	for i, buf := range buffers {
+		if i > 0 && multiopen == "vsplit" {
+			CurView().VSplit(buf)
+			continue
+		} else if i > 0 && multiopen == "hsplit" {
+			CurView().HSplit(buf)
+			continue
+		}
+
 		// For each buffer we create a new tab and place the view in that tab
 		tab := NewTabFromView(NewView(buf))
 		tab.SetNum(len(tabs))
@@ -427,6 +461,7 @@ func main() {
 	L.SetGlobal("RunTermEmulator", luar.New(L, RunTermEmulator))
 	L.SetGlobal("GetLeadingWhitespace", luar.New(L, GetLeadingWhitespace))
 	L.SetGlobal("MakeCompletion", luar.New(L, MakeCompletion))
+	L.SetGlobal("RegisterCompleter", luar.New(L, RegisterCompleter))
 	L.SetGlobal("NewBuffer", luar.New(L, NewBufferFromString))
 	L.SetGlobal("NewBufferFromFile", luar.New(L, NewBufferFromFile))
 	L.SetGlobal("RuneStr", luar.New(L, func(r rune) string {
@@ -460,8 +495,10 @@ func main() {
 	L.SetGlobal("import", luar.New(L, Import))
 
 	jobs = make(chan JobFunction, 100)
+	callbacks = make(chan func(), 100)
 	events = make(chan tcell.Event, 100)
 	autosave = make(chan bool)
+	backup = make(chan bool)
 	updateterm = make(chan bool)
 	closeterm = make(chan int)
 
@@ -471,6 +508,8 @@ func main() {
 		for _, v := range t.Views {
 			GlobalPluginCall("onViewOpen", v)
 			GlobalPluginCall("onBufferOpen", v.Buf)
+			// Plugins may have registered completion providers
+			ReloadCompleter(v)
 		}
 	}
 
@@ -495,6 +534,15 @@ func main() {
 		}
 	}()
 
+	go func() {
+		for {
+			time.Sleep(backupTime * time.Second)
+			backup <- true
+		}
+	}()
+
+	go WatchFiles()
+
 	for {
 		// Display everything
 		RedrawAll()
@@ -507,10 +555,17 @@ func main() {
 			// If a new job has finished while running in the background we should execute the callback
 			f.function(f.output, f.args...)
 			continue
+		case f := <-callbacks:
+			// A background task, e.g. looking up completions, has results to apply
+			f()
+			continue
 		case <-autosave:
 			if CurView().Buf.Path != "" {
 				CurView().Save(true)
 			}
+		case <-backup:
+			BackupAll()
+			continue
 		case <-updateterm:
 			continue
 		case vnum := <-closeterm:
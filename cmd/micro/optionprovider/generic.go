@@ -5,9 +5,11 @@ import (
 	"sort"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
-var word = regexp.MustCompile(`[a-zA-Z]+\d*`)
+// word matches names, which can contain letters and digits from any script.
+var word = regexp.MustCompile(`[\pL_][\pL\p{Nd}_]*`)
 var stopList = map[string]interface{}{
 	"for":   false,
 	"if":    false,
@@ -20,43 +22,295 @@ var stopList = map[string]interface{}{
 	"to":    false,
 	"and":   false,
 }
+
+// qualified matches dotted names such as fmt.Println, which are offered as a whole when the
+// text being completed isn't already part of one.
+var qualified = regexp.MustCompile(`[\pL_][\pL\p{Nd}_]*(?:\.[\pL_][\pL\p{Nd}_]*)+`)
+
+// maxSuggestions is the default number of options returned by Generic.
 var maxSuggestions = 10
 
 // Generic is an OptionProvider which provides options to the autocompletion system based on the
 // words in the current buffer. It returns a delta of the start index if the start position needs
-// to change.
-func Generic(logger func(s string, values ...interface{}), buffer []byte, startOffset, currentOffset int) (options []Option, startDelta int, err error) {
+// to change. At most 10 options are returned, see NewGeneric to change this.
+func Generic(logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []Option, startDelta int, err error) {
+	return generic(maxSuggestions, nil, buffer, startOffset, currentOffset)
+}
+
+// NewGeneric creates a Generic provider which returns at most limit options. If index is not nil, the
+// words are taken from it instead of the current buffer, which must be one of the documents in it.
+func NewGeneric(limit int, index *WordIndex) func(logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []Option, startDelta int, err error) {
+	return func(logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []Option, startDelta int, err error) {
+		return generic(limit, index, buffer, startOffset, currentOffset)
+	}
+}
+
+func generic(limit int, index *WordIndex, buffer []byte, startOffset, currentOffset int) (options []Option, startDelta int, err error) {
 	s := string(buffer)
 
 	// Find the best matches.
 	prefix := prefix(lastCharacters(s, currentOffset, 10))
 	startDelta = currentOffset - startOffset - len(prefix)
 
-	// Calculate common words.
-	words := word.FindAllString(s, -1)
+	// Names like fmt.Println can be completed from fPl, but not after "fmt." has been typed.
+	prefixStart := currentOffset - len(prefix)
+	withQualified := len(prefix) > 0 && (prefixStart == 0 || s[prefixStart-1] != '.')
 
 	// Count words, but remove common syntax and the prefix from the autocomplete list.
-	counts := wordCounts(words, map[string]interface{}{prefix: false})
-	orderedWords := orderByFrequencyDesc(counts)
-
-	if len(prefix) > 0 {
-		// We have a prefix, so write out prefix matches.
-		for i := 0; i < len(orderedWords) && len(options) < maxSuggestions; i++ {
-			if strings.HasPrefix(orderedWords[i], prefix) {
-				options = append(options, New(orderedWords[i], ""))
-			}
+	var candidates map[string]*candidate
+	if index != nil {
+		candidates = indexCandidates(index, s, currentOffset, prefix, withQualified)
+	} else {
+		candidates = findCandidates(word, s, currentOffset, prefix, nil)
+		if withQualified {
+			findCandidates(qualified, s, currentOffset, prefix, candidates)
+		}
+	}
+
+	if len(prefix) == 0 {
+		// Without a prefix, write out all words, most common first.
+		counts := make(map[string]int)
+		for w, c := range candidates {
+			counts[w] = c.count
+		}
+		orderedWords := orderByFrequencyDesc(counts)
+		for i := 0; i < len(orderedWords) && len(options) < limit; i++ {
+			options = append(options, New(orderedWords[i], ""))
 		}
 		return
 	}
 
-	// Write out all matches.
-	for i := 0; i < len(orderedWords) && len(options) < maxSuggestions; i++ {
-		options = append(options, New(orderedWords[i], ""))
+	// Keep the words which match the prefix, best first.
+	var matches []*candidate
+	for _, c := range candidates {
+		if score, ok := fuzzyScore(c.word, prefix); ok {
+			c.score = score + frequencyScore(c.count) + proximityScore(c.distance)
+			matches = append(matches, c)
+		}
 	}
+	sort.Sort(byScore(matches))
+	for i := 0; i < len(matches) && len(options) < limit; i++ {
+		options = append(options, New(matches[i].word, ""))
+	}
+	return
+}
+
+// A candidate is a word which could complete the prefix.
+type candidate struct {
+	word  string
+	count int
+	// distance is the number of bytes between the cursor and the nearest occurrence of the word.
+	distance int
+	score    int
+}
 
+// findCandidates finds the words matched by re, and works out how often they occur and how near to
+// the cursor they are. The words are added to candidates, or to a new map if it's nil.
+func findCandidates(re *regexp.Regexp, s string, cursor int, prefix string, candidates map[string]*candidate) map[string]*candidate {
+	if candidates == nil {
+		candidates = make(map[string]*candidate)
+	}
+	for _, loc := range re.FindAllStringIndex(s, -1) {
+		w := s[loc[0]:loc[1]]
+		if isStopWord(w, prefix) {
+			continue
+		}
+		distance := distanceToCursor(loc, cursor)
+		c, ok := candidates[w]
+		if !ok {
+			c = &candidate{word: w, distance: distance}
+			candidates[w] = c
+		}
+		c.count++
+		if distance < c.distance {
+			c.distance = distance
+		}
+	}
+	return candidates
+}
+
+// proximityWindow is how far either side of the cursor the current buffer is searched for words
+// when an index is used. Words further away are treated as being this far away.
+const proximityWindow = 4096
+
+// indexCandidates returns the words in the index, and works out how near to the cursor they are from
+// the part of the buffer around it.
+func indexCandidates(index *WordIndex, s string, cursor int, prefix string, withQualified bool) map[string]*candidate {
+	candidates := make(map[string]*candidate)
+	index.Words(func(w string, count int) {
+		if isStopWord(w, prefix) || (!withQualified && strings.Contains(w, ".")) {
+			return
+		}
+		candidates[w] = &candidate{word: w, count: count, distance: proximityWindow}
+	})
+
+	from, to := cursor-proximityWindow, cursor+proximityWindow
+	if from < 0 {
+		from = 0
+	}
+	if to > len(s) {
+		to = len(s)
+	}
+	for _, re := range [...]*regexp.Regexp{word, qualified} {
+		for _, loc := range re.FindAllStringIndex(s[from:to], -1) {
+			if c, ok := candidates[s[from+loc[0]:from+loc[1]]]; ok {
+				loc[0], loc[1] = loc[0]+from, loc[1]+from
+				if distance := distanceToCursor(loc, cursor); distance < c.distance {
+					c.distance = distance
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+func isStopWord(w, prefix string) bool {
+	return w == prefix || inAnyStopList(w, stopList)
+}
+
+func distanceToCursor(loc []int, cursor int) int {
+	if loc[1] < cursor {
+		return cursor - loc[1]
+	} else if loc[0] > cursor {
+		return loc[0] - cursor
+	}
+	return 0
+}
+
+// Weights used to rank fuzzy matches.
+const (
+	// Matching the first character, or the start of a part of a camelCase or snake_case name.
+	boundaryBonus = 8
+	// Matching the character after the previous match.
+	consecutiveBonus = 5
+	// Matching the whole prefix at the start of the word, with the same case.
+	prefixBonus = 20
+	// Words which are near to the cursor get up to this many points.
+	proximityBonus = 10
+	// The distance in bytes at which the proximity bonus halves.
+	proximityScale = 1000
+	// Frequent words get up to this many points.
+	maxFrequencyBonus = 10
+)
+
+// fuzzyScore works out how well pattern matches word, if the characters of pattern appear in word
+// in the same order. Lowercase characters in the pattern match either case, uppercase characters only
+// match uppercase. Higher scores are better matches.
+func fuzzyScore(word, pattern string) (score int, ok bool) {
+	w, p := []rune(word), []rune(pattern)
+	if len(p) == 0 {
+		return 0, true
+	}
+	if len(p) > len(w) {
+		return 0, false
+	}
+
+	// best[i] is the best score for matching the pattern so far with its last character at w[i].
+	const none = -1 << 30
+	best := make([]int, len(w))
+	next := make([]int, len(w))
+	for i := range w {
+		best[i] = none
+		if runeMatches(w[i], p[0]) {
+			// Skipping characters at the start of the word costs a point each.
+			best[i] = 1 + boundaryScore(w, i) - i
+		}
+	}
+	for j := 1; j < len(p); j++ {
+		// gap holds the best score of an earlier match, plus its index, so that
+		// gaps between matches cost a point per skipped character.
+		gap := none
+		for i := range w {
+			next[i] = none
+			if i >= 2 && best[i-2] != none && best[i-2]+i-2 > gap {
+				gap = best[i-2] + i - 2
+			}
+			if !runeMatches(w[i], p[j]) {
+				continue
+			}
+			candidate := none
+			if i >= 1 && best[i-1] != none {
+				candidate = best[i-1] + consecutiveBonus
+			}
+			if gap != none && gap-i+1 > candidate {
+				candidate = gap - i + 1
+			}
+			if candidate != none {
+				next[i] = candidate + 1 + boundaryScore(w, i)
+			}
+		}
+		best, next = next, best
+	}
+
+	score = none
+	for _, b := range best {
+		if b > score {
+			score = b
+		}
+	}
+	if score == none {
+		return 0, false
+	}
+	if strings.HasPrefix(word, pattern) {
+		score += prefixBonus
+	}
+	// Prefer shorter words when the matches are otherwise equal.
+	score -= (len(w) - len(p)) / 4
+	return score, true
+}
+
+func runeMatches(w, p rune) bool {
+	if unicode.IsUpper(p) {
+		return w == p
+	}
+	return unicode.ToLower(w) == p
+}
+
+// boundaryScore returns the bonus for matching w[i], which is given at the start of each part of a
+// name, e.g. the P and l of fmt.Println, or the n and c of new_count.
+func boundaryScore(w []rune, i int) int {
+	if i == 0 {
+		return boundaryBonus
+	}
+	prev, cur := w[i-1], w[i]
+	switch {
+	case !unicode.IsLetter(prev) && !unicode.IsDigit(prev):
+		return boundaryBonus
+	case unicode.IsLower(prev) && unicode.IsUpper(cur):
+		return boundaryBonus
+	case !unicode.IsDigit(prev) && unicode.IsDigit(cur):
+		return boundaryBonus
+	}
+	return 0
+}
+
+func frequencyScore(count int) (score int) {
+	for count > 1 && score < maxFrequencyBonus {
+		count /= 2
+		score += 2
+	}
 	return
 }
 
+func proximityScore(distance int) int {
+	return proximityBonus * proximityScale / (proximityScale + distance)
+}
+
+// byScore sorts candidates by score, then by frequency, then alphabetically.
+type byScore []*candidate
+
+func (s byScore) Len() int      { return len(s) }
+func (s byScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byScore) Less(i, j int) bool {
+	if s[i].score != s[j].score {
+		return s[i].score > s[j].score
+	}
+	if s[i].count != s[j].count {
+		return s[i].count > s[j].count
+	}
+	return s[i].word < s[j].word
+}
+
 func lastCharacters(s string, end, charactersBefore int) string {
 	if end == 0 {
 		return ""
@@ -65,6 +319,10 @@ func lastCharacters(s string, end, charactersBefore int) string {
 	if start < 0 {
 		return s[0:end]
 	}
+	// Don't start partway through a character.
+	for start > 0 && !utf8.RuneStart(s[start]) {
+		start--
+	}
 	return s[start:end]
 }
 
@@ -72,7 +330,7 @@ func prefix(s string) string {
 	text := []*unicode.RangeTable{unicode.Letter, unicode.Digit}
 	last := 0
 	for i, r := range s {
-		if !unicode.IsOneOf(text, r) {
+		if !unicode.IsOneOf(text, r) && r != '_' {
 			last = i + 1
 		}
 	}
@@ -2,6 +2,7 @@ package optionprovider
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -63,6 +64,13 @@ func TestGeneric(t *testing.T) {
 			to:       `fmt.Println("hello") fmt.P`,
 			expected: []Option{New("Println", "")},
 		},
+		{
+			name:     "words can be written in any script",
+			text:     "größe grün gr",
+			from:     "größe grün ",
+			to:       "größe grün gr",
+			expected: []Option{New("größe", ""), New("grün", "")},
+		},
 		{
 			name:          "go back further than the start position",
 			text:          `testing`,
@@ -74,7 +82,7 @@ func TestGeneric(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		options, delta, err := Generic(t.Logf, []byte(test.text), len(test.from), len(test.to))
+		options, delta, err := Generic(t.Logf, nil, []byte(test.text), len(test.from), len(test.to))
 		if err != nil {
 			t.Fatalf("%s: generic complete failed with error %v", test.name, err)
 			continue
@@ -88,6 +96,121 @@ func TestGeneric(t *testing.T) {
 	}
 }
 
+func TestGenericFuzzy(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		to       string
+		expected []Option
+	}{
+		{
+			name:     "qualified names are matched by a subsequence of their characters",
+			text:     `fmt.Println("hello") fPl`,
+			to:       `fmt.Println("hello") fPl`,
+			expected: []Option{New("fmt.Println", "")},
+		},
+		{
+			name:     "qualified names aren't offered after a dot",
+			text:     `fmt.Println("hello") fmt.Pl`,
+			to:       `fmt.Println("hello") fmt.Pl`,
+			expected: []Option{New("Println", "")},
+		},
+		{
+			name:     "uppercase characters only match uppercase",
+			text:     "newCount nucleus nC",
+			to:       "newCount nucleus nC",
+			expected: []Option{New("newCount", "")},
+		},
+		{
+			name:     "matching the start of each part of the name beats a scattered match",
+			text:     "nodeCount new_count inaccurate nc",
+			to:       "nodeCount new_count inaccurate nc",
+			expected: []Option{New("new_count", ""), New("nodeCount", ""), New("inaccurate", "")},
+		},
+		{
+			name:     "prefix matches come before other matches",
+			text:     "gosub_string go_string gos",
+			to:       "gosub_string go_string gos",
+			expected: []Option{New("gosub_string", ""), New("go_string", "")},
+		},
+		{
+			name:     "words nearer to the cursor come first",
+			text:     "alpha " + strings.Repeat("x ", 2000) + "alpine al",
+			to:       "alpha " + strings.Repeat("x ", 2000) + "alpine al",
+			expected: []Option{New("alpine", ""), New("alpha", "")},
+		},
+		{
+			name:     "frequent words come first",
+			text:     "alpine alpha alpha alpha al",
+			to:       "alpine alpha alpha alpha al",
+			expected: []Option{New("alpha", ""), New("alpine", "")},
+		},
+	}
+
+	for _, test := range tests {
+		options, _, err := Generic(t.Logf, nil, []byte(test.text), len(test.to)-1, len(test.to))
+		if err != nil {
+			t.Fatalf("%s: generic complete failed with error %v", test.name, err)
+		}
+		if !reflect.DeepEqual(options, test.expected) {
+			t.Errorf("%s: expected '%v', got '%v'", test.name, test.expected, options)
+		}
+	}
+}
+
+func TestNewGenericLimitsOptions(t *testing.T) {
+	text := "a b c d e f g h i j k l m n o p"
+	options, _, err := NewGeneric(3, nil)(t.Logf, nil, []byte(text), 0, 0)
+	if err != nil {
+		t.Fatalf("generic complete failed with error %v", err)
+	}
+	if len(options) != 3 {
+		t.Errorf("expected 3 options, got %v", options)
+	}
+
+	options, _, err = NewGeneric(20, nil)(t.Logf, nil, []byte(text), 0, 0)
+	if err != nil {
+		t.Fatalf("generic complete failed with error %v", err)
+	}
+	if len(options) != 16 {
+		t.Errorf("expected 16 options, got %v", options)
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		word    string
+		pattern string
+		matches bool
+	}{
+		{word: "Println", pattern: "", matches: true},
+		{word: "Println", pattern: "pln", matches: true},
+		{word: "Println", pattern: "Pln", matches: true},
+		{word: "Println", pattern: "PL", matches: false},
+		{word: "Println", pattern: "nlP", matches: false},
+		{word: "fmt.Println", pattern: "fPl", matches: true},
+		{word: "ab", pattern: "abc", matches: false},
+	}
+
+	for _, test := range tests {
+		_, ok := fuzzyScore(test.word, test.pattern)
+		if ok != test.matches {
+			t.Errorf("matching '%v' against '%v': expected %v, got %v", test.pattern, test.word, test.matches, ok)
+		}
+	}
+
+	// Better matches have higher scores.
+	ordered := []string{"PrintLine", "Println", "appendLines"}
+	previous, _ := fuzzyScore(ordered[0], "pl")
+	for _, w := range ordered[1:] {
+		score, _ := fuzzyScore(w, "pl")
+		if score >= previous {
+			t.Errorf("expected '%v' to score less than %v for 'pl', but got %v", w, previous, score)
+		}
+		previous = score
+	}
+}
+
 func TestLastCharacters(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -173,6 +296,10 @@ func TestPrefix(t *testing.T) {
 			input:    `"quote`,
 			expected: "quote",
 		},
+		{
+			input:    "x := new_cou",
+			expected: "new_cou",
+		},
 	}
 
 	for _, test := range tests {
@@ -1,42 +1,55 @@
 package optionprovider
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os/exec"
 	"strconv"
+	"strings"
+
+	"github.com/zyedidia/micro/cmd/micro/snippet"
 )
 
-// GoCode is an OptionProvider which provides options to the autocompletion system.
-func GoCode(logger func(s string, values ...interface{}), buffer []byte, startOffset, currentOffset int) (options []Option, startOffsetDelta int, err error) {
+// GoCode is an OptionProvider which provides options to the autocompletion system. The gocode process is
+// killed if cancel is closed before it's finished.
+func GoCode(logger func(s string, values ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) (options []Option, startOffsetDelta int, err error) {
 	logger("autocompleter.GoCode: received offset %v - '...%v'->'%v'",
 		currentOffset,
 		string(previousX(buffer, startOffset, 10)),
 		string(buffer[startOffset:currentOffset]))
 	cmd := exec.Command("gocode", "-f=json", "autocomplete", strconv.Itoa(currentOffset))
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return
-	}
-	_, err = stdin.Write(buffer)
-	if err != nil {
-		return
-	}
-	err = stdin.Close()
-	if err != nil {
-		return
-	}
-	stdoutStderr, err := cmd.CombinedOutput()
+	var output bytes.Buffer
+	cmd.Stdin = bytes.NewReader(buffer)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Start()
 	if err != nil {
 		if execErr, isExecError := err.(*exec.Error); isExecError {
 			if execErr.Err.Error() == exec.ErrNotFound.Error() {
 				logger("autocompleter.GoCode: failed to run because GoCode is not on the path, defaulting to Generic")
-				return Generic(logger, buffer, startOffset, currentOffset)
+				return Generic(logger, cancel, buffer, startOffset, currentOffset)
 			}
 		}
 		return
 	}
 
+	// Stop gocode if the options stop being wanted before it's finished.
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+			cmd.Process.Kill()
+		case <-exited:
+		}
+	}()
+	err = cmd.Wait()
+	close(exited)
+	if err != nil {
+		return
+	}
+	stdoutStderr := output.Bytes()
+
 	// Unmarshal the JSON, it's an awkward format (mixed array)
 	// [1, [ { "class": "", "name": "", "type": "" } ]]
 	results := []interface{}{}
@@ -81,6 +94,7 @@ func previousX(b []byte, index, x int) []byte {
 
 func mapToOption(m map[string]interface{}) Option {
 	// Available values are "class", "name", "type" and "package"
+	// The class is one of "func", "var", "type", "const" or "package", which are the same as the kinds.
 	o := Option{}
 	if nv, ok := m["name"]; ok {
 		if n, nok := nv.(string); nok {
@@ -94,5 +108,53 @@ func mapToOption(m map[string]interface{}) Option {
 			o.H = t
 		}
 	}
+	if cv, ok := m["class"]; ok {
+		if c, cok := cv.(string); cok {
+			// kind
+			o.K = c
+		}
+	}
+	if o.K == KindFunc {
+		o.S = funcSnippet(o.T, o.H)
+	}
 	return o
 }
+
+// funcSnippet returns a snippet which calls the function called name with the type typ, e.g.
+// "func(a int, b string) error", with a placeholder for each parameter. It returns an empty
+// string if the function has no parameters.
+func funcSnippet(name, typ string) string {
+	if !strings.HasPrefix(typ, "func(") {
+		return ""
+	}
+	var params []string
+	depth, start := 0, len("func(")
+	for i := start; i < len(typ) && depth >= 0; i++ {
+		switch typ[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth < 0 {
+				params = append(params, typ[start:i])
+			}
+		case ',':
+			if depth == 0 {
+				params = append(params, typ[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if len(params) == 0 || (len(params) == 1 && strings.TrimSpace(params[0]) == "") {
+		return ""
+	}
+
+	s := snippet.Escape(name) + "("
+	for i, p := range params {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("${%d:%s}", i+1, snippet.Escape(strings.TrimSpace(p)))
+	}
+	return s + ")$0"
+}
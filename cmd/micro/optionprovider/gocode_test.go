@@ -0,0 +1,46 @@
+package optionprovider
+
+import (
+	"testing"
+)
+
+func TestMapToOption(t *testing.T) {
+	tests := []struct {
+		m        map[string]interface{}
+		expected Option
+	}{
+		{
+			m:        map[string]interface{}{"class": "var", "name": "x", "type": "int"},
+			expected: Option{T: "x", H: "int", K: KindVar},
+		},
+		{
+			m:        map[string]interface{}{"class": "func", "name": "Close", "type": "func() error"},
+			expected: Option{T: "Close", H: "func() error", K: KindFunc},
+		},
+		{
+			m: map[string]interface{}{"class": "func", "name": "Fprintf", "type": "func(w io.Writer, format string, a ...interface{}) (n int, err error)"},
+			expected: Option{
+				T: "Fprintf",
+				H: "func(w io.Writer, format string, a ...interface{}) (n int, err error)",
+				K: KindFunc,
+				S: "Fprintf(${1:w io.Writer}, ${2:format string}, ${3:a ...interface{\\}})$0",
+			},
+		},
+		{
+			m: map[string]interface{}{"class": "func", "name": "Walk", "type": "func(root string, fn func(path string) error) error"},
+			expected: Option{
+				T: "Walk",
+				H: "func(root string, fn func(path string) error) error",
+				K: KindFunc,
+				S: "Walk(${1:root string}, ${2:fn func(path string) error})$0",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		actual := mapToOption(test.m)
+		if actual != test.expected {
+			t.Errorf("for %v, expected %#v, got %#v", test.m, test.expected, actual)
+		}
+	}
+}
@@ -3,8 +3,25 @@ package optionprovider
 // Option describes a multiple choice selection.
 type Option struct {
 	T, H string
+	// K is the kind of thing that the option is, one of the Kind constants, or empty if it isn't known.
+	K string
+	// D is the documentation of the option, shown next to the list of options.
+	D string
+	// S is a snippet which is inserted instead of the text if it's set, e.g. to add placeholders for the
+	// arguments of a function. See the snippet package for its syntax.
+	S string
 }
 
+// The kinds of option. They match the classes returned by gocode.
+const (
+	KindFunc    = "func"
+	KindVar     = "var"
+	KindType    = "type"
+	KindConst   = "const"
+	KindPackage = "package"
+	KindKeyword = "keyword"
+)
+
 // New creates a new option value.
 func New(text, hint string) Option {
 	return Option{T: text, H: hint}
@@ -19,3 +36,18 @@ func (o Option) Text() string {
 func (o Option) Hint() string {
 	return o.H
 }
+
+// Kind is the kind of thing that the option is, e.g. KindFunc.
+func (o Option) Kind() string {
+	return o.K
+}
+
+// Doc is the documentation of the option.
+func (o Option) Doc() string {
+	return o.D
+}
+
+// Snippet is the snippet which is inserted instead of the text, if there is one.
+func (o Option) Snippet() string {
+	return o.S
+}
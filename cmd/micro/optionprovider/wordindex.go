@@ -0,0 +1,206 @@
+package optionprovider
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// WordIndex counts the words in a set of documents, so that Generic can offer words from all of them
+// without searching every document on each keystroke. Open documents are kept up to date line by line
+// as they're edited. Files which have been read from disk are also counted, unless the same file is
+// open. It's safe to use from multiple goroutines.
+type WordIndex struct {
+	lock   sync.RWMutex
+	open   map[string]*indexedDocument
+	files  map[string]*indexedDocument
+	counts map[string]int
+}
+
+type indexedDocument struct {
+	// lines holds the number of times that each word appears on each line.
+	lines []map[string]int
+	// counts holds the number of times that each word appears in the whole document.
+	counts map[string]int
+}
+
+// NewWordIndex creates an empty index.
+func NewWordIndex() *WordIndex {
+	return &WordIndex{
+		open:   make(map[string]*indexedDocument),
+		files:  make(map[string]*indexedDocument),
+		counts: make(map[string]int),
+	}
+}
+
+func newIndexedDocument(text string) *indexedDocument {
+	d := &indexedDocument{counts: make(map[string]int)}
+	d.replace(0, 0, strings.Split(text, "\n"), nil)
+	return d
+}
+
+// replace replaces lines start to end (exclusive) of the document, adding the difference in the word
+// counts to total.
+func (d *indexedDocument) replace(start, end int, lines []string, total map[string]int) {
+	for _, l := range d.lines[start:end] {
+		for w, n := range l {
+			add(d.counts, w, -n)
+			add(total, w, -n)
+		}
+	}
+	replaced := make([]map[string]int, len(lines))
+	for i, line := range lines {
+		replaced[i] = countLine(line)
+		for w, n := range replaced[i] {
+			add(d.counts, w, n)
+			add(total, w, n)
+		}
+	}
+	d.lines = append(d.lines[:start], append(replaced, d.lines[end:]...)...)
+}
+
+// addTo adds (or, if sign is -1, removes) the document's word counts to total.
+func (d *indexedDocument) addTo(total map[string]int, sign int) {
+	for w, n := range d.counts {
+		add(total, w, sign*n)
+	}
+}
+
+func add(counts map[string]int, w string, n int) {
+	if counts == nil {
+		return
+	}
+	if counts[w] += n; counts[w] <= 0 {
+		delete(counts, w)
+	}
+}
+
+// countLine counts the words and qualified names on a line.
+func countLine(line string) map[string]int {
+	var counts map[string]int
+	for _, re := range [...]*regexp.Regexp{word, qualified} {
+		for _, w := range re.FindAllString(line, -1) {
+			if counts == nil {
+				counts = make(map[string]int)
+			}
+			counts[w]++
+		}
+	}
+	return counts
+}
+
+// Open adds an open document to the index, replacing the file with the same name if there is one.
+func (wi *WordIndex) Open(name, text string) {
+	wi.lock.Lock()
+	defer wi.lock.Unlock()
+	wi.remove(name)
+	if f, ok := wi.files[name]; ok {
+		f.addTo(wi.counts, -1)
+	}
+	d := newIndexedDocument(text)
+	d.addTo(wi.counts, 1)
+	wi.open[name] = d
+}
+
+// Change updates the index after lines start to end (exclusive) of an open document have been replaced
+// with lines.
+func (wi *WordIndex) Change(name string, start, end int, lines []string) {
+	wi.lock.Lock()
+	defer wi.lock.Unlock()
+	d, ok := wi.open[name]
+	if !ok || start < 0 || start > end || end > len(d.lines) {
+		return
+	}
+	d.replace(start, end, lines, wi.counts)
+}
+
+// Close removes an open document from the index.
+func (wi *WordIndex) Close(name string) {
+	wi.lock.Lock()
+	defer wi.lock.Unlock()
+	if wi.remove(name) {
+		if f, ok := wi.files[name]; ok {
+			f.addTo(wi.counts, 1)
+		}
+	}
+}
+
+func (wi *WordIndex) remove(name string) bool {
+	d, ok := wi.open[name]
+	if ok {
+		d.addTo(wi.counts, -1)
+		delete(wi.open, name)
+	}
+	return ok
+}
+
+// AddFile adds the contents of a file which isn't being edited to the index.
+func (wi *WordIndex) AddFile(name, text string) {
+	d := newIndexedDocument(text)
+
+	wi.lock.Lock()
+	defer wi.lock.Unlock()
+	_, shadowed := wi.open[name]
+	if f, ok := wi.files[name]; ok && !shadowed {
+		f.addTo(wi.counts, -1)
+	}
+	wi.files[name] = d
+	if !shadowed {
+		d.addTo(wi.counts, 1)
+	}
+}
+
+// Maximum size of the files added by AddDirectory.
+const maxIndexedFileSize = 1 << 20
+
+// errEnoughFiles stops AddDirectory once it has added as many files as it was asked to.
+var errEnoughFiles = errors.New("enough files have been indexed")
+
+// AddDirectory adds the files under dir which have the extension ext to the index, stopping after
+// maxFiles files. Hidden files and directories are skipped, as are files which look binary.
+func (wi *WordIndex) AddDirectory(dir, ext string, maxFiles int) error {
+	added := 0
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Skip what can't be read rather than giving up.
+			return nil
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || filepath.Ext(path) != ext || info.Size() > maxIndexedFileSize {
+			return nil
+		}
+		if added >= maxFiles {
+			return errEnoughFiles
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil || bytes.IndexByte(data, 0) >= 0 {
+			return nil
+		}
+		wi.AddFile(path, string(data))
+		added++
+		return nil
+	})
+	if err == errEnoughFiles {
+		return nil
+	}
+	return err
+}
+
+// Words calls fn with every word in the index and the number of times that it appears.
+func (wi *WordIndex) Words(fn func(w string, count int)) {
+	wi.lock.RLock()
+	defer wi.lock.RUnlock()
+	for w, n := range wi.counts {
+		fn(w, n)
+	}
+}
@@ -0,0 +1,110 @@
+package optionprovider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func indexCounts(wi *WordIndex) map[string]int {
+	counts := make(map[string]int)
+	wi.Words(func(w string, count int) {
+		counts[w] = count
+	})
+	return counts
+}
+
+func TestWordIndex(t *testing.T) {
+	wi := NewWordIndex()
+	wi.Open("a.go", "fmt.Println(x)\ny := x")
+	expected := map[string]int{"fmt": 1, "Println": 1, "fmt.Println": 1, "x": 2, "y": 1}
+	if actual := indexCounts(wi); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("after open: expected %v, got %v", expected, actual)
+	}
+
+	// Replace the second line with two new ones.
+	wi.Change("a.go", 1, 2, []string{"z := x", "w := z"})
+	expected = map[string]int{"fmt": 1, "Println": 1, "fmt.Println": 1, "x": 2, "z": 2, "w": 1}
+	if actual := indexCounts(wi); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("after change: expected %v, got %v", expected, actual)
+	}
+
+	// Files on disk are hidden while a document with the same name is open.
+	wi.AddFile("a.go", "old")
+	wi.AddFile("b.go", "other")
+	expected["other"] = 1
+	if actual := indexCounts(wi); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("after adding files: expected %v, got %v", expected, actual)
+	}
+
+	wi.Close("a.go")
+	expected = map[string]int{"old": 1, "other": 1}
+	if actual := indexCounts(wi); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("after close: expected %v, got %v", expected, actual)
+	}
+}
+
+func TestWordIndexAddDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wordindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.go":         "alpha",
+		"b.txt":        "bravo",
+		"sub/c.go":     "charlie",
+		".hidden/d.go": "delta",
+	}
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wi := NewWordIndex()
+	if err := wi.AddDirectory(dir, ".go", 10); err != nil {
+		t.Fatalf("failed to add directory: %v", err)
+	}
+	expected := map[string]int{"alpha": 1, "charlie": 1}
+	if actual := indexCounts(wi); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	wi = NewWordIndex()
+	if err := wi.AddDirectory(dir, ".go", 1); err != nil {
+		t.Fatalf("failed to add directory: %v", err)
+	}
+	if actual := indexCounts(wi); len(actual) != 1 {
+		t.Errorf("expected a single file to be added, got %v", actual)
+	}
+}
+
+func TestGenericWithIndex(t *testing.T) {
+	wi := NewWordIndex()
+	wi.AddFile("other.go", "func printDocument() {}\nfunc parseDocument() {}")
+	text := "printDoc\nx := pri"
+	wi.Open("main.go", text)
+
+	options, delta, err := NewGeneric(10, wi)(t.Logf, nil, []byte(text), len(text), len(text))
+	if err != nil {
+		t.Fatalf("generic complete failed with error %v", err)
+	}
+	if delta != -3 {
+		t.Errorf("expected delta -3, got %d", delta)
+	}
+	var actual []string
+	for _, o := range options {
+		actual = append(actual, o.Text())
+	}
+	// printDoc is nearer to the cursor, but printDocument comes from another file.
+	expected := []string{"printDoc", "printDocument"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
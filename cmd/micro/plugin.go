@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/yuin/gopher-lua"
+	"github.com/zyedidia/micro/cmd/micro/optionprovider"
 	"github.com/zyedidia/tcell"
 	"layeh.com/gopher-luar"
 )
@@ -94,6 +95,58 @@ func LuaFunctionCommand(function string) func([]string) {
 	}
 }
 
+// LuaFunctionCompleter returns a completion provider which calls a lua function
+// The function is given the buffer's text, and the byte offsets (starting from 0)
+// of the text being completed and of the cursor. It returns a table of options,
+// each being either a string or a table with `text`, `hint`, `kind`, `doc` and `snippet` fields
+// The lua VM can only be used in the main loop, so this waits for the main loop
+// to run the function and must not be called from it. If the options stop being
+// wanted before then, the function isn't called
+func LuaFunctionCompleter(function string) OptionProvider {
+	return func(logger func(string, ...interface{}), cancel <-chan struct{}, buffer []byte, startOffset, currentOffset int) ([]optionprovider.Option, int, error) {
+		var options []optionprovider.Option
+		var err error
+		done := make(chan struct{})
+		RunInMainLoop(func() {
+			defer close(done)
+			select {
+			case <-cancel:
+				return
+			default:
+			}
+
+			var res lua.LValue
+			res, err = Call(function, string(buffer), startOffset, currentOffset)
+			if err != nil {
+				return
+			}
+			tbl, ok := res.(*lua.LTable)
+			if !ok {
+				err = errors.New(function + " should return a table of options")
+				return
+			}
+			for i := 1; i <= tbl.Len(); i++ {
+				switch v := tbl.RawGetInt(i).(type) {
+				case lua.LString:
+					options = append(options, optionprovider.New(string(v), ""))
+				case *lua.LTable:
+					o := optionprovider.New(lua.LVAsString(v.RawGetString("text")), lua.LVAsString(v.RawGetString("hint")))
+					o.K = lua.LVAsString(v.RawGetString("kind"))
+					o.D = lua.LVAsString(v.RawGetString("doc"))
+					o.S = lua.LVAsString(v.RawGetString("snippet"))
+					options = append(options, o)
+				}
+			}
+		})
+		select {
+		case <-done:
+			return options, 0, err
+		case <-cancel:
+			return nil, 0, nil
+		}
+	}
+}
+
 // LuaFunctionComplete returns a function which can be used for autocomplete in plugins
 func LuaFunctionComplete(function string) func(string) []string {
 	return func(input string) (result []string) {
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/zyedidia/micro/cmd/micro/optionprovider"
+	"github.com/zyedidia/tcell"
+)
+
+// kindIcons are drawn before the options to show what kind of thing they are.
+var kindIcons = map[string]rune{
+	optionprovider.KindFunc:    'f',
+	optionprovider.KindVar:     'v',
+	optionprovider.KindType:    't',
+	optionprovider.KindConst:   'c',
+	optionprovider.KindPackage: 'p',
+	optionprovider.KindKeyword: 'k',
+}
+
+const (
+	// maxHintWidth is the widest that the hint column can be. Longer hints are cut short, but can be
+	// read in full in the detail pane.
+	maxHintWidth = 40
+	// maxDetailWidth is the widest that the detail pane can be, including its padding.
+	maxDetailWidth = 60
+	// unbounded is used for the edges of the area that the options are drawn in when there's no limit.
+	unbounded = 1 << 30
+)
+
+// popupLayout describes where the list of options is drawn.
+type popupLayout struct {
+	// x and y are the top left corner of the list.
+	x, y int
+	// rows is the number of options shown, starting from the option at c.top.
+	rows int
+	// above is true if the list is drawn above the line being completed.
+	above bool
+	// The widths of the columns, including the space after them. Columns which
+	// aren't needed have no width.
+	iconWidth, textWidth, hintWidth, scrollbarWidth int
+	// left, top, right and bottom are the area that can be drawn in.
+	left, top, right, bottom int
+}
+
+func (p popupLayout) width() int {
+	return p.iconWidth + p.textWidth + p.hintWidth + p.scrollbarWidth
+}
+
+// layout works out where to draw the options, given the position of the start of the text being
+// completed. The list goes below the line unless there's more room above it, and is moved left if
+// it would go past the right hand edge. It also scrolls the list to show the active option.
+func (c *Completer) layout(start Loc) (p popupLayout) {
+	p.left, p.top, p.right, p.bottom = -unbounded, -unbounded, unbounded, unbounded
+	if c.Bounds != nil {
+		p.left, p.top, p.right, p.bottom = c.Bounds()
+	}
+
+	p.rows = len(c.Options)
+	if c.MaxHeight > 0 && p.rows > c.MaxHeight {
+		p.rows = c.MaxHeight
+	}
+	below, above := p.bottom-start.Y-1, start.Y-p.top
+	if p.rows > below && above > below {
+		p.above = true
+		p.rows = Min(p.rows, above)
+		p.y = start.Y - p.rows
+	} else {
+		p.rows = Min(p.rows, below)
+		p.y = start.Y + 1
+	}
+	if p.rows <= 0 {
+		p.rows = 0
+		return
+	}
+
+	// Scroll to the active option.
+	active := c.ActiveIndex
+	if active < 0 {
+		active = 0
+	}
+	if active < c.top {
+		c.top = active
+	}
+	if active >= c.top+p.rows {
+		c.top = active - p.rows + 1
+	}
+	if c.top > len(c.Options)-p.rows {
+		c.top = len(c.Options) - p.rows
+	}
+	if c.top < 0 {
+		c.top = 0
+	}
+
+	for _, o := range c.Options {
+		if _, ok := kindIcons[o.Kind()]; ok {
+			p.iconWidth = 2
+		}
+		p.textWidth = Max(p.textWidth, runewidth.StringWidth(o.Text())+1)
+		if o.Hint() != "" {
+			p.hintWidth = Max(p.hintWidth, Min(runewidth.StringWidth(o.Hint()), maxHintWidth)+1)
+		}
+	}
+	if len(c.Options) > p.rows {
+		p.scrollbarWidth = 1
+	}
+
+	p.x = start.X - p.iconWidth
+	if p.x+p.width() > p.right {
+		p.x = p.right - p.width()
+	}
+	if p.x < p.left {
+		p.x = p.left
+	}
+	return
+}
+
+// drawOptions draws the visible options, and a scrollbar if some of them are hidden.
+func (c *Completer) drawOptions(p popupLayout) {
+	thumbStart, thumbEnd := 0, 0
+	if p.scrollbarWidth > 0 {
+		thumbSize := Max(1, p.rows*p.rows/len(c.Options))
+		thumbStart = c.top * p.rows / len(c.Options)
+		thumbEnd = Min(thumbStart+thumbSize, p.rows)
+		if c.top+p.rows == len(c.Options) {
+			thumbStart = thumbEnd - thumbSize
+		}
+	}
+
+	for row := 0; row < p.rows; row++ {
+		i := c.top + row
+		o := c.Options[i]
+		y := p.y + row
+
+		// If it's active, show it differently.
+		style := c.OptionStyleInactive
+		if c.ActiveIndex == i {
+			style = c.OptionStyleActive
+		}
+
+		x := p.x
+		if p.iconWidth > 0 {
+			icon := ' '
+			if r, ok := kindIcons[o.Kind()]; ok {
+				icon = r
+			}
+			x = c.drawText(p, x, y, string(icon), p.iconWidth, style)
+		}
+		x = c.drawText(p, x, y, o.Text(), p.textWidth, style)
+		if p.hintWidth > 0 {
+			x = c.drawText(p, x, y, truncate(o.Hint(), p.hintWidth-1), p.hintWidth, style)
+		}
+		if p.scrollbarWidth > 0 {
+			bar := ' '
+			if row >= thumbStart && row < thumbEnd {
+				bar = '█'
+			}
+			c.drawText(p, x, y, string(bar), p.scrollbarWidth, c.OptionStyleInactive)
+		}
+	}
+}
+
+// drawDetail draws the full hint and the documentation of the active option beside the list,
+// if either of them has something which can't be seen in the list.
+func (c *Completer) drawDetail(p popupLayout) {
+	if c.ActiveIndex < 0 || c.ActiveIndex >= len(c.Options) {
+		return
+	}
+	o := c.Options[c.ActiveIndex]
+	if o.Doc() == "" && runewidth.StringWidth(o.Hint()) < p.hintWidth {
+		return
+	}
+
+	// Use whichever side of the list has more room, unless the pane fits on the right.
+	onLeft, room := false, p.right-p.x-p.width()
+	if room < maxDetailWidth && p.x-p.left > room {
+		onLeft, room = true, p.x-p.left
+	}
+	room = Min(room, maxDetailWidth) - 2
+	if room <= 0 {
+		return
+	}
+
+	var lines []string
+	if o.Hint() != "" {
+		lines = append(lines, wrap(o.Hint(), room)...)
+	}
+	if o.Doc() != "" {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		doc := strings.Replace(strings.TrimSpace(o.Doc()), "\t", "    ", -1)
+		for _, l := range strings.Split(doc, "\n") {
+			lines = append(lines, wrap(l, room)...)
+		}
+	}
+	height := Max(p.rows, Min(len(lines), c.MaxHeight))
+	if p.above {
+		height = Min(height, p.y+p.rows-p.top)
+	} else {
+		height = Min(height, p.bottom-p.y)
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+
+	width := 0
+	for _, l := range lines {
+		width = Max(width, runewidth.StringWidth(l)+2)
+	}
+	x := p.x + p.width()
+	if onLeft {
+		x = p.x - width
+	}
+	y := p.y
+	if p.above {
+		y = p.y + p.rows - len(lines)
+	}
+	for i, l := range lines {
+		c.drawText(p, x, y+i, " "+l, width, c.OptionStyleInactive)
+	}
+}
+
+// drawText draws s at x, y, padded to width columns, and returns the x position after it. Wide
+// characters take up two columns. Anything outside the area of the layout is left out.
+func (c *Completer) drawText(p popupLayout, x, y int, s string, width int, style tcell.Style) int {
+	set := func(col int, r rune, combc []rune) {
+		if x+col >= p.left && x+col < p.right {
+			c.Setter(x+col, y, r, combc, style)
+		}
+	}
+	runes := []rune(s)
+	col := 0
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		i++
+		// Combining characters are drawn in the same cell as the character before them.
+		var combc []rune
+		for i < len(runes) && runewidth.RuneWidth(runes[i]) == 0 {
+			combc = append(combc, runes[i])
+			i++
+		}
+		w := Max(runewidth.RuneWidth(r), 1)
+		if col+w > width {
+			break
+		}
+		set(col, r, combc)
+		col += w
+	}
+	for ; col < width; col++ {
+		set(col, 0, nil)
+	}
+	return x + width
+}
+
+// truncate shortens s to width columns, ending it with an ellipsis if anything was cut off.
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	return runewidth.Truncate(s, width, "…")
+}
+
+// wrap splits s into lines at most width columns wide, breaking at spaces where possible.
+func wrap(s string, width int) (lines []string) {
+	runes := []rune(s)
+	for runewidth.StringWidth(string(runes)) > width {
+		fit, w := 0, 0
+		for fit < len(runes) && w+runewidth.RuneWidth(runes[fit]) <= width {
+			w += runewidth.RuneWidth(runes[fit])
+			fit++
+		}
+		end := fit
+		for end > 0 && runes[end] != ' ' {
+			end--
+		}
+		if end == 0 {
+			end = Max(fit, 1)
+		}
+		lines = append(lines, string(runes[:end]))
+		runes = runes[end:]
+		for len(runes) > 0 && runes[0] == ' ' {
+			runes = runes[1:]
+		}
+		if len(runes) == 0 {
+			return lines
+		}
+	}
+	return append(lines, string(runes))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zyedidia/micro/cmd/micro/optionprovider"
+	"github.com/zyedidia/tcell"
+)
+
+func newPopupCompleter(n int, cursor Loc, left, top, right, bottom int) *Completer {
+	currentLocation := func() Loc {
+		return cursor
+	}
+	c := NewCompleter(nil, nil, nil, func(s string, values ...interface{}) {}, nil, currentLocation, nil, nil, noopContentSetter, optionStyleInactive, optionStyleActive, enabledFlagSetToTrue)
+	c.Bounds = func() (int, int, int, int) {
+		return left, top, right, bottom
+	}
+	c.Active = true
+	c.X = cursor.X
+	c.Y = cursor.Y
+	for i := 0; i < n; i++ {
+		c.Options = append(c.Options, optionprovider.New(fmt.Sprintf("option%02d", i), ""))
+	}
+	return c
+}
+
+func TestPopupLayout(t *testing.T) {
+	tests := []struct {
+		name                   string
+		options                int
+		cursor                 Loc
+		bottom                 int
+		activeIndex            int
+		expectedX, expectedY   int
+		expectedRows           int
+		expectedTop            int
+		expectedAbove          bool
+		expectedScrollbarWidth int
+	}{
+		{
+			name:         "the list goes below the cursor",
+			options:      3,
+			cursor:       Loc{X: 5, Y: 2},
+			bottom:       20,
+			expectedX:    5,
+			expectedY:    3,
+			expectedRows: 3,
+		},
+		{
+			name:                   "long lists are limited to the maximum height and scroll",
+			options:                15,
+			bottom:                 20,
+			expectedY:              1,
+			expectedRows:           10,
+			expectedScrollbarWidth: 1,
+		},
+		{
+			name:                   "the list scrolls to the active option",
+			options:                15,
+			bottom:                 20,
+			activeIndex:            12,
+			expectedY:              1,
+			expectedRows:           10,
+			expectedTop:            3,
+			expectedScrollbarWidth: 1,
+		},
+		{
+			name:          "the list goes above the cursor near the bottom",
+			options:       5,
+			cursor:        Loc{X: 0, Y: 18},
+			bottom:        20,
+			expectedY:     13,
+			expectedRows:  5,
+			expectedAbove: true,
+		},
+		{
+			name:                   "the list is shortened if there isn't room above or below",
+			options:                5,
+			cursor:                 Loc{X: 0, Y: 2},
+			bottom:                 6,
+			expectedY:              3,
+			expectedRows:           3,
+			expectedScrollbarWidth: 1,
+		},
+		{
+			name:         "the list moves left at the right hand edge",
+			options:      2,
+			cursor:       Loc{X: 35, Y: 0},
+			bottom:       20,
+			expectedX:    31,
+			expectedY:    1,
+			expectedRows: 2,
+		},
+	}
+
+	for _, test := range tests {
+		c := newPopupCompleter(test.options, test.cursor, 0, 0, 40, test.bottom)
+		c.ActiveIndex = test.activeIndex
+		p := c.layout(test.cursor)
+		if p.x != test.expectedX || p.y != test.expectedY {
+			t.Errorf("%s: expected the list at %d, %d, got %d, %d", test.name, test.expectedX, test.expectedY, p.x, p.y)
+		}
+		if p.rows != test.expectedRows {
+			t.Errorf("%s: expected %d rows, got %d", test.name, test.expectedRows, p.rows)
+		}
+		if c.top != test.expectedTop {
+			t.Errorf("%s: expected the list to start at option %d, got %d", test.name, test.expectedTop, c.top)
+		}
+		if p.above != test.expectedAbove {
+			t.Errorf("%s: expected above to be %v, got %v", test.name, test.expectedAbove, p.above)
+		}
+		if p.scrollbarWidth != test.expectedScrollbarWidth {
+			t.Errorf("%s: expected a scrollbar width of %d, got %d", test.name, test.expectedScrollbarWidth, p.scrollbarWidth)
+		}
+	}
+}
+
+func TestPopupDetail(t *testing.T) {
+	// The rows of the expected display use NUL for padding, and start from the
+	// leftmost cell that's drawn.
+	tests := []struct {
+		name     string
+		cursor   Loc
+		doc      string
+		expected string
+	}{
+		{
+			name:     "the documentation is shown on the right",
+			doc:      "Does things.",
+			expected: "a\x00 Does things.\x00",
+		},
+		{
+			name:     "the documentation is shown on the left if there's more room there",
+			cursor:   Loc{X: 16, Y: 0},
+			doc:      "Does things.",
+			expected: " Does things.\x00a\x00",
+		},
+		{
+			name:     "long documentation is wrapped",
+			doc:      "Does many things.",
+			expected: "a\x00 Does many\x00\n   things.\x00\x00\x00",
+		},
+		{
+			name:     "nothing is shown without documentation",
+			expected: "a\x00",
+		},
+	}
+
+	for _, test := range tests {
+		actual := make(displayMap)
+		c := newPopupCompleter(0, test.cursor, 0, 0, 18, 6)
+		c.Options = []optionprovider.Option{{T: "a", D: test.doc}}
+		c.ShowDetail = true
+		c.Setter = func(x int, y int, mainc rune, combc []rune, style tcell.Style) {
+			actual[Loc{X: x, Y: y}] = rs{Rune: mainc, Style: style}
+		}
+
+		c.Display()
+		if actual.String() != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, actual.String())
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A replacer makes the replacement for each match of a regex from a template,
+// where $1 or ${name} stand for the groups of the match, like in
+// regexp.Expand
+type replacer struct {
+	regex    *regexp.Regexp
+	template []byte
+	// preserveCase makes the case of each replacement follow the case of the
+	// text it replaces
+	preserveCase bool
+}
+
+// newReplacer returns a replacer for a template typed by the user, which can
+// have \n, \t and \\ escapes. A literal template is used as it is, without
+// expanding $ in it.
+func newReplacer(regex *regexp.Regexp, template string, literal, preserveCase bool) *replacer {
+	template = unescapeReplacement(template)
+	if literal {
+		template = strings.Replace(template, "$", "$$", -1)
+	}
+	return &replacer{regex, []byte(template), preserveCase}
+}
+
+// unescapeReplacement replaces the escapes in a replacement template with the
+// characters they stand for. Other backslashes are kept.
+func unescapeReplacement(template string) string {
+	if !strings.Contains(template, `\`) {
+		return template
+	}
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c == '\\' && i+1 < len(template) {
+			switch template[i+1] {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case '\\':
+				c = '\\'
+			default:
+				b.WriteByte(c)
+				continue
+			}
+			i++
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// matchCase changes the case of a replacement to the case of the text it
+// replaces. An upper case match gives an upper case replacement, and a match
+// which starts with an upper case letter gives a replacement which does too.
+// Otherwise the replacement is kept as it is.
+func matchCase(replacement, matched string) string {
+	hasUpper, hasLower := false, false
+	for _, r := range matched {
+		hasUpper = hasUpper || unicode.IsUpper(r)
+		hasLower = hasLower || unicode.IsLower(r)
+	}
+	switch {
+	case hasUpper && !hasLower && utf8.RuneCountInString(matched) > 1:
+		return strings.ToUpper(replacement)
+	case hasUpper:
+		first, _ := utf8.DecodeRuneInString(matched)
+		if unicode.IsUpper(first) {
+			r, size := utf8.DecodeRuneInString(replacement)
+			return string(unicode.ToUpper(r)) + replacement[size:]
+		}
+	}
+	return replacement
+}
+
+// expand returns the replacement for the match of the regex in src, given by
+// the indexes from regexp.FindSubmatchIndex
+func (r *replacer) expand(src []byte, match []int) string {
+	text := string(r.regex.Expand(nil, r.template, src, match))
+	if r.preserveCase {
+		text = matchCase(text, string(src[match[0]:match[1]]))
+	}
+	return text
+}
+
+// locator returns a function which converts byte offsets in text, which is
+// found at start in the buffer, to locations. The offsets it's given must not
+// decrease.
+func locator(text []byte, start Loc) func(offset int) Loc {
+	pos, loc := 0, start
+	return func(offset int) Loc {
+		for pos < offset {
+			c, size := utf8.DecodeRune(text[pos:])
+			if c == '\n' {
+				loc = Loc{0, loc.Y + 1}
+			} else {
+				loc.X++
+			}
+			pos += size
+		}
+		return loc
+	}
+}
+
+// deltas returns the deltas which replace each match in text, which is found
+// at start in the buffer. The deltas are in reverse order, so each one can be
+// applied without moving the text of the ones after it.
+func (r *replacer) deltas(text []byte, start Loc) []Delta {
+	var deltas []Delta
+	locate := locator(text, start)
+	for _, match := range r.regex.FindAllSubmatchIndex(text, -1) {
+		from, to := locate(match[0]), locate(match[1])
+		deltas = append(deltas, Delta{r.expand(text, match), from, to})
+	}
+	for i, j := 0, len(deltas)-1; i < j; i, j = i+1, j-1 {
+		deltas[i], deltas[j] = deltas[j], deltas[i]
+	}
+	return deltas
+}
+
+// replaceAll returns text with each match replaced, along with the number of
+// matches
+func (r *replacer) replaceAll(text []byte) (string, int) {
+	var b strings.Builder
+	last := 0
+	matches := r.regex.FindAllSubmatchIndex(text, -1)
+	for _, match := range matches {
+		b.Write(text[last:match[0]])
+		b.WriteString(r.expand(text, match))
+		last = match[1]
+	}
+	b.Write(text[last:])
+	return b.String(), len(matches)
+}
+
+// A region is the part of a buffer from start to end
+type region struct {
+	start, end Loc
+}
+
+// deltasIn returns the deltas which replace each match in the regions of the
+// buffer, in reverse order like deltas
+func (r *replacer) deltasIn(buf *Buffer, regions []region) []Delta {
+	var deltas []Delta
+	for _, rg := range regions {
+		deltas = append(deltas, r.deltas([]byte(buf.Substr(rg.start, rg.end)), rg.start)...)
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		return deltas[j].Start.LessThan(deltas[i].Start)
+	})
+	return deltas
+}
+
+// find returns where the first match between from and to is in the buffer,
+// along with the text it was found in and its indexes in that text, which
+// are nil if there isn't a match
+func (r *replacer) find(buf *Buffer, from, to Loc) (region, []byte, []int) {
+	text := []byte(buf.Substr(from, to))
+	match := r.regex.FindSubmatchIndex(text)
+	if match == nil {
+		return region{}, nil, nil
+	}
+	locate := locator(text, from)
+	return region{locate(match[0]), locate(match[1])}, text, match
+}
+
+// replaceRegions returns the regions of the view's buffer which a replace
+// works on. These are the given lines, counted from 1, or else the selections
+// of the cursors, in order. It returns nil if the whole buffer is used.
+func replaceRegions(v *View, lines *lineRange) ([]region, error) {
+	if lines != nil {
+		last := v.Buf.LinesNum()
+		if lines.start < 1 || lines.start > lines.end || lines.start > last {
+			return nil, errors.New("Invalid range of lines")
+		}
+		end := Min(lines.end, last) - 1
+		return []region{{Loc{0, lines.start - 1}, Loc{Count(v.Buf.Line(end)), end}}}, nil
+	}
+
+	var regions []region
+	for _, c := range v.Buf.cursors {
+		if c.HasSelection() {
+			sel := c.CurSelection
+			if sel[1].LessThan(sel[0]) {
+				sel[0], sel[1] = sel[1], sel[0]
+			}
+			regions = append(regions, region{sel[0], sel[1]})
+		}
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].start.LessThan(regions[j].start)
+	})
+	return regions, nil
+}
+
+// shiftLoc returns where loc is after the text which ends at end is replaced
+// with text which ends at newEnd. Locations before end aren't moved.
+func shiftLoc(loc, end, newEnd Loc) Loc {
+	if loc.LessThan(end) {
+		return loc
+	}
+	if loc.Y == end.Y {
+		return Loc{newEnd.X + loc.X - end.X, newEnd.Y}
+	}
+	return Loc{loc.X, loc.Y + newEnd.Y - end.Y}
+}
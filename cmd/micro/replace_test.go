@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestUnescapeReplacement(t *testing.T) {
+	tests := map[string]string{
+		`a\nb`:     "a\nb",
+		`\tx`:      "\tx",
+		`a\\nb`:    `a\nb`,
+		`\d\`:      `\d\`,
+		`$1\n${x}`: "$1\n${x}",
+	}
+	for template, expected := range tests {
+		if got := unescapeReplacement(template); got != expected {
+			t.Errorf("%q: expected %q, got %q", template, expected, got)
+		}
+	}
+}
+
+func TestMatchCase(t *testing.T) {
+	tests := []struct {
+		replacement, matched, expected string
+	}{
+		{"bar", "foo", "bar"},
+		{"bar", "Foo", "Bar"},
+		{"bar", "FOO", "BAR"},
+		{"bar", "fOO", "bar"},
+		{"barBaz", "F", "BarBaz"},
+		{"", "FOO", ""},
+	}
+	for _, test := range tests {
+		if got := matchCase(test.replacement, test.matched); got != test.expected {
+			t.Errorf("%q onto %q: expected %q, got %q", test.replacement, test.matched, test.expected, got)
+		}
+	}
+}
+
+func TestReplacerDeltas(t *testing.T) {
+	tests := []struct {
+		search, template string
+		literal          bool
+		text             string
+		expected         []Delta
+	}{
+		{`(\S+)=(\S+)`, `$2=$1`, false, "a=b\nxé=y", []Delta{
+			{"y=xé", Loc{0, 1}, Loc{4, 1}},
+			{"b=a", Loc{0, 0}, Loc{3, 0}},
+		}},
+		{`(?P<key>\w+):`, `${key}\t`, false, "k: v", []Delta{
+			{"k\t", Loc{0, 0}, Loc{2, 0}},
+		}},
+		{`,\n`, `, `, false, "a,\nb,\nc", []Delta{
+			{", ", Loc{1, 1}, Loc{0, 2}},
+			{", ", Loc{1, 0}, Loc{0, 1}},
+		}},
+		{`; `, `;\n`, false, "a; b", []Delta{
+			{";\n", Loc{1, 0}, Loc{3, 0}},
+		}},
+		{`\$x`, `$1`, true, "$x", []Delta{
+			{"$1", Loc{0, 0}, Loc{2, 0}},
+		}},
+	}
+	for _, test := range tests {
+		regex := regexp.MustCompile("(?m)" + test.search)
+		r := newReplacer(regex, test.template, test.literal, false)
+		if got := r.deltas([]byte(test.text), Loc{0, 0}); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.search, test.expected, got)
+		}
+	}
+
+	r := newReplacer(regexp.MustCompile(`(?i)foo`), "bar", false, true)
+	got := r.deltas([]byte("foo Foo FOO"), Loc{0, 0})
+	var replacements []string
+	for _, d := range got {
+		replacements = append(replacements, d.Text)
+	}
+	if expected := []string{"BAR", "Bar", "bar"}; !reflect.DeepEqual(replacements, expected) {
+		t.Errorf("expected the case to be kept as %v, got %v", expected, replacements)
+	}
+}
+
+func TestShiftLoc(t *testing.T) {
+	// The text ending at {4, 1} is replaced with text ending at {2, 3}
+	end, newEnd := Loc{4, 1}, Loc{2, 3}
+	tests := []struct {
+		loc, expected Loc
+	}{
+		{Loc{0, 0}, Loc{0, 0}},
+		{Loc{3, 1}, Loc{3, 1}},
+		{Loc{4, 1}, Loc{2, 3}},
+		{Loc{7, 1}, Loc{5, 3}},
+		{Loc{1, 2}, Loc{1, 4}},
+	}
+	for _, test := range tests {
+		if got := shiftLoc(test.loc, end, newEnd); got != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.loc, test.expected, got)
+		}
+	}
+}
@@ -12,6 +12,7 @@ const (
 	RTSyntax      = "syntax"
 	RTHelp        = "help"
 	RTPlugin      = "plugin"
+	RTSnippet     = "snippets"
 )
 
 // RuntimeFile allows the program to read runtime data like colorschemes or syntax files
@@ -135,6 +136,7 @@ func InitRuntimeFiles() {
 	add(RTColorscheme, "colorschemes", "*.micro")
 	add(RTSyntax, "syntax", "*.yaml")
 	add(RTHelp, "help", "*.md")
+	add(RTSnippet, "snippets", "*.snippets")
 
 	// Search configDir for plugin-scripts
 	files, _ := ioutil.ReadDir(filepath.Join(configDir, "plugins"))
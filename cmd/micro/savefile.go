@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// A file is saved by writing a temporary file next to it, syncing that to the
+// disk and renaming it over the file, so that a crash or a full disk while
+// saving leaves either the old file or the new one. The temporary file is
+// first given the mode, owner and extended attributes of the old one. A file
+// which can't be replaced like that, because it has other hard links, its
+// owner can't be kept, or its directory can't be written to, is overwritten
+// instead.
+
+var errOwner = errors.New("The owner of the file can't be kept")
+
+// A replaceError is returned by writeReplacement when the temporary file
+// can't be created or renamed, in which case the file may still be
+// overwritten.
+type replaceError struct {
+	err error
+}
+
+func (e *replaceError) Error() string {
+	return e.err.Error()
+}
+
+// saveFile writes the file with fn. A symbolic link is followed, so that the
+// file it points to is written and the link is kept. If backup is true the old
+// file is kept as name~. overwrite is whether the file may be overwritten in
+// place when it can't be replaced.
+func saveFile(name string, backup, overwrite bool, fn func(io.Writer) error) error {
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return writeReplacement(name, nil, newFileMode(), false, fn)
+	}
+
+	if backup {
+		if err := backupFile(name); err != nil {
+			return errors.New("Can't back up " + name + ": " + err.Error())
+		}
+	}
+
+	if !overwrite {
+		return writeReplacement(name, info, 0, false, fn)
+	}
+	if hardLinks(info) > 1 {
+		return overwriteFile(name, fn)
+	}
+	err = writeReplacement(name, info, 0, true, fn)
+	if _, ok := err.(*replaceError); ok || err == errOwner {
+		return overwriteFile(name, fn)
+	}
+	return err
+}
+
+// replaceFile is like overwriteFile, except that the file is written under
+// another name and then renamed, so that the original isn't changed while
+// it's being read. mode is the mode of the file if it doesn't exist yet.
+func replaceFile(name string, mode os.FileMode, fn func(io.Writer) error) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		info = nil
+	}
+	return writeReplacement(name, info, mode, false, fn)
+}
+
+// writeReplacement writes a temporary file with fn and renames it to name.
+// info is the file which is replaced, if there is one, and the temporary file
+// is given its mode, owner and extended attributes, or mode if there isn't
+// one. If keepOwner is true and the owner can't be kept, nothing is written
+// and errOwner is returned.
+func writeReplacement(name string, info os.FileInfo, mode os.FileMode, keepOwner bool, fn func(io.Writer) error) (err error) {
+	file, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return &replaceError{err}
+	}
+
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	if info != nil {
+		if err = file.Chmod(info.Mode()); err != nil {
+			return
+		}
+		if e := chownFile(file, info); e != nil && keepOwner {
+			return errOwner
+		}
+		copyXattrs(name, file.Name())
+	} else if err = file.Chmod(mode); err != nil {
+		return
+	}
+
+	w := bufio.NewWriter(file)
+
+	if err = fn(w); err != nil {
+		return
+	}
+	if err = w.Flush(); err != nil {
+		return
+	}
+	if err = file.Sync(); err != nil {
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+
+	if err = os.Rename(file.Name(), name); err != nil {
+		return &replaceError{err}
+	}
+	// The rename itself is only on the disk once the directory is
+	syncDir(filepath.Dir(name))
+	return nil
+}
+
+// backupFile copies the file to name~
+func backupFile(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return replaceFile(name+"~", info.Mode().Perm(), func(w io.Writer) error {
+		_, err := io.Copy(w, file)
+		return err
+	})
+}
+
+// saveStdin saves what micro reads from its standard input to the file, when
+// it's run as another user to save a file which that user owns. The input is
+// read first, since the file may be written twice if it can't be replaced.
+func saveStdin(name string, backup bool) error {
+	text, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	return saveFile(name, backup, true, func(w io.Writer) error {
+		_, err := w.Write(text)
+		return err
+	})
+}
@@ -0,0 +1,19 @@
+// +build plan9 nacl windows
+
+package main
+
+import "os"
+
+func newFileMode() os.FileMode {
+	return 0666
+}
+
+func hardLinks(info os.FileInfo) uint64 {
+	return 1
+}
+
+func chownFile(file *os.File, info os.FileInfo) error {
+	return nil
+}
+
+func syncDir(name string) {}
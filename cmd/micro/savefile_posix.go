@@ -0,0 +1,46 @@
+// +build linux darwin dragonfly solaris openbsd netbsd freebsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// umask is the mask of the permissions which new files don't get. It's read
+// when micro starts, since it can only be read by changing it.
+var umask = func() os.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return os.FileMode(mask)
+}()
+
+// newFileMode returns the mode which a new file is created with
+func newFileMode() os.FileMode {
+	return 0666 &^ umask
+}
+
+// hardLinks returns the number of names the file has
+func hardLinks(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
+
+// chownFile gives the file the owner and group of the file described by info
+func chownFile(file *os.File, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return file.Chown(int(st.Uid), int(st.Gid))
+}
+
+// syncDir syncs the directory to the disk
+func syncDir(name string) {
+	if dir, err := os.Open(name); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSaveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(text string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, text)
+			return err
+		}
+	}
+	check := func(name, expected string) {
+		data, err := ioutil.ReadFile(name)
+		if err != nil || string(data) != expected {
+			t.Errorf("expected %s to contain %q, got %q (%v)", name, expected, data, err)
+		}
+	}
+
+	name := filepath.Join(dir, "file")
+	if err := saveFile(name, true, true, write("new")); err != nil {
+		t.Fatal(err)
+	}
+	check(name, "new")
+	if _, err := os.Stat(name + "~"); !os.IsNotExist(err) {
+		t.Errorf("expected no backup of a new file")
+	}
+	if info, _ := os.Stat(name); info.Mode().Perm() != newFileMode() {
+		t.Errorf("expected a new file to have mode %v, got %v", newFileMode(), info.Mode())
+	}
+
+	os.Chmod(name, 0640)
+	if err := saveFile(name, true, true, write("changed")); err != nil {
+		t.Fatal(err)
+	}
+	check(name, "changed")
+	check(name+"~", "new")
+	if info, _ := os.Stat(name + "~"); info.Mode().Perm() != 0640 {
+		t.Errorf("expected the backup to have the mode of the file, got %v", info.Mode())
+	}
+	if info, _ := os.Stat(name); info.Mode().Perm() != 0640 {
+		t.Errorf("expected the mode to be kept, got %v", info.Mode())
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	link := filepath.Join(dir, "link")
+	os.Symlink(name, link)
+	if err := saveFile(link, false, true, write("through link")); err != nil {
+		t.Fatal(err)
+	}
+	check(name, "through link")
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected the symbolic link to be kept")
+	}
+
+	// A file with another hard link is overwritten, so both names still
+	// have the same text
+	hard := filepath.Join(dir, "hard")
+	os.Link(name, hard)
+	if err := saveFile(name, false, true, write("both")); err != nil {
+		t.Fatal(err)
+	}
+	check(hard, "both")
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 4 {
+		t.Errorf("expected no temporary files to be left, got %d files", len(files))
+	}
+}
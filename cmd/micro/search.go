@@ -1,8 +1,10 @@
 package main
 
 import (
+	"io"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/zyedidia/tcell"
 )
@@ -47,6 +49,7 @@ func EndSearch() {
 // ExitSearch exits the search mode, reset active search phrase, and clear status bar
 func ExitSearch(v *View) {
 	lastSearch = ""
+	highlightedSearch = ""
 	searching = false
 	messenger.hasPrompt = false
 	messenger.Clear()
@@ -85,6 +88,7 @@ func HandleSearchEvent(event tcell.Event, v *View) {
 	}
 
 	if messenger.response == "" {
+		highlightedSearch = ""
 		v.Cursor.ResetSelection()
 		// We don't end the search though
 		return
@@ -97,88 +101,133 @@ func HandleSearchEvent(event tcell.Event, v *View) {
 	return
 }
 
-func searchDown(r *regexp.Regexp, v *View, start, end Loc) bool {
-	if start.Y >= v.Buf.NumLines {
-		start.Y = v.Buf.NumLines - 1
-	}
-	if start.Y < 0 {
-		start.Y = 0
-	}
-	for i := start.Y; i <= end.Y; i++ {
-		var l []byte
-		var charPos int
-		if i == start.Y {
-			runes := []rune(string(v.Buf.lines[i].data))
-			if start.X >= len(runes) {
-				start.X = len(runes) - 1
-			}
-			if start.X < 0 {
-				start.X = 0
-			}
-			l = []byte(string(runes[start.X:]))
-			charPos = start.X
-
-			if strings.Contains(r.String(), "^") && start.X != 0 {
-				continue
-			}
-		} else {
-			l = v.Buf.lines[i].data
-		}
+// searchUpLines is how many lines above the start of a search up are searched
+// first
+const searchUpLines = 64
 
-		match := r.FindIndex(l)
+// textReader reads the text of a buffer from a location to the end of a line,
+// with the lines joined by newlines, so that regexes can search it without it
+// having to be copied
+type textReader struct {
+	la   *LineArray
+	line []byte
+	x, y int
+	endY int
+}
 
-		if match != nil {
-			v.Cursor.SetSelectionStart(Loc{charPos + runePos(match[0], string(l)), i})
-			v.Cursor.SetSelectionEnd(Loc{charPos + runePos(match[1], string(l)), i})
-			v.Cursor.OrigSelection[0] = v.Cursor.CurSelection[0]
-			v.Cursor.OrigSelection[1] = v.Cursor.CurSelection[1]
-			v.Cursor.Loc = v.Cursor.CurSelection[1]
+func newTextReader(la *LineArray, start Loc, endY int) *textReader {
+	line := la.lineData(start.Y)
+	return &textReader{la, line, runeToByteIndex(start.X, line), start.Y, endY}
+}
 
-			return true
-		}
+// ReadRune reads the next rune, where invalid bytes are read one at a time
+func (r *textReader) ReadRune() (rune, int, error) {
+	if r.x < len(r.line) {
+		c, size := utf8.DecodeRune(r.line[r.x:])
+		r.x += size
+		return c, size, nil
+	}
+	if r.y >= r.endY {
+		return 0, 0, io.EOF
 	}
-	return false
+	r.y++
+	r.line, r.x = r.la.lineData(r.y), 0
+	return '\n', 1, nil
 }
 
-func searchUp(r *regexp.Regexp, v *View, start, end Loc) bool {
-	if start.Y >= v.Buf.NumLines {
-		start.Y = v.Buf.NumLines - 1
-	}
-	if start.Y < 0 {
-		start.Y = 0
-	}
-	for i := start.Y; i >= end.Y; i-- {
-		var l []byte
-		if i == start.Y {
-			runes := []rune(string(v.Buf.lines[i].data))
-			if start.X >= len(runes) {
-				start.X = len(runes) - 1
-			}
-			if start.X < 0 {
-				start.X = 0
-			}
-			l = []byte(string(runes[:start.X]))
-
-			if strings.Contains(r.String(), "$") && start.X != Count(string(l)) {
-				continue
-			}
-		} else {
-			l = v.Buf.lines[i].data
+// locAt returns the location offset bytes after start, where each line ends
+// with a newline
+func locAt(la *LineArray, start Loc, offset int) Loc {
+	line := la.lineData(start.Y)
+	x, y := runeToByteIndex(start.X, line), start.Y
+	for x+offset > len(line) {
+		offset -= len(line) - x + 1
+		y++
+		line, x = la.lineData(y), 0
+	}
+	return Loc{runePos(x+offset, string(line)), y}
+}
+
+// clampLoc moves loc into the buffer
+func clampLoc(loc Loc, buf *Buffer) Loc {
+	loc.Y = Max(Min(loc.Y, buf.NumLines-1), 0)
+	loc.X = Max(Min(loc.X, Count(buf.Line(loc.Y))), 0)
+	return loc
+}
+
+// selectMatch selects a match of a search, and moves the cursor to its end
+func selectMatch(v *View, start, end Loc) {
+	v.Cursor.SetSelectionStart(start)
+	v.Cursor.SetSelectionEnd(end)
+	v.Cursor.OrigSelection[0] = v.Cursor.CurSelection[0]
+	v.Cursor.OrigSelection[1] = v.Cursor.CurSelection[1]
+	v.Cursor.Loc = v.Cursor.CurSelection[1]
+}
+
+// findDown returns the first match of r from start to the end of line endY.
+// The text is read as it's searched, so matches can span lines.
+func findDown(r *regexp.Regexp, buf *Buffer, start Loc, endY int) (from, to Loc, ok bool) {
+	la := buf.LineArray
+	start = clampLoc(start, buf)
+	if strings.Contains(r.String(), "^") && start.X != 0 {
+		// The start would match ^ even though it isn't at the start of a line
+		if start.Y >= endY {
+			return from, to, false
 		}
+		start = Loc{0, start.Y + 1}
+	}
 
-		match := r.FindIndex(l)
+	match := r.FindReaderIndex(newTextReader(la, start, endY))
+	if match == nil {
+		return from, to, false
+	}
+	from = locAt(la, start, match[0])
+	return from, locAt(la, from, match[1]-match[0]), true
+}
 
-		if match != nil {
-			v.Cursor.SetSelectionStart(Loc{runePos(match[0], string(l)), i})
-			v.Cursor.SetSelectionEnd(Loc{runePos(match[1], string(l)), i})
-			v.Cursor.OrigSelection[0] = v.Cursor.CurSelection[0]
-			v.Cursor.OrigSelection[1] = v.Cursor.CurSelection[1]
-			v.Cursor.Loc = v.Cursor.CurSelection[1]
+// findUp returns the last match of r which ends before start and starts on or
+// after line endY. Regexes can only search forwards, so larger and larger
+// pieces of the text above start are searched until one has a match, rather
+// than searching each line again for each line above it.
+func findUp(r *regexp.Regexp, buf *Buffer, start Loc, endY int) (from, to Loc, ok bool) {
+	la := buf.LineArray
+	start = clampLoc(start, buf)
+	if strings.Contains(r.String(), "$") && start.X != Count(buf.Line(start.Y)) {
+		// The start would match $ even though it isn't at the end of a line
+		if start.Y <= endY {
+			return from, to, false
+		}
+		start = Loc{Count(buf.Line(start.Y - 1)), start.Y - 1}
+	}
 
-			return true
+	for n := searchUpLines; ; n *= 4 {
+		top := Loc{0, Max(start.Y-n, endY)}
+		matches := r.FindAllIndex([]byte(la.Substr(top, start)), -1)
+		if len(matches) > 0 {
+			match := matches[len(matches)-1]
+			from = locAt(la, top, match[0])
+			return from, locAt(la, from, match[1]-match[0]), true
 		}
+		if top.Y <= endY {
+			return from, to, false
+		}
+	}
+}
+
+func searchDown(r *regexp.Regexp, v *View, start, end Loc) bool {
+	from, to, ok := findDown(r, v.Buf, start, end.Y)
+	if ok {
+		selectMatch(v, from, to)
 	}
-	return false
+	return ok
+}
+
+func searchUp(r *regexp.Regexp, v *View, start, end Loc) bool {
+	from, to, ok := findUp(r, v.Buf, start, end.Y)
+	if ok {
+		selectMatch(v, from, to)
+	}
+	return ok
 }
 
 // Search searches in the view for the given regex. The down bool
@@ -188,10 +237,8 @@ func Search(searchStr string, v *View, down bool) {
 	if searchStr == "" {
 		return
 	}
-	r, err := regexp.Compile(searchStr)
-	if v.Buf.Settings["ignorecase"].(bool) {
-		r, err = regexp.Compile("(?i)" + searchStr)
-	}
+	highlightedSearch = searchStr
+	r, err := compileSearch(searchStr, v.Buf.Settings["ignorecase"].(bool))
 	if err != nil {
 		return
 	}
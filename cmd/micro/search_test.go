@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindDown(t *testing.T) {
+	b := NewBufferFromString("func f() {\n\tx := \"é\"\n\treturn x\n}\nfunc g() {\n\treturn\n}", "")
+	tests := []struct {
+		search   string
+		start    Loc
+		endY     int
+		from, to Loc
+		ok       bool
+	}{
+		{`func.*\n\s*return`, Loc{0, 0}, 6, Loc{0, 4}, Loc{7, 5}, true},
+		{`(?s)func.*?return`, Loc{0, 0}, 6, Loc{0, 0}, Loc{7, 2}, true},
+		{`"\n`, Loc{0, 0}, 6, Loc{8, 1}, Loc{0, 2}, true},
+		{`x`, Loc{2, 1}, 6, Loc{8, 2}, Loc{9, 2}, true},
+		{`^func`, Loc{1, 0}, 6, Loc{0, 4}, Loc{4, 4}, true},
+		{`g`, Loc{0, 0}, 3, Loc{}, Loc{}, false},
+		{`}$`, Loc{0, 0}, 6, Loc{0, 3}, Loc{1, 3}, true},
+	}
+	for _, test := range tests {
+		r, _ := compileSearch(test.search, false)
+		from, to, ok := findDown(r, b, test.start, test.endY)
+		if ok != test.ok || from != test.from || to != test.to {
+			t.Errorf("%s from %v: expected %v %v-%v, got %v %v-%v", test.search, test.start, test.ok, test.from, test.to, ok, from, to)
+		}
+	}
+}
+
+func TestFindUp(t *testing.T) {
+	b := NewBufferFromString("foo foo\nbar\nfoo\nbar baz", "")
+	tests := []struct {
+		search   string
+		start    Loc
+		endY     int
+		from, to Loc
+		ok       bool
+	}{
+		{`foo`, Loc{7, 3}, 0, Loc{0, 2}, Loc{3, 2}, true},
+		{`foo`, Loc{2, 2}, 0, Loc{4, 0}, Loc{7, 0}, true},
+		{`foo\nbar`, Loc{7, 3}, 0, Loc{0, 2}, Loc{3, 3}, true},
+		{`foo\nbar`, Loc{2, 3}, 0, Loc{4, 0}, Loc{3, 1}, true},
+		{`foo`, Loc{2, 2}, 1, Loc{}, Loc{}, false},
+		{`bar$`, Loc{3, 3}, 0, Loc{0, 1}, Loc{3, 1}, true},
+	}
+	for _, test := range tests {
+		r, _ := compileSearch(test.search, false)
+		from, to, ok := findUp(r, b, test.start, test.endY)
+		if ok != test.ok || from != test.from || to != test.to {
+			t.Errorf("%s from %v: expected %v %v-%v, got %v %v-%v", test.search, test.start, test.ok, test.from, test.to, ok, from, to)
+		}
+	}
+
+	// Matches far above the start are found once enough text is searched
+	b = NewBufferFromString("match\n"+strings.Repeat("line\n", 1000), "")
+	r, _ := compileSearch("match", false)
+	if from, _, ok := findUp(r, b, b.End(), 0); !ok || from != (Loc{0, 0}) {
+		t.Errorf("expected the match at the start to be found, got %v %v", ok, from)
+	}
+}
@@ -25,12 +25,20 @@ var invalidSettings bool
 
 // Options with validators
 var optionValidators = map[string]optionValidator{
-	"tabsize":      validatePositiveValue,
-	"scrollmargin": validateNonNegativeValue,
-	"scrollspeed":  validateNonNegativeValue,
-	"colorscheme":  validateColorscheme,
-	"colorcolumn":  validateNonNegativeValue,
-	"fileformat":   validateLineEnding,
+	"tabsize":               validatePositiveValue,
+	"scrollmargin":          validateNonNegativeValue,
+	"scrollspeed":           validateNonNegativeValue,
+	"colorscheme":           validateColorscheme,
+	"colorcolumn":           validateNonNegativeValue,
+	"fileformat":            validateLineEnding,
+	"encoding":              validateEncoding,
+	"autocompletelimit":     validatePositiveValue,
+	"autocompleteminlength": validateNonNegativeValue,
+	"autocompletedelay":     validateNonNegativeValue,
+	"largefilesize":         validateNonNegativeValue,
+	"saveundolimit":         validateNonNegativeValue,
+	"saveundosize":          validateNonNegativeValue,
+	"multiopen":             validateMultiOpen,
 }
 
 // InitGlobalSettings initializes the options map and sets all options to their default values
@@ -202,47 +210,62 @@ func GetOption(name string) interface{} {
 // Note that colorscheme is a global only option
 func DefaultGlobalSettings() map[string]interface{} {
 	return map[string]interface{}{
-		"autoindent":     true,
-		"autosave":       false,
-		"basename":       false,
-		"colorcolumn":    float64(0),
-		"colorscheme":    "default",
-		"cursorline":     true,
-		"eofnewline":     false,
-		"fastdirty":      true,
-		"fileformat":     "unix",
-		"hidehelp":       false,
-		"ignorecase":     false,
-		"indentchar":     " ",
-		"infobar":        true,
-		"keepautoindent": false,
-		"keymenu":        false,
-		"matchbrace":     false,
-		"matchbraceleft": false,
-		"mouse":          true,
-		"pluginchannels": []string{"https://raw.githubusercontent.com/micro-editor/plugin-channel/master/channel.json"},
-		"pluginrepos":    []string{},
-		"rmtrailingws":   false,
-		"ruler":          true,
-		"savecursor":     false,
-		"savehistory":    true,
-		"saveundo":       false,
-		"scrollbar":      false,
-		"scrollmargin":   float64(3),
-		"scrollspeed":    float64(2),
-		"softwrap":       false,
-		"smartpaste":     true,
-		"splitbottom":    true,
-		"splitright":     true,
-		"statusline":     true,
-		"sucmd":          "sudo",
-		"syntax":         true,
-		"tabmovement":    false,
-		"tabsize":        float64(4),
-		"tabstospaces":   false,
-		"termtitle":      false,
-		"useprimary":     true,
-		"autocomplete":   false,
+		"autoindent":               true,
+		"autosave":                 false,
+		"backup":                   false,
+		"basename":                 false,
+		"colorcolumn":              float64(0),
+		"colorscheme":              "default",
+		"cursorline":               true,
+		"eofnewline":               false,
+		"encoding":                 "utf-8",
+		"fastdirty":                true,
+		"fileformat":               "unix",
+		"hidehelp":                 false,
+		"hlsearch":                 true,
+		"ignorecase":               false,
+		"indentchar":               " ",
+		"infobar":                  true,
+		"keepautoindent":           false,
+		"keymenu":                  false,
+		"largefilesize":            float64(100),
+		"matchbrace":               false,
+		"matchbraceleft":           false,
+		"mouse":                    true,
+		"multiopen":                "tab",
+		"pluginchannels":           []string{"https://raw.githubusercontent.com/micro-editor/plugin-channel/master/channel.json"},
+		"pluginrepos":              []string{},
+		"rmtrailingws":             false,
+		"ruler":                    true,
+		"savecursor":               false,
+		"savehistory":              true,
+		"saveundo":                 false,
+		"saveundolimit":            float64(10000),
+		"saveundosize":             float64(1024),
+		"scrollbar":                false,
+		"scrollmargin":             float64(3),
+		"scrollspeed":              float64(2),
+		"softwrap":                 false,
+		"smartpaste":               true,
+		"splitbottom":              true,
+		"splitright":               true,
+		"statusline":               true,
+		"sucmd":                    "sudo",
+		"swapfile":                 true,
+		"syntax":                   true,
+		"tabmovement":              false,
+		"tabsize":                  float64(4),
+		"tabstospaces":             false,
+		"termtitle":                false,
+		"useprimary":               true,
+		"autocomplete":             false,
+		"autocompleteactivators":   "",
+		"autocompletedeactivators": "),;",
+		"autocompletedelay":        float64(50),
+		"autocompletedetail":       true,
+		"autocompletelimit":        float64(10),
+		"autocompleteminlength":    float64(1),
+		"autocompleteproject":      false,
 	}
 }
 
@@ -250,39 +273,46 @@ func DefaultGlobalSettings() map[string]interface{} {
 // Note that filetype is a local only option
 func DefaultLocalSettings() map[string]interface{} {
 	return map[string]interface{}{
-		"autoindent":     true,
-		"autosave":       false,
-		"basename":       false,
-		"colorcolumn":    float64(0),
-		"cursorline":     true,
-		"eofnewline":     false,
-		"fastdirty":      true,
-		"fileformat":     "unix",
-		"filetype":       "Unknown",
-		"hidehelp":       false,
-		"ignorecase":     false,
-		"indentchar":     " ",
-		"keepautoindent": false,
-		"matchbrace":     false,
-		"matchbraceleft": false,
-		"rmtrailingws":   false,
-		"ruler":          true,
-		"savecursor":     false,
-		"saveundo":       false,
-		"scrollbar":      false,
-		"scrollmargin":   float64(3),
-		"scrollspeed":    float64(2),
-		"softwrap":       false,
-		"smartpaste":     true,
-		"splitbottom":    true,
-		"splitright":     true,
-		"statusline":     true,
-		"syntax":         true,
-		"tabmovement":    false,
-		"tabsize":        float64(4),
-		"tabstospaces":   false,
-		"useprimary":     true,
-		"autocomplete":   false,
+		"autoindent":               true,
+		"autosave":                 false,
+		"backup":                   false,
+		"basename":                 false,
+		"colorcolumn":              float64(0),
+		"cursorline":               true,
+		"eofnewline":               false,
+		"encoding":                 "utf-8",
+		"fastdirty":                true,
+		"fileformat":               "unix",
+		"filetype":                 "Unknown",
+		"hidehelp":                 false,
+		"ignorecase":               false,
+		"indentchar":               " ",
+		"keepautoindent":           false,
+		"matchbrace":               false,
+		"matchbraceleft":           false,
+		"rmtrailingws":             false,
+		"ruler":                    true,
+		"savecursor":               false,
+		"saveundo":                 false,
+		"scrollbar":                false,
+		"scrollmargin":             float64(3),
+		"scrollspeed":              float64(2),
+		"softwrap":                 false,
+		"smartpaste":               true,
+		"splitbottom":              true,
+		"splitright":               true,
+		"statusline":               true,
+		"swapfile":                 true,
+		"syntax":                   true,
+		"tabmovement":              false,
+		"tabsize":                  float64(4),
+		"tabstospaces":             false,
+		"useprimary":               true,
+		"autocomplete":             false,
+		"autocompleteactivators":   "",
+		"autocompletedeactivators": "),;",
+		"autocompletedelay":        float64(50),
+		"autocompleteminlength":    float64(1),
 	}
 }
 
@@ -342,6 +372,14 @@ func SetOption(option, value string) error {
 		}
 	}
 
+	if isCompleterOption(option) {
+		for _, tab := range tabs {
+			for _, view := range tab.Views {
+				ReloadCompleter(view)
+			}
+		}
+	}
+
 	if option == "mouse" {
 		if !nativeValue.(bool) {
 			screen.DisableMouse()
@@ -439,12 +477,21 @@ func SetLocalOption(option, value string, view *View) error {
 		// LoadSyntaxFiles()
 		InitColorscheme()
 		buf.UpdateRules()
+		ReloadCompleter(view)
 	}
 
 	if option == "fileformat" {
+		buf.NormalizeLineEndings()
+	}
+
+	if option == "fileformat" || option == "encoding" {
 		buf.IsModified = true
 	}
 
+	if isCompleterOption(option) {
+		ReloadCompleter(view)
+	}
+
 	if option == "syntax" {
 		if !nativeValue.(bool) {
 			buf.ClearMatches()
@@ -528,6 +575,31 @@ func validateColorscheme(option string, value interface{}) error {
 	return nil
 }
 
+func validateEncoding(option string, value interface{}) error {
+	name, ok := value.(string)
+
+	if !ok {
+		return errors.New("Expected string type for encoding")
+	}
+
+	_, err := findEncoding(name)
+	return err
+}
+
+func validateMultiOpen(option string, value interface{}) error {
+	layout, ok := value.(string)
+
+	if !ok {
+		return errors.New("Expected string type for multiopen")
+	}
+
+	if layout != "tab" && layout != "vsplit" && layout != "hsplit" {
+		return errors.New("Multiopen must be 'tab', 'vsplit' or 'hsplit'")
+	}
+
+	return nil
+}
+
 func validateLineEnding(option string, value interface{}) error {
 	endingType, ok := value.(string)
 
@@ -535,8 +607,8 @@ func validateLineEnding(option string, value interface{}) error {
 		return errors.New("Expected string type for file format")
 	}
 
-	if endingType != "unix" && endingType != "dos" {
-		return errors.New("File format must be either 'unix' or 'dos'")
+	if _, ok := fileFormats[endingType]; !ok {
+		return errors.New("File format must be 'unix', 'dos' or 'mac'")
 	}
 
 	return nil
@@ -5,10 +5,15 @@ import (
 	"errors"
 	"os"
 	"regexp"
+	"strings"
 )
 
 var envRe = regexp.MustCompile(`\$({[a-zA-Z0-9_]+}|[a-zA-Z0-9_]+)`)
 
+// literalDollar stands for an escaped or single quoted $ until the environment
+// variables in a word have been replaced, so that it doesn't start one
+const literalDollar = "\x00"
+
 func isSpace(r rune) bool {
 	switch r {
 	case ' ', '\t', '\r', '\n':
@@ -18,13 +23,14 @@ func isSpace(r rune) bool {
 }
 
 func replaceEnv(s string) string {
-	return envRe.ReplaceAllStringFunc(s, func(s string) string {
+	s = envRe.ReplaceAllStringFunc(s, func(s string) string {
 		s = s[1:]
 		if s[0] == '{' {
 			s = s[1 : len(s)-1]
 		}
 		return os.Getenv(s)
 	})
+	return strings.Replace(s, literalDollar, "$", -1)
 }
 
 type Parser struct {
@@ -47,7 +53,11 @@ func (p *Parser) Parse(line string) ([]string, error) {
 loop:
 	for i, r := range line {
 		if escaped {
-			buf += string(r)
+			if r == '$' {
+				buf += literalDollar
+			} else {
+				buf += string(r)
+			}
 			escaped = false
 			continue
 		}
@@ -129,7 +139,11 @@ loop:
 		}
 
 		got = true
-		buf += string(r)
+		if r == '$' && singleQuoted {
+			buf += literalDollar
+		} else {
+			buf += string(r)
+		}
 		if backQuote || dollarQuote {
 			backtick += string(r)
 		}
@@ -0,0 +1,72 @@
+package snippet
+
+import (
+	"strings"
+)
+
+// Definition is a snippet which is expanded from a trigger word.
+type Definition struct {
+	Trigger     string
+	Description string
+	Body        string
+}
+
+// ParseFile parses a file of snippets in the snipMate format:
+//
+//	# A comment
+//	snippet trigger An optional description
+//		the body of the snippet, indented with a tab
+//		$0
+//
+// The tab at the start of each line of the body is removed. Later definitions
+// of the same trigger replace earlier ones.
+func ParseFile(data string) []Definition {
+	var defs []Definition
+	var current *Definition
+	var body []string
+	finish := func() {
+		if current != nil {
+			current.Body = strings.Join(body, "\n")
+			defs = append(defs, *current)
+			current = nil
+		}
+	}
+
+	for _, line := range strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n") {
+		switch {
+		case strings.HasPrefix(line, "snippet ") || line == "snippet":
+			finish()
+			fields := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "snippet")), " ", 2)
+			if fields[0] == "" {
+				continue
+			}
+			current = &Definition{Trigger: fields[0]}
+			if len(fields) > 1 {
+				current.Description = strings.TrimSpace(fields[1])
+			}
+			body = nil
+		case current != nil && strings.HasPrefix(line, "\t"):
+			body = append(body, line[1:])
+		case current != nil && line == "":
+			// Blank lines inside a body are kept, but not the ones at its end.
+			body = append(body, "")
+		default:
+			finish()
+		}
+	}
+	finish()
+
+	// Remove the blank lines at the ends of the bodies, and the duplicates.
+	seen := make(map[string]int)
+	var unique []Definition
+	for _, d := range defs {
+		d.Body = strings.TrimRight(d.Body, "\n")
+		if i, ok := seen[d.Trigger]; ok {
+			unique[i] = d
+			continue
+		}
+		seen[d.Trigger] = len(unique)
+		unique = append(unique, d)
+	}
+	return unique
+}
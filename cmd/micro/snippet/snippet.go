@@ -0,0 +1,235 @@
+// Package snippet parses snippets, which are templates of text with fields that
+// are filled in after the snippet has been inserted.
+//
+// The syntax is the one used by TextMate, snipMate and the Language Server
+// Protocol. $1, $2... or ${1}, ${2}... are tabstops, which the cursor visits
+// in order, and ${1:text} is a tabstop with placeholder text. When the same
+// number appears more than once, the other fields mirror the first one. $0 is
+// where the cursor is left at the end; if it's missing, it's the end of the
+// snippet. A $, } or \ can be written literally by escaping it with \.
+package snippet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Snippet is a parsed snippet.
+type Snippet struct {
+	// Text is the text of the snippet, with the placeholders filled in.
+	Text string
+	// Fields are the fields of the snippet, in the order in which they're
+	// visited. The last field is always the final cursor position, $0.
+	Fields []Field
+}
+
+// Field is a tabstop in a snippet, which may appear more than once.
+type Field struct {
+	Number int
+	// Ranges are where the field appears in the text, with the first one
+	// being where it's edited.
+	Ranges []Range
+}
+
+// Range is a part of the text of a snippet, as offsets in runes.
+type Range struct {
+	Start, End int
+}
+
+// Parse parses the body of a snippet.
+func Parse(body string) (*Snippet, error) {
+	p := &parser{body: []rune(body), placeholders: make(map[int]string)}
+	nodes, err := p.parse(false)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snippet{}
+	var text []rune
+	fields := make(map[int]*Field)
+	for _, n := range nodes {
+		if !n.tabstop {
+			text = append(text, []rune(n.text)...)
+			continue
+		}
+		f, ok := fields[n.number]
+		if !ok {
+			f = &Field{Number: n.number}
+			fields[n.number] = f
+		}
+		start := len(text)
+		text = append(text, []rune(p.placeholders[n.number])...)
+		f.Ranges = append(f.Ranges, Range{Start: start, End: len(text)})
+	}
+	s.Text = string(text)
+
+	if _, ok := fields[0]; !ok {
+		fields[0] = &Field{Ranges: []Range{{Start: len(text), End: len(text)}}}
+	}
+	for _, f := range fields {
+		s.Fields = append(s.Fields, *f)
+	}
+	sort.Slice(s.Fields, func(i, j int) bool {
+		a, b := s.Fields[i].Number, s.Fields[j].Number
+		if a == 0 || b == 0 {
+			return b == 0 && a != 0
+		}
+		return a < b
+	})
+	return s, nil
+}
+
+// Escape escapes the characters which have a meaning in snippets, so that text
+// is inserted as it is.
+func Escape(text string) string {
+	r := strings.NewReplacer(`\`, `\\`, `$`, `\$`, `}`, `\}`)
+	return r.Replace(text)
+}
+
+// Indent indents every line of the body of a snippet after the first with
+// indent, which is the indentation of the line that the snippet is inserted
+// on, and replaces the tabs at the start of lines with indentUnit.
+func Indent(body, indent, indentUnit string) string {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		tabs := len(line) - len(strings.TrimLeft(line, "\t"))
+		line = strings.Repeat(indentUnit, tabs) + line[tabs:]
+		if i > 0 {
+			line = indent + line
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// node is a piece of text or a tabstop in a parsed snippet.
+type node struct {
+	text    string
+	tabstop bool
+	number  int
+}
+
+type parser struct {
+	body []rune
+	pos  int
+	// placeholders are the texts of the tabstops, taken from the first
+	// occurrence of each one which has a placeholder.
+	placeholders map[int]string
+}
+
+// parse parses the body up to its end, or up to the closing brace of the
+// placeholder being parsed if inPlaceholder is true.
+func (p *parser) parse(inPlaceholder bool) (nodes []node, err error) {
+	var text []rune
+	flush := func() {
+		if len(text) > 0 {
+			nodes = append(nodes, node{text: string(text)})
+			text = nil
+		}
+	}
+	for p.pos < len(p.body) {
+		r := p.body[p.pos]
+		switch {
+		case r == '\\' && p.pos+1 < len(p.body) && strings.ContainsRune(`\$}`, p.body[p.pos+1]):
+			text = append(text, p.body[p.pos+1])
+			p.pos += 2
+		case r == '}' && inPlaceholder:
+			flush()
+			return nodes, nil
+		case r == '$':
+			n, ok, err := p.tabstop()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				text = append(text, r)
+				p.pos++
+				continue
+			}
+			flush()
+			nodes = append(nodes, n)
+		default:
+			text = append(text, r)
+			p.pos++
+		}
+	}
+	if inPlaceholder {
+		return nil, fmt.Errorf("snippet: missing } at the end of a placeholder")
+	}
+	flush()
+	return nodes, nil
+}
+
+// tabstop parses the tabstop at the current position, which is a $. If it
+// isn't a tabstop, ok is false and the position isn't changed.
+func (p *parser) tabstop() (n node, ok bool, err error) {
+	start := p.pos
+	p.pos++
+	braced := p.pos < len(p.body) && p.body[p.pos] == '{'
+	if braced {
+		p.pos++
+	}
+	number, digits := 0, 0
+	for ; p.pos < len(p.body) && p.body[p.pos] >= '0' && p.body[p.pos] <= '9'; p.pos++ {
+		number = number*10 + int(p.body[p.pos]-'0')
+		digits++
+	}
+	if digits == 0 {
+		p.pos = start
+		return node{}, false, nil
+	}
+	n = node{tabstop: true, number: number}
+	if !braced {
+		return n, true, nil
+	}
+
+	if p.pos >= len(p.body) {
+		return node{}, false, fmt.Errorf("snippet: missing } at the end of ${%d", number)
+	}
+	switch p.body[p.pos] {
+	case '}':
+		p.pos++
+	case ':':
+		p.pos++
+		nodes, err := p.parse(true)
+		if err != nil {
+			return node{}, false, err
+		}
+		p.pos++
+		if _, ok := p.placeholders[number]; !ok {
+			p.placeholders[number] = p.flatten(nodes)
+		}
+	case '|':
+		// A choice, such as ${1|one,two|}. The first choice is used as the placeholder.
+		end := p.pos + 1
+		for end+1 < len(p.body) && !(p.body[end] == '|' && p.body[end+1] == '}') {
+			end++
+		}
+		if end+1 >= len(p.body) {
+			return node{}, false, fmt.Errorf("snippet: missing |} at the end of a choice")
+		}
+		choices := string(p.body[p.pos+1 : end])
+		p.pos = end + 2
+		if _, ok := p.placeholders[number]; !ok {
+			p.placeholders[number] = strings.Split(choices, ",")[0]
+		}
+	default:
+		return node{}, false, fmt.Errorf("snippet: unexpected %q in ${%d", p.body[p.pos], number)
+	}
+	return n, true, nil
+}
+
+// flatten returns the text of nested nodes. Tabstops inside placeholders
+// aren't visited, but their placeholders are kept.
+func (p *parser) flatten(nodes []node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		if n.tabstop {
+			b.WriteString(p.placeholders[n.number])
+		} else {
+			b.WriteString(n.text)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package snippet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedText   string
+		expectedFields []Field
+		expectedErr    bool
+	}{
+		{
+			name:           "text without fields ends at the end",
+			body:           "hello",
+			expectedText:   "hello",
+			expectedFields: []Field{{Number: 0, Ranges: []Range{{5, 5}}}},
+		},
+		{
+			name:         "tabstops are visited in order, with $0 last",
+			body:         "$0 $2 ${1}",
+			expectedText: "  ",
+			expectedFields: []Field{
+				{Number: 1, Ranges: []Range{{2, 2}}},
+				{Number: 2, Ranges: []Range{{1, 1}}},
+				{Number: 0, Ranges: []Range{{0, 0}}},
+			},
+		},
+		{
+			name:         "placeholders are filled in",
+			body:         "func ${1:name}(${2:args}) {\n\t$0\n}",
+			expectedText: "func name(args) {\n\t\n}",
+			expectedFields: []Field{
+				{Number: 1, Ranges: []Range{{5, 9}}},
+				{Number: 2, Ranges: []Range{{10, 14}}},
+				{Number: 0, Ranges: []Range{{19, 19}}},
+			},
+		},
+		{
+			name:         "mirrors copy the placeholder",
+			body:         "$1 = ${1:x}",
+			expectedText: "x = x",
+			expectedFields: []Field{
+				{Number: 1, Ranges: []Range{{0, 1}, {4, 5}}},
+				{Number: 0, Ranges: []Range{{5, 5}}},
+			},
+		},
+		{
+			name:         "nested placeholders are flattened",
+			body:         "${1:a ${2:b}}",
+			expectedText: "a b",
+			expectedFields: []Field{
+				{Number: 1, Ranges: []Range{{0, 3}}},
+				{Number: 0, Ranges: []Range{{3, 3}}},
+			},
+		},
+		{
+			name:         "the first choice is the placeholder",
+			body:         "${1|one,two|}",
+			expectedText: "one",
+			expectedFields: []Field{
+				{Number: 1, Ranges: []Range{{0, 3}}},
+				{Number: 0, Ranges: []Range{{3, 3}}},
+			},
+		},
+		{
+			name:           "escaped and stray dollars are text",
+			body:           `\$1 $x \} \\`,
+			expectedText:   `$1 $x } \`,
+			expectedFields: []Field{{Number: 0, Ranges: []Range{{9, 9}}}},
+		},
+		{
+			name:         "offsets are counted in runes",
+			body:         "é${1:ü}",
+			expectedText: "éü",
+			expectedFields: []Field{
+				{Number: 1, Ranges: []Range{{1, 2}}},
+				{Number: 0, Ranges: []Range{{2, 2}}},
+			},
+		},
+		{
+			name:        "unclosed placeholders are an error",
+			body:        "${1:abc",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.body)
+		if test.expectedErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if s.Text != test.expectedText {
+			t.Errorf("%s: expected text %q, got %q", test.name, test.expectedText, s.Text)
+		}
+		if !reflect.DeepEqual(s.Fields, test.expectedFields) {
+			t.Errorf("%s: expected fields %v, got %v", test.name, test.expectedFields, s.Fields)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	text := `a$b}c\d`
+	s, err := Parse(Escape(text))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s.Text != text {
+		t.Errorf("expected %q, got %q", text, s.Text)
+	}
+}
+
+func TestIndent(t *testing.T) {
+	actual := Indent("if {\n\tx\n}", "  ", "    ")
+	expected := "if {\n      x\n  }"
+	if actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	data := `# Comments are ignored
+snippet if An if statement
+	if ${1:cond} {
+
+		$0
+	}
+
+snippet fn
+	func $1() {}
+snippet fn
+	function $1() {}
+`
+	expected := []Definition{
+		{Trigger: "if", Description: "An if statement", Body: "if ${1:cond} {\n\n\t$0\n}"},
+		{Trigger: "fn", Body: "function $1() {}"},
+	}
+	actual := ParseFile(data)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import "github.com/zyedidia/micro/cmd/micro/snippet"
+
+// loadedSnippets caches the snippets of each filetype by their trigger words.
+var loadedSnippets = make(map[string]map[string]snippet.Definition)
+
+// snippetBuffers stores the buffers which keep the snippet sessions of their
+// views up to date, so that each only has one change listener for them.
+var snippetBuffers = make(map[*Buffer]bool)
+
+// snippetsForFiletype returns the snippets defined in the runtime files of
+// the snippets type with the same name as the filetype.
+func snippetsForFiletype(filetype string) map[string]snippet.Definition {
+	if snippets, ok := loadedSnippets[filetype]; ok {
+		return snippets
+	}
+	snippets := make(map[string]snippet.Definition)
+	for _, f := range ListRuntimeFiles(RTSnippet) {
+		if f.Name() != filetype {
+			continue
+		}
+		data, err := f.Data()
+		if err != nil {
+			TermMessage("Error loading snippets:", err)
+			continue
+		}
+		for _, d := range snippet.ParseFile(string(data)) {
+			snippets[d.Trigger] = d
+		}
+	}
+	loadedSnippets[filetype] = snippets
+	return snippets
+}
+
+// locRange is a part of a buffer which moves as the buffer is edited.
+type locRange struct {
+	start, end Loc
+}
+
+// A snippetSession holds the fields of a snippet which has been inserted into a
+// view, while the cursor is moved between them.
+type snippetSession struct {
+	// fields holds the ranges of each field, in the order that they're visited.
+	// The first range of a field is edited by the main cursor, and the others
+	// by extra cursors. The last field is where the cursor is left at the end.
+	fields [][]*locRange
+	// current is the index of the field being edited.
+	current int
+	// extent is the whole of the snippet.
+	extent *locRange
+}
+
+// update moves the ranges after the text between start and end has been
+// replaced by inserted. Text inserted at the edge of the field being edited
+// becomes part of it.
+func (s *snippetSession) update(start, end Loc, inserted string) {
+	newEnd := start
+	for _, r := range inserted {
+		if r == '\n' {
+			newEnd = Loc{0, newEnd.Y + 1}
+		} else {
+			newEnd.X++
+		}
+	}
+	for i, field := range s.fields {
+		for _, r := range field {
+			r.move(start, end, newEnd, i == s.current)
+		}
+	}
+	s.extent.move(start, end, newEnd, true)
+}
+
+// move moves the range after the text between start and end has been replaced
+// by text which ends at newEnd. If extend is true, text inserted at the start
+// or end of the range is added to it, otherwise the range is moved past it.
+func (r *locRange) move(start, end, newEnd Loc, extend bool) {
+	r.start = moveLoc(r.start, start, end, newEnd, false, extend)
+	r.end = moveLoc(r.end, start, end, newEnd, true, extend)
+}
+
+func moveLoc(l, start, end, newEnd Loc, isEnd, extend bool) Loc {
+	switch {
+	case l.LessThan(start):
+		return l
+	case l == start && (start != end || extend):
+		if start == end && isEnd {
+			return newEnd
+		}
+		return l
+	case l.LessThan(end):
+		// The location was in the text which was replaced.
+		if isEnd {
+			return newEnd
+		}
+		return start
+	case l.Y == end.Y:
+		return Loc{newEnd.X + l.X - end.X, newEnd.Y}
+	}
+	return Loc{l.X, l.Y + newEnd.Y - end.Y}
+}
+
+// contains returns whether l is inside the snippet.
+func (s *snippetSession) contains(l Loc) bool {
+	return l.GreaterEqual(s.extent.start) && l.LessEqual(s.extent.end)
+}
+
+// gotoField selects the field with index i, with a cursor for each place that
+// it appears. The session ends when the last field is reached.
+func (s *snippetSession) gotoField(v *View, i int) {
+	s.current = i
+	v.Buf.clearCursors()
+	for j, r := range s.fields[i] {
+		c := &v.Buf.Cursor
+		if j > 0 {
+			c = &Cursor{buf: v.Buf}
+			v.Buf.cursors = append(v.Buf.cursors, c)
+		}
+		c.GotoLoc(r.end)
+		if r.start != r.end {
+			c.SetSelectionStart(r.start)
+			c.SetSelectionEnd(r.end)
+		}
+	}
+	v.Buf.UpdateCursors()
+	v.SetCursor(&v.Buf.Cursor)
+	if i == len(s.fields)-1 {
+		v.snippet = nil
+	}
+}
+
+// InsertSnippet replaces the text between from and to with a snippet, indented
+// to match the line that it's inserted on, and selects its first field.
+func (v *View) InsertSnippet(from, to Loc, body string) {
+	v.endSnippet()
+	indent := GetLeadingWhitespace(v.Buf.Line(from.Y))
+	s, err := snippet.Parse(snippet.Indent(body, indent, v.Buf.IndentString()))
+	if err != nil {
+		messenger.Error(err)
+		return
+	}
+
+	if from != to {
+		v.Buf.Remove(from, to)
+	}
+	v.Buf.Insert(from, s.Text)
+
+	session := &snippetSession{
+		extent: &locRange{from, from.Move(Count(s.Text), v.Buf)},
+	}
+	for _, f := range s.Fields {
+		var ranges []*locRange
+		for _, r := range f.Ranges {
+			ranges = append(ranges, &locRange{from.Move(r.Start, v.Buf), from.Move(r.End, v.Buf)})
+		}
+		session.fields = append(session.fields, ranges)
+	}
+	v.snippet = session
+
+	b := v.Buf
+	if !snippetBuffers[b] {
+		snippetBuffers[b] = true
+		b.AddChangeListener(func(start, end Loc, removed, inserted string) {
+			for _, t := range tabs {
+				for _, view := range t.Views {
+					if view.Buf == b && view.snippet != nil {
+						view.snippet.update(start, end, inserted)
+					}
+				}
+			}
+		})
+	}
+	session.gotoField(v, 0)
+}
+
+// snippetKeyForOtherCursor returns whether an action which moves between the
+// fields of the snippet is used up by a cursor other than the main one. Those
+// cursors edit copies of the field which the main cursor moves on from, so
+// while it's in the snippet the key mustn't do anything else for them.
+func (v *View) snippetKeyForOtherCursor() bool {
+	return v.snippet.contains(v.Buf.cursors[len(v.Buf.cursors)-1].Loc)
+}
+
+// endSnippet stops moving between the fields of the snippet being edited,
+// removing the cursors used to edit the copies of a field.
+func (v *View) endSnippet() {
+	if v.snippet == nil {
+		return
+	}
+	v.snippet = nil
+	if len(v.Buf.cursors) > 1 {
+		v.Buf.clearCursors()
+		v.SetCursor(&v.Buf.Cursor)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSnippetSessionUpdate(t *testing.T) {
+	// The snippet "${1:a} = ${2:b}$0" inserted at the start of a line.
+	newSession := func() *snippetSession {
+		return &snippetSession{
+			fields: [][]*locRange{
+				{{Loc{0, 0}, Loc{1, 0}}},
+				{{Loc{4, 0}, Loc{5, 0}}},
+				{{Loc{5, 0}, Loc{5, 0}}},
+			},
+			extent: &locRange{Loc{0, 0}, Loc{5, 0}},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		start, end Loc
+		inserted   string
+		expected   [][2]Loc
+	}{
+		{
+			name:     "typing at the end of the current field extends it",
+			start:    Loc{1, 0},
+			end:      Loc{1, 0},
+			inserted: "bc",
+			expected: [][2]Loc{{{0, 0}, {3, 0}}, {{6, 0}, {7, 0}}, {{7, 0}, {7, 0}}},
+		},
+		{
+			name:     "replacing the current field",
+			start:    Loc{0, 0},
+			end:      Loc{1, 0},
+			inserted: "",
+			expected: [][2]Loc{{{0, 0}, {0, 0}}, {{3, 0}, {4, 0}}, {{4, 0}, {4, 0}}},
+		},
+		{
+			name:     "new lines move the later fields down",
+			start:    Loc{1, 0},
+			end:      Loc{1, 0},
+			inserted: "\n",
+			expected: [][2]Loc{{{0, 0}, {0, 1}}, {{3, 1}, {4, 1}}, {{4, 1}, {4, 1}}},
+		},
+		{
+			name:     "text before the snippet moves it",
+			start:    Loc{0, 0},
+			end:      Loc{0, 0},
+			inserted: "x",
+			expected: [][2]Loc{{{0, 0}, {2, 0}}, {{5, 0}, {6, 0}}, {{6, 0}, {6, 0}}},
+		},
+	}
+
+	for _, test := range tests {
+		s := newSession()
+		s.update(test.start, test.end, test.inserted)
+		for i, field := range s.fields {
+			actual := [2]Loc{field[0].start, field[0].end}
+			if actual != test.expected[i] {
+				t.Errorf("%s: expected field %d at %v, got %v", test.name, i, test.expected[i], actual)
+			}
+		}
+	}
+}
+
+func TestSnippetSessionUpdateOtherFields(t *testing.T) {
+	// Text typed at the start of the field after the current one isn't added to it.
+	s := &snippetSession{
+		fields: [][]*locRange{
+			{{Loc{0, 0}, Loc{1, 0}}},
+			{{Loc{1, 0}, Loc{2, 0}}},
+		},
+		extent: &locRange{Loc{0, 0}, Loc{2, 0}},
+	}
+	s.update(Loc{1, 0}, Loc{1, 0}, "x")
+	if r := s.fields[0][0]; r.end != (Loc{2, 0}) {
+		t.Errorf("expected the current field to end at 2, got %v", r.end)
+	}
+	if r := s.fields[1][0]; r.start != (Loc{2, 0}) || r.end != (Loc{3, 0}) {
+		t.Errorf("expected the next field to move to 2-3, got %v-%v", r.start, r.end)
+	}
+}
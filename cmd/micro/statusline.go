@@ -31,6 +31,10 @@ func (sline *Statusline) Display() {
 	if sline.view.Buf.Modified() {
 		file += " +"
 	}
+	// A large file which can't be read any more has to be reopened to save it
+	if sline.view.Buf.fileError() != nil {
+		file += " (changed on disk, reopen to save)"
+	}
 
 	// Add one to cursor.x and cursor.y because (0,0) is the top left,
 	// but users will be used to (1,1) (first line,first column)
@@ -45,6 +49,23 @@ func (sline *Statusline) Display() {
 	file += " " + sline.view.Buf.FileType()
 
 	file += " " + sline.view.Buf.Settings["fileformat"].(string)
+	if sline.view.Buf.MixedLineEndings() {
+		file += " (mixed)"
+	}
+	file += " " + sline.view.Buf.Settings["encoding"].(string)
+
+	if matches := sline.view.Buf.searchMatches(); matches != nil {
+		loc := sline.view.Cursor.Loc
+		if sline.view.Cursor.HasSelection() {
+			loc = sline.view.Cursor.CurSelection[0]
+			if sline.view.Cursor.CurSelection[1].LessThan(loc) {
+				loc = sline.view.Cursor.CurSelection[1]
+			}
+		}
+		if total, before, ok := matches.count(sline.view.Buf, loc); ok && total > 0 {
+			file += " match " + strconv.Itoa(before) + "/" + strconv.Itoa(total)
+		}
+	}
 
 	rightText := ""
 	if !sline.view.Buf.Settings["hidehelp"].(bool) {
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"container/heap"
+	"crypto/md5"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// The undo tree and the cursor position of a buffer are saved in
+// configDir/buffers for the saveundo and savecursor options. The file starts
+// with a header naming its format, and the rest is kept apart from the types
+// used while editing, so that they can change without misreading old files.
+
+const (
+	undoFileMagic   = "micro undo"
+	undoFileVersion = 1
+)
+
+type undoFileHeader struct {
+	Magic   string
+	Version int
+}
+
+// undoFile is what's saved for a buffer
+type undoFile struct {
+	// Hash is the md5 hash of the text of the buffer when it was saved. The
+	// history is only used if the file still has this text.
+	Hash   [md5.Size]byte
+	Cursor Loc
+
+	// Nodes and Current are the undo tree, which is empty if saveundo is off
+	Nodes   []undoFileNode
+	Current int
+}
+
+type undoFileNode struct {
+	Parent    int
+	Redo      int
+	Time      time.Time
+	EventType int
+	Deltas    []undoFileDelta
+	Endings   []lineEnding
+	Cursor    undoFileCursor
+}
+
+type undoFileDelta struct {
+	Text       string
+	Start, End Loc
+}
+
+type undoFileCursor struct {
+	Num                         int
+	Loc                         Loc
+	LastVisualX                 int
+	CurSelection, OrigSelection [2]Loc
+}
+
+// undoFilePath returns the file which the buffer's history is saved in
+func (b *Buffer) undoFilePath() string {
+	return configDir + "/buffers/" + EscapePath(b.AbsPath)
+}
+
+// Serialize serializes the buffer to configDir/buffers
+func (b *Buffer) Serialize() error {
+	if !b.Settings["savecursor"].(bool) && !b.Settings["saveundo"].(bool) {
+		return nil
+	}
+
+	f := undoFile{Cursor: b.Cursor.Loc}
+	// The history of a large file can't be checked without reading all of it
+	if b.Settings["saveundo"].(bool) && !b.LargeFile {
+		calcHash(b, &f.Hash)
+		maxEvents, _ := globalSettings["saveundolimit"].(float64)
+		maxKB, _ := globalSettings["saveundosize"].(float64)
+		tree := compactUndoTree(b.Tree, int(maxEvents), int(maxKB)*1024)
+		f.Nodes, f.Current = encodeUndoTree(tree), tree.Current
+	}
+
+	return overwriteFile(b.undoFilePath(), func(file io.Writer) error {
+		enc := gob.NewEncoder(file)
+		if err := enc.Encode(undoFileHeader{undoFileMagic, undoFileVersion}); err != nil {
+			return err
+		}
+		return enc.Encode(f)
+	})
+}
+
+// loadUndoFile restores the cursor and the undo tree saved for the buffer.
+// Files which can't be read are removed, and are only mentioned in the log,
+// since the history isn't worth interrupting the user for.
+func (b *Buffer) loadUndoFile() {
+	name := b.undoFilePath()
+	file, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	f, err := readUndoFile(file)
+	file.Close()
+	if err != nil {
+		messenger.AddLog("Discarding the saved history of ", b.Path, ": ", err.Error())
+		os.Remove(name)
+		return
+	}
+
+	if b.Settings["savecursor"].(bool) {
+		b.Cursor.GotoLoc(f.Cursor)
+		b.Cursor.Relocate()
+	}
+
+	if b.Settings["saveundo"].(bool) && len(f.Nodes) > 0 && !b.LargeFile {
+		// The history only fits the text it was saved with
+		var hash [md5.Size]byte
+		calcHash(b, &hash)
+		if hash != f.Hash {
+			return
+		}
+		b.EventHandler.Tree = decodeUndoTree(f.Nodes, f.Current)
+	}
+}
+
+// readUndoFile reads and checks an undo file
+func readUndoFile(r io.Reader) (*undoFile, error) {
+	dec := gob.NewDecoder(r)
+	var header undoFileHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, err
+	}
+	if header.Magic != undoFileMagic {
+		return nil, errors.New("not an undo file")
+	}
+	if header.Version != undoFileVersion {
+		return nil, errors.New("unknown undo file version")
+	}
+
+	f := new(undoFile)
+	if err := dec.Decode(f); err != nil {
+		return nil, err
+	}
+
+	if len(f.Nodes) > 0 && (f.Nodes[0].Parent != -1 || f.Current < 0 || f.Current >= len(f.Nodes)) {
+		return nil, errors.New("invalid undo tree")
+	}
+	for i, n := range f.Nodes {
+		// Parents are always made before their children
+		if i > 0 && (n.Parent < 0 || n.Parent >= i) {
+			return nil, errors.New("invalid undo tree")
+		}
+		if n.Redo != -1 && (n.Redo <= i || n.Redo >= len(f.Nodes) || f.Nodes[n.Redo].Parent != i) {
+			return nil, errors.New("invalid undo tree")
+		}
+	}
+	return f, nil
+}
+
+// encodeUndoTree converts an undo tree to the nodes saved in undo files
+func encodeUndoTree(tree *UndoTree) []undoFileNode {
+	nodes := make([]undoFileNode, len(tree.Nodes))
+	for i, n := range tree.Nodes {
+		nodes[i] = undoFileNode{Parent: n.Parent, Redo: n.Redo, Time: n.Time}
+		if t := n.Event; t != nil {
+			nodes[i].EventType = t.EventType
+			for _, d := range t.Deltas {
+				nodes[i].Deltas = append(nodes[i].Deltas, undoFileDelta{d.Text, d.Start, d.End})
+			}
+			nodes[i].Endings = t.Endings
+			nodes[i].Cursor = undoFileCursor{t.C.Num, t.C.Loc, t.C.LastVisualX, t.C.CurSelection, t.C.OrigSelection}
+		}
+	}
+	return nodes
+}
+
+// decodeUndoTree converts the nodes saved in an undo file to an undo tree
+func decodeUndoTree(nodes []undoFileNode, current int) *UndoTree {
+	tree := &UndoTree{Nodes: make([]*UndoNode, len(nodes)), Current: current}
+	for i, n := range nodes {
+		node := &UndoNode{Parent: n.Parent, Redo: n.Redo, Time: n.Time}
+		if i > 0 {
+			t := &TextEvent{EventType: n.EventType, Endings: n.Endings, Time: n.Time}
+			for _, d := range n.Deltas {
+				t.Deltas = append(t.Deltas, Delta{d.Text, d.Start, d.End})
+			}
+			c := n.Cursor
+			t.C = Cursor{Loc: c.Loc, LastVisualX: c.LastVisualX, CurSelection: c.CurSelection, OrigSelection: c.OrigSelection, Num: c.Num}
+			node.Event = t
+			parent := tree.Nodes[n.Parent]
+			parent.Children = append(parent.Children, i)
+		}
+		tree.Nodes[i] = node
+	}
+	return tree
+}
+
+// undoStates is a heap of states, oldest first
+type undoStates []int
+
+func (s undoStates) Len() int            { return len(s) }
+func (s undoStates) Less(i, j int) bool  { return s[i] < s[j] }
+func (s undoStates) Swap(i, j int)       { s[i], s[j] = s[j], s[i] }
+func (s *undoStates) Push(x interface{}) { *s = append(*s, x.(int)) }
+func (s *undoStates) Pop() interface{} {
+	old := *s
+	x := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return x
+}
+
+// compactUndoTree returns a copy of the tree without its oldest states, so
+// that it has no more than maxEvents events and maxBytes bytes of text in
+// them. A limit of 0 means no limit. The branches which were undone go first,
+// oldest state first, and then the oldest changes which led to the current
+// state.
+func compactUndoTree(tree *UndoTree, maxEvents, maxBytes int) *UndoTree {
+	size := func(i int) (n int) {
+		if t := tree.Nodes[i].Event; t != nil {
+			for _, d := range t.Deltas {
+				n += len(d.Text)
+			}
+			n += len(t.Endings)
+		}
+		return n
+	}
+	events, bytes := len(tree.Nodes)-1, 0
+	for i := range tree.Nodes {
+		bytes += size(i)
+	}
+	over := func() bool {
+		return (maxEvents > 0 && events > maxEvents) || (maxBytes > 0 && bytes > maxBytes)
+	}
+	if !over() {
+		return tree
+	}
+
+	removed := make([]bool, len(tree.Nodes))
+	remove := func(i int) {
+		removed[i] = true
+		events--
+		bytes -= size(i)
+	}
+
+	path := tree.onCurrentPath()
+	children := make([]int, len(tree.Nodes))
+	leaves := &undoStates{}
+	for i, n := range tree.Nodes {
+		children[i] = len(n.Children)
+		if len(n.Children) == 0 && !path[i] {
+			*leaves = append(*leaves, i)
+		}
+	}
+	heap.Init(leaves)
+	for over() && leaves.Len() > 0 {
+		i := heap.Pop(leaves).(int)
+		remove(i)
+		p := tree.Nodes[i].Parent
+		if children[p]--; children[p] == 0 && !path[p] {
+			heap.Push(leaves, p)
+		}
+	}
+
+	// Only the current path is left, so the first state is moved down it
+	root := 0
+	for over() && root != tree.Current {
+		removed[root] = true
+		for _, c := range tree.Nodes[root].Children {
+			if path[c] {
+				root = c
+			}
+		}
+		// There's nothing before the new first state to undo to
+		events--
+		bytes -= size(root)
+	}
+
+	// Number the states which are left
+	index := make([]int, len(tree.Nodes))
+	compacted := &UndoTree{}
+	for i, n := range tree.Nodes {
+		index[i] = -1
+		if removed[i] {
+			continue
+		}
+		index[i] = len(compacted.Nodes)
+		node := &UndoNode{Event: n.Event, Parent: -1, Redo: -1, Time: n.Time}
+		if i != root {
+			node.Parent = index[n.Parent]
+			parent := compacted.Nodes[node.Parent]
+			parent.Children = append(parent.Children, index[i])
+		} else {
+			node.Event = nil
+		}
+		compacted.Nodes = append(compacted.Nodes, node)
+	}
+	for i, n := range tree.Nodes {
+		if !removed[i] {
+			node := compacted.Nodes[index[i]]
+			if n.Redo >= 0 && !removed[n.Redo] {
+				node.Redo = index[n.Redo]
+			} else if len(node.Children) > 0 {
+				node.Redo = node.Children[len(node.Children)-1]
+			}
+		}
+	}
+	compacted.Current = index[tree.Current]
+	return compacted
+}
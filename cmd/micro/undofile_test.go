@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+)
+
+func writeTestUndoFile(t *testing.T, header undoFileHeader, f undoFile) *bytes.Buffer {
+	var b bytes.Buffer
+	enc := gob.NewEncoder(&b)
+	if err := enc.Encode(header); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(f); err != nil {
+		t.Fatal(err)
+	}
+	return &b
+}
+
+func TestUndoFileRoundTrip(t *testing.T) {
+	tree := newTestUndoTree(time.Now(), time.Second, 2*time.Second)
+	tree.undo()
+	tree.add(&TextEvent{EventType: TextEventRemove, Deltas: []Delta{{"y", Loc{0, 0}, Loc{1, 0}}}, Time: time.Now()})
+
+	header := undoFileHeader{undoFileMagic, undoFileVersion}
+	b := writeTestUndoFile(t, header, undoFile{Cursor: Loc{2, 3}, Nodes: encodeUndoTree(tree), Current: tree.Current})
+	f, err := readUndoFile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Cursor != (Loc{2, 3}) {
+		t.Errorf("expected the cursor at (2, 3), got %v", f.Cursor)
+	}
+
+	decoded := decodeUndoTree(f.Nodes, f.Current)
+	if decoded.Current != tree.Current || len(decoded.Nodes) != len(tree.Nodes) {
+		t.Fatalf("expected %d states with %d current, got %d with %d current", len(tree.Nodes), tree.Current, len(decoded.Nodes), decoded.Current)
+	}
+	for i, n := range tree.Nodes {
+		d := decoded.Nodes[i]
+		if d.Parent != n.Parent || d.Redo != n.Redo || len(d.Children) != len(n.Children) || (d.Event == nil) != (n.Event == nil) {
+			t.Errorf("state %d: expected %+v, got %+v", i, n, d)
+		}
+	}
+	if e := decoded.Nodes[3].Event; e.EventType != TextEventRemove || len(e.Deltas) != 1 || e.Deltas[0].Text != "y" || e.Deltas[0].End != (Loc{1, 0}) {
+		t.Errorf("expected the removal of \"y\" in state 3, got %+v", e)
+	}
+}
+
+func TestUndoFileInvalid(t *testing.T) {
+	valid := undoFileHeader{undoFileMagic, undoFileVersion}
+	nodes := encodeUndoTree(newTestUndoTree(time.Now(), time.Second))
+
+	badParent := append([]undoFileNode(nil), nodes...)
+	badParent[1].Parent = 1
+	badRedo := append([]undoFileNode(nil), nodes...)
+	badRedo[0].Redo = 5
+
+	tests := []struct {
+		name   string
+		header undoFileHeader
+		f      undoFile
+	}{
+		{"magic", undoFileHeader{"something else", undoFileVersion}, undoFile{}},
+		{"version", undoFileHeader{undoFileMagic, undoFileVersion + 1}, undoFile{}},
+		{"current", valid, undoFile{Nodes: nodes, Current: 2}},
+		{"parent", valid, undoFile{Nodes: badParent}},
+		{"redo", valid, undoFile{Nodes: badRedo}},
+	}
+	for _, test := range tests {
+		if _, err := readUndoFile(writeTestUndoFile(t, test.header, test.f)); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	// Truncated and garbled files
+	b := writeTestUndoFile(t, valid, undoFile{Nodes: nodes, Current: 1}).Bytes()
+	if _, err := readUndoFile(bytes.NewReader(b[:len(b)/2])); err == nil {
+		t.Errorf("expected an error for a truncated file")
+	}
+	if _, err := readUndoFile(bytes.NewReader([]byte("not gob at all"))); err == nil {
+		t.Errorf("expected an error for a garbled file")
+	}
+}
+
+func TestCompactUndoTree(t *testing.T) {
+	start := time.Now()
+	tree := newTestUndoTree(start, time.Second, 2*time.Second, 3*time.Second)
+	// Make a branch from state 1 and go back to state 3, so that state 4 is
+	// the only state off the current path
+	tree.undo()
+	tree.undo()
+	tree.add(&TextEvent{EventType: TextEventInsert, Deltas: []Delta{{"x", Loc{}, Loc{}}}, Time: start.Add(4 * time.Second)})
+	tree.undo()
+	tree.Nodes[1].Redo = 2
+	tree.redo()
+	tree.redo()
+
+	if same := compactUndoTree(tree, 0, 0); same != tree {
+		t.Errorf("expected a tree within the limits to be left alone")
+	}
+
+	// Dropping one event drops the undone branch
+	compacted := compactUndoTree(tree, 3, 0)
+	if len(compacted.Nodes) != 4 || compacted.Current != 3 {
+		t.Fatalf("expected 4 states with 3 current, got %d with %d current", len(compacted.Nodes), compacted.Current)
+	}
+	if len(compacted.Nodes[1].Children) != 1 || compacted.Nodes[1].Redo != 2 {
+		t.Errorf("expected state 1 to have just the current branch, got %v redoing to %d", compacted.Nodes[1].Children, compacted.Nodes[1].Redo)
+	}
+
+	// Dropping two more moves the first state down the current path
+	compacted = compactUndoTree(tree, 1, 0)
+	if len(compacted.Nodes) != 2 || compacted.Current != 1 {
+		t.Fatalf("expected 2 states with 1 current, got %d with %d current", len(compacted.Nodes), compacted.Current)
+	}
+	root := compacted.Nodes[0]
+	if root.Event != nil || root.Parent != -1 || root.Redo != 1 || compacted.Nodes[1].Parent != 0 {
+		t.Errorf("expected a first state without an event which redoes to state 1, got %+v", root)
+	}
+	if root.Time != tree.Nodes[2].Time {
+		t.Errorf("expected the first state to be the old state 2")
+	}
+
+	// Each event holds one byte
+	compacted = compactUndoTree(tree, 0, 2)
+	if len(compacted.Nodes) != 3 {
+		t.Errorf("expected 3 states for 2 bytes, got %d", len(compacted.Nodes))
+	}
+
+	// One byte leaves just the current state
+	compacted = compactUndoTree(tree, 0, 1)
+	if len(compacted.Nodes) != 2 || compacted.Nodes[compacted.Current].Time != tree.Nodes[3].Time {
+		t.Errorf("expected the current state to be kept, got %d states", len(compacted.Nodes))
+	}
+}
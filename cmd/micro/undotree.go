@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zyedidia/tcell"
+)
+
+// An UndoTree holds every state that a buffer has been in. Making a change
+// after undoing adds a new branch, so the states which were undone can still
+// be gone back to.
+type UndoTree struct {
+	// Nodes holds the states in the order they were made. The first one is the
+	// state the buffer was opened in.
+	Nodes []*UndoNode
+	// Current is the index of the state the buffer is in
+	Current int
+}
+
+// An UndoNode is a state in an undo tree. The nodes refer to each other by
+// their index, so that the tree can be serialized.
+type UndoNode struct {
+	// Event changes the parent state into this one. Undoing it reverses it in
+	// place, so it changes this state into the parent while this state isn't
+	// the current one or one of its ancestors.
+	Event *TextEvent
+	// Parent is the state this one was made from, or -1 for the first state
+	Parent int
+	// Children are the states made from this one, oldest first
+	Children []int
+	// Redo is the child which redo goes to, the one most recently made or
+	// undone, or -1 if there are no children
+	Redo int
+	// Time is when the state was made
+	Time time.Time
+}
+
+// NewUndoTree returns an undo tree holding just the current state
+func NewUndoTree() *UndoTree {
+	return &UndoTree{
+		Nodes: []*UndoNode{{Parent: -1, Redo: -1, Time: time.Now()}},
+	}
+}
+
+// add adds the state made by t from the current one, and makes it current
+func (ut *UndoTree) add(t *TextEvent) {
+	n := len(ut.Nodes)
+	parent := ut.Nodes[ut.Current]
+	parent.Children = append(parent.Children, n)
+	parent.Redo = n
+	ut.Nodes = append(ut.Nodes, &UndoNode{Event: t, Parent: ut.Current, Redo: -1, Time: t.Time})
+	ut.Current = n
+}
+
+// undoEvent returns the event which undo reverses, or nil at the first state
+func (ut *UndoTree) undoEvent() *TextEvent {
+	return ut.Nodes[ut.Current].Event
+}
+
+// redoEvent returns the event which redo applies, or nil if there is none
+func (ut *UndoTree) redoEvent() *TextEvent {
+	if redo := ut.Nodes[ut.Current].Redo; redo >= 0 {
+		return ut.Nodes[redo].Event
+	}
+	return nil
+}
+
+// undo moves to the parent of the current state
+func (ut *UndoTree) undo() {
+	parent := ut.Nodes[ut.Current].Parent
+	ut.Nodes[parent].Redo = ut.Current
+	ut.Current = parent
+}
+
+// redo moves to the child which redo goes to
+func (ut *UndoTree) redo() {
+	ut.Current = ut.Nodes[ut.Current].Redo
+}
+
+// path returns the states which are undone, starting with the current one,
+// and then the states which are redone to get to target
+func (ut *UndoTree) path(target int) (undo, redo []int) {
+	ancestors := make(map[int]bool)
+	for n := target; n >= 0; n = ut.Nodes[n].Parent {
+		ancestors[n] = true
+	}
+	n := ut.Current
+	for ; !ancestors[n]; n = ut.Nodes[n].Parent {
+		undo = append(undo, n)
+	}
+	for m := target; m != n; m = ut.Nodes[m].Parent {
+		redo = append(redo, m)
+	}
+	for i, j := 0, len(redo)-1; i < j; i, j = i+1, j-1 {
+		redo[i], redo[j] = redo[j], redo[i]
+	}
+	return undo, redo
+}
+
+// atTime returns the latest state which was made no later than t, or the first
+// state if they all were
+func (ut *UndoTree) atTime(t time.Time) int {
+	i := sort.Search(len(ut.Nodes), func(i int) bool {
+		return ut.Nodes[i].Time.After(t)
+	})
+	return Max(i-1, 0)
+}
+
+// sameStep returns whether state b was made from a without a pause in typing,
+// so that they're undone together
+func (ut *UndoTree) sameStep(a, b int) bool {
+	return a > 0 && ut.Nodes[b].Parent == a &&
+		ut.Nodes[b].Time.Sub(ut.Nodes[a].Time) <= undoThreshold*time.Millisecond
+}
+
+// onCurrentPath returns the states from the first one to the current one
+func (ut *UndoTree) onCurrentPath() map[int]bool {
+	path := make(map[int]bool)
+	for n := ut.Current; n >= 0; n = ut.Nodes[n].Parent {
+		path[n] = true
+	}
+	return path
+}
+
+// describe summarizes the change which made a state. applied is whether its
+// event is the right way round, rather than reversed by undo.
+func (n *UndoNode) describe(applied bool) string {
+	t := n.Event
+	if t == nil {
+		return "original"
+	}
+	if t.Endings != nil {
+		return "line endings"
+	}
+	eventType := t.EventType
+	if !applied {
+		eventType = -eventType
+	}
+	var text []string
+	for _, d := range t.Deltas {
+		text = append(text, d.Text)
+	}
+	summary := []rune(fmt.Sprintf("%q", strings.Join(text, "")))
+	if len(summary) > 40 {
+		summary = append(summary[:37], []rune("...")...)
+	}
+	switch eventType {
+	case TextEventInsert:
+		return "insert " + string(summary)
+	case TextEventRemove:
+		return "remove " + string(summary)
+	}
+	return fmt.Sprintf("replace %d", len(t.Deltas))
+}
+
+// An UndoBrowser shows the undo tree of a buffer in another view, where the
+// buffer can be put into any state by pressing enter on it
+type UndoBrowser struct {
+	view *View
+	buf  *Buffer
+
+	// states holds the state shown on each line, or -1 for other lines
+	states []int
+	// shown is the state and size of the tree when it was last shown, so that
+	// it's shown again when they change
+	shownCurrent, shownLen int
+	// closed is set once the buffer is no longer open in any other view
+	closed bool
+}
+
+// OpenUndoBrowser opens a split showing the undo tree of the view's buffer
+func (v *View) OpenUndoBrowser() {
+	buf := NewBufferFromString("", "")
+	buf.name = "Undo tree"
+	v.HSplit(buf)
+	view := CurView()
+	view.Type = vtUndo
+	view.undoBrowser = &UndoBrowser{view: view, buf: v.Buf}
+	view.undoBrowser.Refresh()
+}
+
+// Refresh shows the undo tree again if it has changed since it was last shown.
+// Once the buffer has been closed, the browser just says so, since its tree
+// can't be used any more.
+func (u *UndoBrowser) Refresh() {
+	if u.closed {
+		return
+	}
+	if !isOpenInOtherView(u.buf, u.view) {
+		u.closed = true
+		u.states = nil
+		u.show(u.buf.GetName()+" has been closed\nPress q to close the undo tree", 0)
+		return
+	}
+
+	tree := u.buf.Tree
+	if u.states != nil && tree.Current == u.shownCurrent && len(tree.Nodes) == u.shownLen {
+		return
+	}
+	u.shownCurrent, u.shownLen = tree.Current, len(tree.Nodes)
+
+	text, states, current := renderUndoTree(tree, u.buf.GetName())
+	u.states = states
+	u.show(text, current)
+}
+
+// show replaces the text of the browser, and puts the cursor on the given line.
+func (u *UndoBrowser) show(text string, line int) {
+	buf := u.view.Buf
+	buf.LineArray = NewLineArray(int64(len(text)), strings.NewReader(text))
+	buf.Update()
+	buf.Cursor.GotoLoc(Loc{0, line})
+	u.view.Relocate()
+}
+
+// HandleEvent handles an event in the browser, and returns whether it was
+// used. Enter goes to the state on the cursor's line, and escape or q closes
+// the browser.
+func (u *UndoBrowser) HandleEvent(event tcell.Event) bool {
+	e, ok := event.(*tcell.EventKey)
+	if !ok {
+		return false
+	}
+	switch {
+	case e.Key() == tcell.KeyEnter:
+		if !isOpenInOtherView(u.buf, u.view) {
+			// The buffer has been closed since the browser was last shown
+			u.Refresh()
+		} else if y := u.view.Cursor.Y; y < len(u.states) && u.states[y] >= 0 {
+			u.buf.GotoState(u.states[y])
+			for _, t := range tabs {
+				for _, view := range t.Views {
+					if view.Buf == u.buf {
+						view.Relocate()
+					}
+				}
+			}
+			u.Refresh()
+		}
+	case e.Key() == tcell.KeyEscape, e.Key() == tcell.KeyRune && e.Rune() == 'q':
+		u.view.Quit(false)
+	default:
+		return false
+	}
+	return true
+}
+
+// renderUndoTree returns the text which shows an undo tree, the state shown on
+// each line and the line which shows the current state. Each line shows a
+// state and the states made from it without a pause in typing. Older branches
+// are indented below the state they were made from, and the newest branch
+// carries on below them.
+func renderUndoTree(tree *UndoTree, name string) (text string, states []int, currentLine int) {
+	lines := []string{
+		"Undo tree of " + name,
+		"Press enter to go to a state, or q to close",
+		"",
+	}
+	states = []int{-1, -1, -1}
+	applied := tree.onCurrentPath()
+	now := time.Now()
+
+	var show func(n, depth int)
+	show = func(n, depth int) {
+		for {
+			// Follow the states made without a pause
+			last, current := n, n == tree.Current
+			for len(tree.Nodes[last].Children) == 1 && tree.sameStep(last, tree.Nodes[last].Children[0]) {
+				last = tree.Nodes[last].Children[0]
+				current = current || last == tree.Current
+			}
+
+			marker := " "
+			if current {
+				marker = "*"
+				currentLine = len(lines)
+			}
+			node := tree.Nodes[last]
+			when := node.Time.Format("15:04:05")
+			if node.Time.YearDay() != now.YearDay() || node.Time.Year() != now.Year() {
+				when = node.Time.Format("Jan 2 15:04")
+			}
+			lines = append(lines, fmt.Sprintf("%s%s %4d  %-11s  %s",
+				strings.Repeat("  ", depth), marker, last, when, node.describe(applied[last])))
+			states = append(states, last)
+
+			children := node.Children
+			if len(children) == 0 {
+				return
+			}
+			for _, c := range children[:len(children)-1] {
+				show(c, depth+1)
+			}
+			n = children[len(children)-1]
+		}
+	}
+	show(0, 0)
+
+	return strings.Join(lines, "\n"), states, currentLine
+}
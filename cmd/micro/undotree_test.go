@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestUndoTree returns a tree with states made at the given times after
+// the first state, each from the state before it.
+func newTestUndoTree(start time.Time, after ...time.Duration) *UndoTree {
+	tree := NewUndoTree()
+	tree.Nodes[0].Time = start
+	for _, d := range after {
+		tree.add(&TextEvent{EventType: TextEventInsert, Deltas: []Delta{{"x", Loc{}, Loc{}}}, Time: start.Add(d)})
+	}
+	return tree
+}
+
+// undoTestState undoes the current state of a tree the way UndoOneEvent does,
+// without a buffer.
+func undoTestState(tree *UndoTree) {
+	t := tree.undoEvent()
+	t.EventType = -t.EventType
+	tree.undo()
+}
+
+func TestUndoTreePath(t *testing.T) {
+	start := time.Now()
+	tree := newTestUndoTree(start, time.Second, 2*time.Second, 3*time.Second)
+	// Make a branch from state 1
+	tree.undo()
+	tree.undo()
+	tree.add(&TextEvent{EventType: TextEventInsert, Time: start.Add(4 * time.Second)})
+
+	if tree.Current != 4 || tree.Nodes[4].Parent != 1 {
+		t.Fatalf("expected a new state 4 made from state 1, got %d made from %d", tree.Current, tree.Nodes[tree.Current].Parent)
+	}
+	if len(tree.Nodes[1].Children) != 2 || tree.Nodes[1].Redo != 4 {
+		t.Errorf("expected state 1 to keep both branches and redo to the new one, got %v and %d", tree.Nodes[1].Children, tree.Nodes[1].Redo)
+	}
+
+	undo, redo := tree.path(3)
+	if len(undo) != 1 || undo[0] != 4 || len(redo) != 2 || redo[0] != 2 || redo[1] != 3 {
+		t.Errorf("expected to undo [4] and redo [2 3], got %v and %v", undo, redo)
+	}
+	undo, redo = tree.path(0)
+	if len(undo) != 2 || undo[0] != 4 || undo[1] != 1 || len(redo) != 0 {
+		t.Errorf("expected to undo [4 1] and redo nothing, got %v and %v", undo, redo)
+	}
+}
+
+func TestUndoTreeAtTime(t *testing.T) {
+	start := time.Now()
+	tree := newTestUndoTree(start, time.Minute, 2*time.Minute, 3*time.Minute)
+
+	tests := []struct {
+		at       time.Duration
+		expected int
+	}{
+		{-time.Hour, 0},
+		{0, 0},
+		{90 * time.Second, 1},
+		{2 * time.Minute, 2},
+		{time.Hour, 3},
+	}
+	for _, test := range tests {
+		if actual := tree.atTime(start.Add(test.at)); actual != test.expected {
+			t.Errorf("%v: expected state %d, got %d", test.at, test.expected, actual)
+		}
+	}
+}
+
+func TestUndoTreeSameStep(t *testing.T) {
+	start := time.Now()
+	tree := newTestUndoTree(start, time.Second, time.Second+100*time.Millisecond, 5*time.Second)
+
+	if tree.sameStep(0, 1) {
+		t.Errorf("the first state should never be in the same step as another")
+	}
+	if !tree.sameStep(1, 2) {
+		t.Errorf("states made without a pause should be in the same step")
+	}
+	if tree.sameStep(2, 3) {
+		t.Errorf("states made after a pause should be in different steps")
+	}
+}
+
+func TestUndoTreeSerialize(t *testing.T) {
+	tree := newTestUndoTree(time.Now(), time.Second, 2*time.Second)
+	tree.undo()
+	tree.add(&TextEvent{EventType: TextEventRemove, Deltas: []Delta{{"y", Loc{}, Loc{1, 0}}}, Time: time.Now()})
+
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(tree); err != nil {
+		t.Fatal(err)
+	}
+	var decoded UndoTree
+	if err := gob.NewDecoder(&b).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Current != tree.Current || len(decoded.Nodes) != len(tree.Nodes) {
+		t.Fatalf("expected %d states with %d current, got %d with %d current", len(tree.Nodes), tree.Current, len(decoded.Nodes), decoded.Current)
+	}
+	for i, n := range tree.Nodes {
+		d := decoded.Nodes[i]
+		if d.Parent != n.Parent || d.Redo != n.Redo || len(d.Children) != len(n.Children) || (d.Event == nil) != (n.Event == nil) {
+			t.Errorf("state %d: expected %+v, got %+v", i, n, d)
+		}
+	}
+}
+
+func TestRenderUndoTree(t *testing.T) {
+	start := time.Now()
+	tree := newTestUndoTree(start, time.Second, time.Second+100*time.Millisecond, 5*time.Second)
+	// Branch from state 2, which was made together with state 1
+	undoTestState(tree)
+	tree.add(&TextEvent{EventType: TextEventInsert, Deltas: []Delta{{"z", Loc{}, Loc{}}}, Time: start.Add(6 * time.Second)})
+
+	text, states, current := renderUndoTree(tree, "test")
+	lines := strings.Split(text, "\n")
+	if len(lines) != len(states) {
+		t.Fatalf("expected a state for each of the %d lines, got %d", len(lines), len(states))
+	}
+
+	// The original state, states 1 and 2 on one line, the old branch to state 3
+	// indented, and the new branch to state 4.
+	expected := []int{-1, -1, -1, 0, 2, 3, 4}
+	for i, s := range expected {
+		if i >= len(states) || states[i] != s {
+			t.Fatalf("expected states %v, got %v", expected, states)
+		}
+	}
+	if !strings.HasPrefix(lines[5], "  ") || strings.HasPrefix(lines[6], "  ") {
+		t.Errorf("expected only the old branch to be indented, got %q", lines[3:])
+	}
+	if current != 6 || !strings.HasPrefix(lines[6], "*") {
+		t.Errorf("expected the current state to be marked on line 6, got line %d in %q", current, lines[3:])
+	}
+	if !strings.Contains(lines[5], `insert "x"`) || !strings.Contains(lines[6], `insert "z"`) {
+		t.Errorf("expected the undone state to show its change the right way round, got %q", lines[3:])
+	}
+}
+
+func TestEventHandlerGotoState(t *testing.T) {
+	b := NewBufferFromString("abc", "")
+	b.Insert(Loc{3, 0}, "d")
+	b.UndoOneEvent()
+	b.Insert(Loc{0, 0}, "e")
+
+	if b.String() != "eabc" {
+		t.Fatalf("expected 'eabc', got '%s'", b.String())
+	}
+	b.GotoState(1)
+	if b.String() != "abcd" {
+		t.Errorf("expected the undone branch 'abcd', got '%s'", b.String())
+	}
+	b.GotoState(2)
+	if b.String() != "eabc" {
+		t.Errorf("expected to get back to 'eabc', got '%s'", b.String())
+	}
+	b.GotoState(0)
+	if b.String() != "abc" {
+		t.Errorf("expected the original 'abc', got '%s'", b.String())
+	}
+}
@@ -24,6 +24,10 @@ var (
 	vtScratch = ViewType{3, false, true}
 	vtRaw     = ViewType{4, true, true}
 	vtTerm    = ViewType{5, true, true}
+	vtUndo    = ViewType{6, true, true}
+	vtBrowser = ViewType{7, true, true}
+	vtGrep    = ViewType{8, true, true}
+	vtPreview = ViewType{9, true, true}
 )
 
 // The View struct stores information about a view into a buffer.
@@ -105,6 +109,12 @@ type View struct {
 	// Autocomplete function
 	Completer *Completer
 
+	// The snippet whose fields are being filled in
+	snippet *snippetSession
+
+	// The undo tree shown in this view, if it's an undo browser
+	undoBrowser *UndoBrowser
+
 	// Virtual terminal
 	term *Terminal
 }
@@ -272,6 +282,13 @@ func (v *View) OpenBuffer(buf *Buffer) {
 	screen.Clear()
 	v.CloseBuffer()
 	v.Buf = buf
+	if buf.browser != nil {
+		v.Type = vtBrowser
+	} else if buf.results != nil {
+		v.Type = vtGrep
+	} else if v.Type == vtBrowser || v.Type == vtGrep {
+		v.Type = vtDefault
+	}
 	v.Cursor = &buf.Cursor
 	v.Topline = 0
 	v.leftCol = 0
@@ -306,6 +323,16 @@ func (v *View) Open(path string) {
 func (v *View) CloseBuffer() {
 	if v.Buf != nil {
 		v.Buf.Serialize()
+		if !isOpenInOtherView(v.Buf, v) {
+			lspCloseBuffer(v.Buf)
+			unindexBuffer(v.Buf)
+			v.Buf.RemoveBackup()
+			v.Buf.unwatch()
+			v.Buf.closeFile()
+			if v.Buf.results != nil {
+				v.Buf.results.Stop()
+			}
+		}
 	}
 }
 
@@ -514,22 +541,29 @@ func (v *View) MoveToMouseClick(x, y int) {
 	v.Cursor.LastVisualX = v.Cursor.GetVisualX()
 }
 
+// readonlyBindingsList holds parts of the names of the actions which change
+// the contents of a buffer, which aren't run in readonly views
+var readonlyBindingsList = []string{"Delete", "Insert", "Backspace", "Cut", "Play", "Paste", "Move", "Add", "DuplicateLine", "Macro", "ExpandSnippet"}
+
+// changesContents returns whether the action with the given name may change
+// the contents of a buffer
+func changesContents(funcName string) bool {
+	for _, readonlyBindings := range readonlyBindingsList {
+		if strings.Contains(funcName, readonlyBindings) {
+			return true
+		}
+	}
+	return false
+}
+
 // ExecuteActions executes the supplied actions
 func (v *View) ExecuteActions(actions []func(*View, bool) bool) bool {
 	relocate := false
-	readonlyBindingsList := []string{"Delete", "Insert", "Backspace", "Cut", "Play", "Paste", "Move", "Add", "DuplicateLine", "Macro"}
 	for _, action := range actions {
-		readonlyBindingsResult := false
 		funcName := ShortFuncName(action)
 		curv := CurView()
-		if curv.Type.Readonly == true {
-			// check for readonly and if true only let key bindings get called if they do not change the contents.
-			for _, readonlyBindings := range readonlyBindingsList {
-				if strings.Contains(funcName, readonlyBindings) {
-					readonlyBindingsResult = true
-				}
-			}
-		}
+		// check for readonly and if true only let key bindings get called if they do not change the contents.
+		readonlyBindingsResult := curv.Type.Readonly && changesContents(funcName)
 		if !readonlyBindingsResult {
 			// call the key binding
 			relocate = action(curv, true) || relocate
@@ -577,6 +611,16 @@ func (v *View) HandleEvent(event tcell.Event) {
 		return
 	}
 
+	if v.Type == vtUndo && v.undoBrowser.HandleEvent(event) {
+		return
+	}
+	if v.Type == vtBrowser && v.Buf.browser.HandleEvent(v, event) {
+		return
+	}
+	if v.Type == vtGrep && v.Buf.results.HandleEvent(v, event) {
+		return
+	}
+
 	// This bool determines whether the view is relocated at the end of the function
 	// By default it's true because most events should cause a relocate
 	relocate := true
@@ -655,12 +699,6 @@ func (v *View) HandleEvent(event tcell.Event) {
 						v.Buf.Insert(v.Cursor.Loc, string(e.Rune()))
 					}
 
-					// Allow the completer to access the rune.
-					err := v.Completer.Process(e.Rune())
-					if err != nil {
-						TermMessage(err)
-					}
-
 					for pl := range loadedPlugins {
 						_, err := Call(pl+".onRune", string(e.Rune()), v)
 						if err != nil && !strings.HasPrefix(err.Error(), "function does not exist") {
@@ -673,6 +711,13 @@ func (v *View) HandleEvent(event tcell.Event) {
 					}
 				}
 				v.SetCursor(&v.Buf.Cursor)
+
+				// Allow the completer to access the rune. It follows the first
+				// cursor, and its options are used at the others when accepted.
+				err := v.Completer.Process(e.Rune())
+				if err != nil {
+					TermMessage(err)
+				}
 			}
 		}
 	case *tcell.EventPaste:
@@ -696,6 +741,12 @@ func (v *View) HandleEvent(event tcell.Event) {
 
 		button := e.Buttons()
 
+		// Let the autocomplete list take clicks and scrolling over it.
+		x, y := e.Position()
+		if v.Completer.HandleMouse(x-v.lineNumOffset+v.leftCol-v.x, y+v.Topline-v.y, button) {
+			break
+		}
+
 		for key, actions := range bindings {
 			if button == key.buttons && e.Modifiers() == key.modifiers {
 				for _, c := range v.Buf.cursors {
@@ -827,6 +878,10 @@ func (v *View) DisplayView() {
 		v.Relocate()
 	}
 
+	if v.Type == vtUndo {
+		v.undoBrowser.Refresh()
+	}
+
 	// We need to know the string length of the largest line number
 	// so we can pad appropriately when displaying line numbers
 	maxLineNumLength := len(strconv.Itoa(v.Buf.NumLines))
@@ -866,6 +921,15 @@ func (v *View) DisplayView() {
 
 	v.cellview.Draw(v.Buf, top, height, left, width-v.lineNumOffset)
 
+	var matches *searchMatches
+	if globalSettings["hlsearch"].(bool) {
+		matches = v.Buf.searchMatches()
+	}
+	searchStyle := defStyle.Underline(true)
+	if style, ok := colorscheme["search"]; ok {
+		searchStyle = style
+	}
+
 	screenX := v.x
 	realLineN := top - 1
 	visualLineN := 0
@@ -981,6 +1045,11 @@ func (v *View) DisplayView() {
 			screenX++
 		}
 
+		var lineMatches [][2]int
+		if matches != nil && realLineN < v.Buf.NumLines {
+			lineMatches = matches.line(v.Buf, realLineN)
+		}
+
 		var lastChar *Char
 		cursorSet := false
 		for _, char := range line {
@@ -995,6 +1064,14 @@ func (v *View) DisplayView() {
 				}
 
 				charLoc := char.realLoc
+				matched := false
+				for _, m := range lineMatches {
+					if charLoc.X >= m[0] && charLoc.X < m[1] {
+						lineStyle = searchStyle
+						matched = true
+						break
+					}
+				}
 				for _, c := range v.Buf.cursors {
 					v.SetCursor(c)
 					if v.Cursor.HasSelection() &&
@@ -1011,7 +1088,7 @@ func (v *View) DisplayView() {
 				v.SetCursor(&v.Buf.Cursor)
 
 				if v.Buf.Settings["cursorline"].(bool) && tabs[curTab].CurView == v.Num &&
-					!v.Cursor.HasSelection() && v.Cursor.Y == realLineN {
+					!v.Cursor.HasSelection() && v.Cursor.Y == realLineN && !matched {
 					style := GetColor("cursor-line")
 					fg, _, _ := style.Decompose()
 					lineStyle = lineStyle.Background(fg)
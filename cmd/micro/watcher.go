@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// The files of open buffers are watched for changes by other programs. Their
+// directories are watched rather than the files themselves, since many
+// programs save a file by writing a new one and renaming it over the old one,
+// which would end a watch on the old file. Buffers with no unsaved changes are
+// reloaded, and otherwise the user is asked whether to merge the changes.
+
+// Events for a file are collected for this long before it's checked, since a
+// program which saves it may take several writes to do so
+const watchDelay = 100 * time.Millisecond
+
+var (
+	fileWatcher *fsnotify.Watcher
+	// The number of watched files in each watched directory
+	watchedDirs = make(map[string]int)
+)
+
+// InitWatcher starts watching for changes to files. If it can't, open files
+// are only checked for changes when there's input.
+func InitWatcher() {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		messenger.AddLog("Can't watch for changes to files: ", err.Error())
+		return
+	}
+	fileWatcher = w
+}
+
+// WatchFiles passes changes to watched files to the main loop until the
+// watcher is closed
+func WatchFiles() {
+	if fileWatcher == nil {
+		return
+	}
+	changed := make(map[string]bool)
+	var delay <-chan time.Time
+	for {
+		select {
+		case e, ok := <-fileWatcher.Events:
+			if !ok {
+				return
+			}
+			changed[filepath.Clean(e.Name)] = true
+			if delay == nil {
+				delay = time.After(watchDelay)
+			}
+		case err, ok := <-fileWatcher.Errors:
+			if !ok {
+				return
+			}
+			callbacks <- func() {
+				messenger.AddLog("Error watching files: ", err.Error())
+			}
+		case <-delay:
+			for name := range changed {
+				name := name
+				callbacks <- func() { FileChanged(name) }
+			}
+			changed = make(map[string]bool)
+			delay = nil
+		}
+	}
+}
+
+// watch starts watching the buffer's file, and stops watching the one it had
+// before if it was saved somewhere else
+func (b *Buffer) watch() {
+	if fileWatcher == nil || b.AbsPath == b.watched {
+		return
+	}
+	b.unwatch()
+	if b.Path == "" {
+		return
+	}
+	dir := filepath.Dir(b.AbsPath)
+	if watchedDirs[dir] == 0 {
+		if err := fileWatcher.Add(dir); err != nil {
+			messenger.AddLog("Can't watch ", dir, ": ", err.Error())
+			return
+		}
+	}
+	watchedDirs[dir]++
+	b.watched = b.AbsPath
+}
+
+// unwatch stops watching the buffer's file
+func (b *Buffer) unwatch() {
+	if b.watched == "" {
+		return
+	}
+	dir := filepath.Dir(b.watched)
+	if watchedDirs[dir]--; watchedDirs[dir] == 0 {
+		delete(watchedDirs, dir)
+		fileWatcher.Remove(dir)
+	}
+	b.watched = ""
+}
+
+// FileChanged checks the buffers of a file which was written to by another
+// program
+func FileChanged(path string) {
+	checked := make(map[*Buffer]bool)
+	for _, t := range tabs {
+		for _, v := range t.Views {
+			if b := v.Buf; b.watched == path && !checked[b] {
+				checked[b] = true
+				b.fileChanged()
+			}
+		}
+	}
+}
+
+// readFile reads the buffer's file again
+func (b *Buffer) readFile() (*LineArray, error) {
+	data, err := ioutil.ReadFile(b.Path)
+	if err == nil {
+		data, err = b.decode(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewLineArray(int64(len(data)), bytes.NewReader(data)), nil
+}
+
+// fileChanged reloads the buffer if its file has changed, or asks the user
+// what to do if the buffer has unsaved changes too
+func (b *Buffer) fileChanged() {
+	modTime, ok := GetModTime(b.Path)
+	if !ok || modTime == b.ModTime {
+		return
+	}
+	if !b.Modified() {
+		b.ReOpen()
+		messenger.Message("Reloaded ", b.GetName(), ", which was changed by another program")
+		return
+	}
+	b.ModTime = modTime
+
+	if b.LargeFile {
+		// The lines which haven't been edited are still read from the file,
+		// and saving fails if they've changed, rather than losing them
+		choice, canceled := messenger.YesNoPrompt(b.GetName() + " was changed by another program. Reload it and lose your changes? Keeping them loses the other program's changes when you save, and if the lines you haven't edited were changed, it can't be saved until it's reloaded. (y,n)")
+		messenger.Reset()
+		messenger.Clear()
+		if choice && !canceled {
+			b.ReOpen()
+		}
+		return
+	}
+
+	file, err := b.readFile()
+	if err != nil {
+		messenger.Error(err.Error())
+		return
+	}
+	if file.String() == b.saved.String() {
+		// Only the modification time changed
+		return
+	}
+
+	choice, canceled := messenger.LetterPrompt(b.GetName()+" was changed by another program. Merge the changes with yours (m), reload it and lose yours (r) or keep yours (k)?", 'm', 'r', 'k')
+	messenger.Reset()
+	messenger.Clear()
+	if canceled {
+		return
+	}
+	switch choice {
+	case 'm':
+		b.merge(file)
+	case 'r':
+		b.ReOpen()
+	}
+}
+
+// merge merges the changes in the buffer's file into the buffer, as a change
+// which can be undone
+func (b *Buffer) merge(file *LineArray) {
+	text, conflicts := merge3(b.saved.String(), b.String(), file.String())
+	b.ApplyDiff(text)
+	b.Cursor.Relocate()
+
+	// The buffer now has unsaved changes to the new text of the file
+	b.saved = file
+	h := md5.New()
+	file.write(h, b.lineEnding())
+	h.Sum(b.origHash[:0])
+	b.IsModified = true
+
+	if conflicts > 0 {
+		messenger.Error("Merged the changes to ", b.GetName(), ". Changes which conflict in ", conflicts, " places are marked with <<<<<<< and >>>>>>>")
+	} else {
+		messenger.Message("Merged the changes to ", b.GetName())
+	}
+}
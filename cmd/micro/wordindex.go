@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zyedidia/micro/cmd/micro/optionprovider"
+)
+
+// wordIndex holds the words in every open buffer, and in the project files when
+// the "autocompleteproject" option is on. The generic completion provider offers
+// words from it, so that names defined in other files can be completed.
+var wordIndex = optionprovider.NewWordIndex()
+
+// indexedBuffers stores the name that each buffer was added to the index with.
+// The entry is replaced when a buffer is indexed again, which disables the change
+// listener added the first time, since listeners can't be removed.
+var indexedBuffers = make(map[*Buffer]*indexedBuffer)
+
+type indexedBuffer struct {
+	name string
+}
+
+// indexedExtensions stores the file extensions which have been searched for in
+// the working directory, so that the project is only scanned once for each.
+var indexedExtensions = make(map[string]bool)
+
+// The greatest number of project files added to the index for each extension.
+const maxProjectFiles = 2000
+
+// indexBuffer adds a buffer to the word index, and keeps the index up to date
+// as the buffer is edited.
+func indexBuffer(b *Buffer) {
+	if _, ok := indexedBuffers[b]; ok {
+		return
+	}
+	ib := &indexedBuffer{name: b.AbsPath}
+	if ib.name == "" {
+		ib.name = fmt.Sprintf("buffer:%p", b)
+	}
+	indexedBuffers[b] = ib
+	wordIndex.Open(ib.name, b.String())
+
+	b.AddChangeListener(func(start, end Loc, removed, inserted string) {
+		if indexedBuffers[b] != ib {
+			return
+		}
+		// The lines from start.Y to end.Y have been replaced by the lines holding
+		// the inserted text.
+		lines := make([]string, NumOccurrences(inserted, '\n')+1)
+		for i := range lines {
+			lines[i] = b.Line(start.Y + i)
+		}
+		wordIndex.Change(ib.name, start.Y, end.Y+1, lines)
+	})
+
+	if globalSettings["autocompleteproject"].(bool) {
+		indexProject(filepath.Ext(b.AbsPath))
+	}
+}
+
+// unindexBuffer removes a buffer from the word index.
+func unindexBuffer(b *Buffer) {
+	if ib, ok := indexedBuffers[b]; ok {
+		delete(indexedBuffers, b)
+		wordIndex.Close(ib.name)
+	}
+}
+
+// indexProject adds the files in the working directory which have the extension
+// ext to the word index. The files are read in the background.
+func indexProject(ext string) {
+	if ext == "" || indexedExtensions[ext] {
+		return
+	}
+	indexedExtensions[ext] = true
+	wd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	go func() {
+		if err := wordIndex.AddDirectory(wd, ext, maxProjectFiles); err != nil {
+			RunInMainLoop(func() {
+				messenger.AddLog("completer: failed to index ", wd, ": ", err.Error())
+			})
+		}
+	}()
+}
+
+// isOpenInOtherView returns whether a buffer is shown by any view apart from v.
+func isOpenInOtherView(b *Buffer, v *View) bool {
+	for _, t := range tabs {
+		for _, other := range t.Views {
+			if other != v && other.Buf == b {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"syscall"
+)
+
+// copyXattrs copies the extended attributes of a file to another one, which
+// include its access control list. Attributes which can't be copied, such as
+// ones which need privileges to set, are left out.
+func copyXattrs(from, to string) {
+	size, err := syscall.Listxattr(from, nil)
+	if err != nil || size == 0 {
+		return
+	}
+	names := make([]byte, size)
+	if size, err = syscall.Listxattr(from, names); err != nil {
+		return
+	}
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attr := string(name)
+		n, err := syscall.Getxattr(from, attr, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(from, attr, value); err != nil {
+			continue
+		}
+		syscall.Setxattr(to, attr, value[:n], 0)
+	}
+}
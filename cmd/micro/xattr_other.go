@@ -0,0 +1,5 @@
+// +build !linux
+
+package main
+
+func copyXattrs(from, to string) {}